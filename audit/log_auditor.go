@@ -16,17 +16,69 @@ func NewLogAuditor(logger *slog.Logger) *LogAuditor {
 
 // AuditRequest logs the request using structured logging
 func (a *LogAuditor) AuditRequest(req Request) {
-	if req.Allowed {
-		a.logger.Info("ALLOW",
-			"method", req.Method,
-			"url", req.URL,
-			"host", req.Host,
-			"rule", req.Rule)
-	} else {
-		a.logger.Warn("DENY",
-			"method", req.Method,
-			"url", req.URL,
-			"host", req.Host,
-		)
+	args := []any{
+		"method", req.Method,
+		"url", req.URL,
+		"host", req.Host,
+		"rule", req.Rule,
 	}
+	// Only structured rules have an id and tags.
+	if req.RuleID != "" {
+		args = append(args, "rule_id", req.RuleID)
+	}
+	if len(req.RuleTags) > 0 {
+		args = append(args, "rule_tags", req.RuleTags)
+	}
+	if req.ExpiredRule != "" {
+		args = append(args, "expired_rule", req.ExpiredRule)
+	}
+	if req.Approved {
+		args = append(args, "approved", true)
+	}
+	if req.Upgrade != "" {
+		args = append(args, "upgrade", req.Upgrade)
+	}
+	if req.Passthrough {
+		args = append(args, "passthrough", true)
+	}
+	if req.RateLimited && req.Action == ActionForward {
+		args = append(args, "rate_limited", true)
+	}
+
+	switch {
+	case req.Allowed:
+		a.logger.Info("ALLOW", args...)
+	case req.Learned:
+		a.logger.Info("LEARN", args...)
+	case req.Action == ActionForward:
+		// Monitor mode: the rules denied the request, but it went through.
+		a.logger.Warn("WOULD-DENY", args...)
+	case req.RateLimited:
+		a.logger.Warn("RATE-LIMITED", args...)
+	default:
+		a.logger.Warn("DENY", args...)
+	}
+}
+
+// AuditPolicyChange logs the change of policy using structured logging
+func (a *LogAuditor) AuditPolicyChange(change PolicyChange) {
+	a.logger.Info("POLICY-RELOADED",
+		"old_hash", change.OldHash,
+		"new_hash", change.NewHash,
+		"rules", change.Rules,
+		"reason", change.Reason,
+	)
+}
+
+// AuditUpgrade logs the closed upgraded connection using structured logging
+func (a *LogAuditor) AuditUpgrade(upgrade Upgrade) {
+	a.logger.Info("UPGRADE-CLOSED",
+		"method", upgrade.Method,
+		"url", upgrade.URL,
+		"host", upgrade.Host,
+		"protocol", upgrade.Protocol,
+		"duration", upgrade.Duration,
+		"bytes_sent", upgrade.BytesSent,
+		"bytes_received", upgrade.BytesReceived,
+	)
 }
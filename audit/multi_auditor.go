@@ -26,6 +26,24 @@ func (m *MultiAuditor) AuditRequest(req Request) {
 	}
 }
 
+// AuditPolicyChange sends the change to the wrapped auditors that record policy changes.
+func (m *MultiAuditor) AuditPolicyChange(change PolicyChange) {
+	for _, a := range m.auditors {
+		if pa, ok := a.(PolicyAuditor); ok {
+			pa.AuditPolicyChange(change)
+		}
+	}
+}
+
+// AuditUpgrade sends the upgrade to the wrapped auditors that record upgrades.
+func (m *MultiAuditor) AuditUpgrade(upgrade Upgrade) {
+	for _, a := range m.auditors {
+		if ua, ok := a.(UpgradeAuditor); ok {
+			ua.AuditUpgrade(upgrade)
+		}
+	}
+}
+
 // SetupAuditor creates and configures the appropriate auditors based on the
 // provided configuration. It always includes a LogAuditor for stderr logging,
 // and conditionally adds a SocketAuditor if audit logs are enabled and the
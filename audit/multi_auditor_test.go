@@ -142,3 +142,53 @@ func TestMultiAuditor_AuditRequest(t *testing.T) {
 		t.Error("expected second auditor to be called")
 	}
 }
+
+type mockPolicyAuditor struct {
+	mockAuditor
+	changes []PolicyChange
+}
+
+func (m *mockPolicyAuditor) AuditPolicyChange(change PolicyChange) {
+	m.changes = append(m.changes, change)
+}
+
+func TestMultiAuditor_AuditPolicyChange(t *testing.T) {
+	t.Parallel()
+
+	policyAuditor := &mockPolicyAuditor{}
+	// Auditors that don't record policy changes are skipped.
+	multi := NewMultiAuditor(&mockAuditor{}, policyAuditor)
+	multi.AuditPolicyChange(PolicyChange{OldHash: "old", NewHash: "new", Rules: 2, Reason: "SIGHUP"})
+
+	if len(policyAuditor.changes) != 1 {
+		t.Fatalf("expected 1 policy change, got %d", len(policyAuditor.changes))
+	}
+	if got := policyAuditor.changes[0]; got.OldHash != "old" || got.NewHash != "new" {
+		t.Errorf("unexpected policy change: %+v", got)
+	}
+}
+
+type mockUpgradeAuditor struct {
+	mockAuditor
+	upgrades []Upgrade
+}
+
+func (m *mockUpgradeAuditor) AuditUpgrade(upgrade Upgrade) {
+	m.upgrades = append(m.upgrades, upgrade)
+}
+
+func TestMultiAuditor_AuditUpgrade(t *testing.T) {
+	t.Parallel()
+
+	upgradeAuditor := &mockUpgradeAuditor{}
+	// Auditors that don't record upgrades are skipped.
+	multi := NewMultiAuditor(&mockAuditor{}, upgradeAuditor)
+	multi.AuditUpgrade(Upgrade{Method: "GET", URL: "https://example.com/socket", Protocol: "websocket", BytesSent: 5})
+
+	if len(upgradeAuditor.upgrades) != 1 {
+		t.Fatalf("expected 1 upgrade, got %d", len(upgradeAuditor.upgrades))
+	}
+	if got := upgradeAuditor.upgrades[0]; got.Protocol != "websocket" || got.BytesSent != 5 {
+		t.Errorf("unexpected upgrade: %+v", got)
+	}
+}
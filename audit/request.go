@@ -1,19 +1,105 @@
 package audit
 
+import "time"
+
 type Auditor interface {
 	AuditRequest(req Request)
 }
 
+// Action is what the proxy did with a request.
+type Action string
+
+const (
+	ActionForward Action = "forward"
+	ActionBlock   Action = "block"
+)
+
 // Request represents information about an HTTP request for auditing
 type Request struct {
-	Method  string
-	URL     string // The fully qualified request URL (scheme, domain, optional path).
-	Host    string
+	Method string
+	URL    string // The fully qualified request URL (scheme, domain, optional path).
+	Host   string
+	// Allowed is the policy decision. The proxy acts on it unless it only
+	// monitors the policy or learns one, see Action.
 	Allowed bool
-	Rule    string // The rule that matched (if any)
+	// Action is what the proxy actually did with the request. It forwards
+	// requests the rules deny with --enforcement=monitor and --learn.
+	Action Action
+	// RateLimited is set when the request matched an allow rule but exceeded
+	// its rate limit or quota. Allowed is false then.
+	RateLimited bool
+	// Approved is set when no rule allowed the request, but the person running
+	// boundary did when asked (--on-deny=ask).
+	Approved bool
+	// Upgrade is the protocol the client asked to switch to, e.g.
+	// "websocket", if it asked for one. How the upgraded connection went is
+	// audited separately once it closes, see UpgradeAuditor.
+	Upgrade string
+	// Passthrough is set for a TLS connection that was passed through to its
+	// server without being decrypted (inspect=false). Its requests aren't
+	// seen, so it is audited once, as a CONNECT to the server name the client
+	// sent, with no path.
+	Passthrough bool
+	// Learned is set when the rules denied the request but it was forwarded
+	// anyway, because boundary learns the policy (--learn). Allowed is false.
+	Learned bool
+	// Rule is the allow rule that matched, or the deny rule that blocked the
+	// request (if any). For rate limited requests, it is the rule whose
+	// limit was exceeded. For approved requests, it is the rule the approval
+	// added, or empty if the request was approved once only.
+	Rule string
+	// RuleID and RuleTags are the id and tags of that rule, when it was
+	// written as a structured rule in the config file.
+	RuleID   string
+	RuleTags []string
+	// ExpiredRule is the allow rule that would have allowed a request denied by
+	// default, had it not expired (if any).
+	ExpiredRule string
 
 	// SequenceNumber is the sequence number assigned to this audit event
 	// by the proxy. It is monotonically increasing within a session and
 	// is shared with any injected HTTP header so both carry the same value.
 	SequenceNumber int32
 }
+
+// PolicyAuditor is implemented by auditors that also record changes of the
+// policy a running proxy enforces, such as a reload of the config file.
+type PolicyAuditor interface {
+	AuditPolicyChange(change PolicyChange)
+}
+
+// PolicyChange represents the proxy switching to a new set of rules.
+type PolicyChange struct {
+	// OldHash and NewHash identify the policy before and after the change,
+	// see rulesengine.Engine.Hash.
+	OldHash string
+	NewHash string
+	// Rules is the number of allow and deny rules of the new policy.
+	Rules int
+	// Reason is what triggered the change, e.g. "SIGHUP".
+	Reason string
+}
+
+// UpgradeAuditor is implemented by auditors that also record the connections
+// that switched protocols after an allowed upgrade request, such as WebSocket
+// connections.
+type UpgradeAuditor interface {
+	AuditUpgrade(upgrade Upgrade)
+}
+
+// Upgrade represents an upgraded connection that has closed.
+type Upgrade struct {
+	Method string
+	URL    string
+	Host   string
+	// Protocol is what the connection switched to, e.g. "websocket".
+	Protocol string
+	// Duration is how long the connection was open after the upgrade.
+	Duration time.Duration
+	// BytesSent and BytesReceived count what went from the client to the
+	// upstream server and back after the upgrade.
+	BytesSent     int64
+	BytesReceived int64
+	// SequenceNumber is that of the audited upgrade request.
+	SequenceNumber int32
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -27,7 +28,9 @@ const (
 // workspace agent's boundary log proxy socket. It queues logs and sends
 // them in batches using a batch size and timer. The internal queue operates
 // as a FIFO i.e., logs are sent in the order they are received and dropped
-// if the queue is full.
+// if the queue is full. It doesn't implement PolicyAuditor or
+// UpgradeAuditor: the agent protocol has no message for policy changes or
+// upgraded connections yet.
 type SocketAuditor struct {
 	dial               func() (net.Conn, error)
 	logger             *slog.Logger
@@ -73,10 +76,18 @@ func (s *SocketAuditor) AuditRequest(req Request) {
 		Method: req.Method,
 		Url:    req.URL,
 	}
-	// Only include the matched rule for allowed requests. Boundary is deny by
-	// default, so rules are what allow requests.
-	if req.Allowed {
-		httpReq.MatchedRule = req.Rule
+	// Boundary is deny by default, so the rule is empty for requests that were
+	// denied because nothing matched. Denied requests carry a rule only when an
+	// explicit deny rule blocked them.
+	// The agent protocol has no fields for the rate limited outcome, approvals,
+	// learning, the action taken, upgrades, passthrough and expired rule yet, so
+	// those only reach the stderr logs. A request over the limit of its allow
+	// rule is sent as denied without a rule, since with the allow rule it would
+	// look like a request that rule blocked. The rule id and tags go along with
+	// the rule, see matchedRule. Allowed is the policy decision, so in monitor
+	// mode the agent sees the requests the rules would have denied.
+	if !req.RateLimited {
+		httpReq.MatchedRule = matchedRule(req)
 	}
 
 	log := &agentproto.BoundaryLog{
@@ -94,6 +105,25 @@ func (s *SocketAuditor) AuditRequest(req Request) {
 	}
 }
 
+// matchedRule returns the rule of req as the agent is sent it. The agent
+// protocol has no fields for the id and tags of the rule, so they follow the
+// rule in parentheses, as in "domain=registry.npmjs.org (id=npm, tags=web,ci)".
+// Only rules from the config file have them, rules without either are sent
+// as they are.
+func matchedRule(req Request) string {
+	var meta []string
+	if req.RuleID != "" {
+		meta = append(meta, "id="+req.RuleID)
+	}
+	if len(req.RuleTags) > 0 {
+		meta = append(meta, "tags="+strings.Join(req.RuleTags, ","))
+	}
+	if req.Rule == "" || len(meta) == 0 {
+		return req.Rule
+	}
+	return req.Rule + " (" + strings.Join(meta, ", ") + ")"
+}
+
 // flushErr represents an error from flush, distinguishing between
 // permanent errors (bad data) and transient errors (network issues).
 type flushErr struct {
@@ -93,6 +93,98 @@ func TestSocketAuditor_AuditRequest_AllowIncludesRule(t *testing.T) {
 	}
 }
 
+func TestSocketAuditor_AuditRequest_RuleMetadata(t *testing.T) {
+	t.Parallel()
+
+	auditor := setupSocketAuditor(t)
+
+	for _, req := range []Request{
+		{Method: "GET", URL: "https://registry.npmjs.org", Allowed: true, Rule: "domain=registry.npmjs.org", RuleID: "npm", RuleTags: []string{"web", "ci"}},
+		{Method: "GET", URL: "https://pypi.org", Allowed: true, Rule: "domain=pypi.org", RuleID: "pypi"},
+		{Method: "GET", URL: "https://github.com", Allowed: true, Rule: "domain=github.com", RuleTags: []string{"scm"}},
+		{Method: "GET", URL: "https://example.com", Allowed: true, Rule: "domain=example.com"},
+	} {
+		auditor.AuditRequest(req)
+	}
+
+	for _, want := range []string{
+		"domain=registry.npmjs.org (id=npm, tags=web,ci)",
+		"domain=pypi.org (id=pypi)",
+		"domain=github.com (tags=scm)",
+		"domain=example.com",
+	} {
+		select {
+		case log := <-auditor.logCh:
+			if got := log.GetHttpRequest().MatchedRule; got != want {
+				t.Errorf("expected MatchedRule=%s, got %s", want, got)
+			}
+		default:
+			t.Fatal("expected log in channel, got none")
+		}
+	}
+}
+
+func TestSocketAuditor_AuditRequest_RateLimitedOmitsRule(t *testing.T) {
+	t.Parallel()
+
+	auditor := setupSocketAuditor(t)
+
+	auditor.AuditRequest(Request{
+		Method:      "GET",
+		URL:         "https://api.example.com",
+		Host:        "api.example.com",
+		Allowed:     false,
+		RateLimited: true,
+		Rule:        "domain=api.example.com rate=30/m",
+	})
+
+	select {
+	case log := <-auditor.logCh:
+		if log.Allowed {
+			t.Errorf("expected Allowed=false, got %v", log.Allowed)
+		}
+		httpReq := log.GetHttpRequest()
+		if httpReq == nil {
+			t.Fatal("expected HttpRequest, got nil")
+		}
+		if httpReq.MatchedRule != "" {
+			t.Errorf("expected no MatchedRule, got %s", httpReq.MatchedRule)
+		}
+	default:
+		t.Fatal("expected log in channel, got none")
+	}
+}
+
+func TestSocketAuditor_AuditRequest_DenyIncludesRule(t *testing.T) {
+	t.Parallel()
+
+	auditor := setupSocketAuditor(t)
+
+	auditor.AuditRequest(Request{
+		Method:  "GET",
+		URL:     "https://gist.github.com",
+		Host:    "gist.github.com",
+		Allowed: false,
+		Rule:    "domain=gist.github.com",
+	})
+
+	select {
+	case log := <-auditor.logCh:
+		if log.Allowed {
+			t.Errorf("expected Allowed=false, got %v", log.Allowed)
+		}
+		httpReq := log.GetHttpRequest()
+		if httpReq == nil {
+			t.Fatal("expected HttpRequest, got nil")
+		}
+		if httpReq.MatchedRule != "domain=gist.github.com" {
+			t.Errorf("expected MatchedRule=domain=gist.github.com, got %s", httpReq.MatchedRule)
+		}
+	default:
+		t.Fatal("expected log in channel, got none")
+	}
+}
+
 func TestSocketAuditor_AuditRequest_DropsWhenFull(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/coder/boundary/policy"
+	"github.com/coder/serpent"
+)
+
+// approveCommand returns the `approve` subcommand, which answers the questions a run with --on-deny=ask and
+// --ask-socket puts to its socket.
+func approveCommand() *serpent.Command {
+	var socket serpent.String
+	return &serpent.Command{
+		Use:   "approve",
+		Short: "Allow or deny the requests a run with --on-deny=ask holds",
+		Long: `Connects to the --ask-socket of a boundary run with --on-deny=ask and asks, for each request no rule allows, whether to allow it once, for the rest of the session, persist its rule to the config file, or deny it. Use it when the jailed command needs the terminal to itself. It first asks for the token boundary printed on its stderr when it started. Only one connection at a time gets the questions.
+
+Examples:
+  boundary --on-deny=ask --ask-socket /tmp/boundary.sock --config ./boundary.yaml -- claude
+  boundary approve --socket /tmp/boundary.sock`,
+		// --socket is checked by the handler rather than marked required, since serpent checks required options
+		// before runAfterDash can tell that `boundary -- approve` isn't this command.
+		Options: append(serpent.OptionSet{
+			{
+				Flag:        "socket",
+				Env:         "BOUNDARY_ASK_SOCKET",
+				Description: "Path of the --ask-socket of the boundary run.",
+				Value:       &socket,
+			},
+		}, ignoredOptions()...),
+		Middleware: serpent.RequireNArgs(0),
+		Handler: func(inv *serpent.Invocation) error {
+			if socket.Value() == "" {
+				return errors.New("--socket is required")
+			}
+			return policy.AnswerQuestions(socket.Value(), inv.Stdin, inv.Stdout)
+		},
+	}
+}
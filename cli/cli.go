@@ -36,11 +36,23 @@ func NewCommand(version string) *serpent.Command {
   # Monitor all requests to specific domains (allow only those)
   boundary --allow "domain=github.com path=/api/issues/*" --allow "method=GET,HEAD domain=github.com" -- npm install
 
+  # Allow installing npm packages with the built-in preset
+  boundary --allow-preset npm -- npm install
+
+  # Allow all of GitHub's subdomains except gists
+  boundary --allow "domain=*.github.com" --deny "domain=gist.github.com" -- git pull
+
   # Use allowlist from config file with additional CLI allow rules
   boundary --allow "domain=example.com" -- curl https://example.com
 
   # Block everything by default (implicit)
 
+  # Explain why a request would be allowed or denied
+  boundary explain --allow "domain=github.com" GET https://api.github.com/repos
+
+  # Check the configured rules for shadowed, redundant and overly broad entries
+  boundary lint --config ./boundary.yaml
+
   # Enable session correlation inside a Coder workspace
   boundary --enable-session-correlation \
     --allow "domain=dev.coder.com" -- python train.py
@@ -63,151 +75,19 @@ func NewCommand(version string) *serpent.Command {
 // *top level* serpent command. We are creating this split to make it easier to integrate into the coder
 // CLI if needed.
 func BaseCommand(version string) *serpent.Command {
-	cliConfig := config.CliConfig{}
+	cliConfig := newCliConfig()
 	var showVersion serpent.Bool
 
-	// Set default config path if file exists - serpent will load it automatically
-	if home, err := os.UserHomeDir(); err == nil {
-		defaultPath := filepath.Join(home, ".config", "coder_boundary", "config.yaml")
-		if _, err := os.Stat(defaultPath); err == nil {
-			cliConfig.Config = serpent.YAMLConfigPath(defaultPath)
-		}
-	}
-
-	return &serpent.Command{
+	cmd := &serpent.Command{
 		Use:   "boundary",
 		Short: "Network isolation tool for monitoring and restricting HTTP/HTTPS requests",
 		Long:  `boundary creates an isolated network environment for target processes, intercepting HTTP/HTTPS traffic through a transparent proxy that enforces user-defined allow rules.`,
-		Options: []serpent.Option{
-			{
-				Flag:        "config",
-				Env:         "BOUNDARY_CONFIG",
-				Description: "Path to YAML config file.",
-				Value:       &cliConfig.Config,
-				YAML:        "",
-			},
-			{
-				Flag:        "allow",
-				Env:         "BOUNDARY_ALLOW",
-				Description: "Allow rule (repeatable). These are merged with allowlist from config file. Format: \"pattern\" or \"METHOD[,METHOD] pattern\".",
-				Value:       &cliConfig.AllowStrings,
-				YAML:        "", // CLI only, not loaded from YAML
-			},
-			{
-				Flag:        "", // No CLI flag, YAML only
-				Description: "Allowlist rules from config file (YAML only).",
-				Value:       &cliConfig.AllowListStrings,
-				YAML:        "allowlist",
-			},
-			{
-				Flag:        "log-level",
-				Env:         "BOUNDARY_LOG_LEVEL",
-				Description: "Set log level (error, warn, info, debug).",
-				Default:     "warn",
-				Value:       &cliConfig.LogLevel,
-				YAML:        "log_level",
-			},
-			{
-				Flag:        "log-dir",
-				Env:         "BOUNDARY_LOG_DIR",
-				Description: "Set a directory to write logs to rather than stderr.",
-				Value:       &cliConfig.LogDir,
-				YAML:        "log_dir",
-			},
-			{
-				Flag:        "proxy-port",
-				Env:         "PROXY_PORT",
-				Description: "Set a port for HTTP proxy.",
-				Default:     "8080",
-				Value:       &cliConfig.ProxyPort,
-				YAML:        "proxy_port",
-			},
-			{
-				Flag:        "pprof",
-				Env:         "BOUNDARY_PPROF",
-				Description: "Enable pprof profiling server.",
-				Value:       &cliConfig.PprofEnabled,
-				YAML:        "pprof_enabled",
-			},
-			{
-				Flag:        "pprof-port",
-				Env:         "BOUNDARY_PPROF_PORT",
-				Description: "Set port for pprof profiling server.",
-				Default:     "6060",
-				Value:       &cliConfig.PprofPort,
-				YAML:        "pprof_port",
-			},
-			{
-				Flag:        "jail-type",
-				Env:         "BOUNDARY_JAIL_TYPE",
-				Description: "Jail type to use for network isolation. Options: nsjail (default), landjail.",
-				Default:     "nsjail",
-				Value:       &cliConfig.JailType,
-				YAML:        "jail_type",
-			},
-			{
-				Flag:        "use-real-dns",
-				Env:         "BOUNDARY_USE_REAL_DNS",
-				Description: "Use real DNS in the jail instead of the dummy DNS (allows DNS exfiltration). Default: false.",
-				Value:       &cliConfig.UseRealDNS,
-				YAML:        "use_real_dns",
-			},
-			{
-				Flag:        "no-user-namespace",
-				Env:         "BOUNDARY_NO_USER_NAMESPACE",
-				Description: "Do not create a user namespace. Use in restricted environments that disallow user NS (e.g. Bottlerocket in EKS auto-mode).",
-				Value:       &cliConfig.NoUserNamespace,
-				YAML:        "no_user_namespace",
-			},
-			{
-				Flag:        "disable-audit-logs",
-				Env:         "DISABLE_AUDIT_LOGS",
-				Description: "Disable sending of audit logs to the workspace agent when set to true.",
-				Value:       &cliConfig.DisableAuditLogs,
-				YAML:        "disable_audit_logs",
-			},
-			{
-				Flag:        "log-proxy-socket-path",
-				Description: "Path to the socket where the boundary log proxy server listens for audit logs.",
-				// Important: this default must be the same default path used by the
-				// workspace agent to ensure agreement of the default socket path without
-				// explicit configuration.
-				Default: boundarylogproxy.DefaultSocketPath(),
-				// Important: this must be the same variable name used by the workspace agent
-				// to allow a single environment variable to configure both boundary and the
-				// workspace agent.
-				Env:   "CODER_AGENT_BOUNDARY_LOG_PROXY_SOCKET_PATH",
-				Value: &cliConfig.LogProxySocketPath,
-				YAML:  "", // CLI only, not loaded from YAML
-			},
-			{
-				Flag:        "version",
-				Description: "Print version information and exit.",
-				Value:       &showVersion,
-				YAML:        "", // CLI only
-			},
-			// Session correlation header injection options.
-			{
-				Flag:        "enable-session-correlation",
-				Env:         "BOUNDARY_SESSION_CORRELATION_ENABLED",
-				Description: "Enable session correlation header injection. When no inject targets are configured, the target is auto-derived from CODER_AGENT_URL (set automatically inside Coder workspaces). Disable for deployments without Coder AI Gateway in front.",
-				Value:       &cliConfig.SessionCorrelationEnabled,
-				YAML:        "session_correlation_enabled",
-			},
-			{
-				Flag:        "session-id-inject-target",
-				Env:         "BOUNDARY_SESSION_ID_INJECT_TARGET",
-				Description: `Inject target for session correlation headers. Repeat the flag once per target; each value describes exactly one target. Format: "domain=<host> [path=<glob>]". Example: --session-id-inject-target "domain=prod.coder.com path=/api/v2/aibridge/*".`,
-				Value:       &cliConfig.InjectSessionIDTarget,
-				YAML:        "", // CLI only, YAML uses session_id_inject_targets.
-			},
-			{
-				Flag:        "", // No CLI flag, YAML only.
-				Description: "Inject targets from config file (YAML only).",
-				Value:       &cliConfig.InjectSessionIDTargets,
-				YAML:        "session_id_inject_targets",
-			},
-		},
+		Options: append(options(&cliConfig), serpent.Option{
+			Flag:        "version",
+			Description: "Print version information and exit.",
+			Value:       &showVersion,
+			YAML:        "", // CLI only
+		}),
 		Handler: func(inv *serpent.Invocation) error {
 			// Handle --version flag early
 			if showVersion.Value() {
@@ -243,7 +123,7 @@ func BaseCommand(version string) *serpent.Command {
 			}
 			logger.Debug("Application config", "config", appConfigInJSON)
 
-			err = run.Run(inv.Context(), logger, appConfig)
+			err = runJail(inv.Context(), logger, appConfig)
 
 			// If the child process exited with a non-zero code, exit
 			// with the same code directly. All cleanup (proxy, etc.)
@@ -258,4 +138,291 @@ func BaseCommand(version string) *serpent.Command {
 			return err
 		},
 	}
+	cmd.AddSubcommands(explainCommand(), lintCommand(), presetsCommand(), approveCommand())
+	runAfterDash(cmd)
+
+	return cmd
+}
+
+// runJail runs the command of a boundary run in the jail. Tests replace it.
+var runJail = run.Run
+
+// runAfterDash makes the subcommands of root run root instead when their name comes after "--": in
+// `boundary --allow ... -- lint`, lint is the command to run in the jail, but serpent looks up subcommands in the
+// arguments after "--" as well. root's handler gets the arguments after "--", like when no subcommand matched.
+func runAfterDash(root *serpent.Command) {
+	root.Walk(func(cmd *serpent.Command) {
+		if cmd == root {
+			return
+		}
+		// Commands without a handler only show their help, which must not come first.
+		if cmd.Handler == nil {
+			cmd.Handler = serpent.DefaultHelpFn()
+		}
+		afterDash := func(next serpent.HandlerFunc) serpent.HandlerFunc {
+			return func(inv *serpent.Invocation) error {
+				// The positional arguments are the names of the commands down to cmd, followed by the arguments
+				// of cmd.
+				flags := inv.ParsedFlags()
+				args := flags.Args()
+				dash := flags.ArgsLenAtDash()
+				if dash < 0 || dash >= len(args)-len(inv.Args) {
+					return next(inv)
+				}
+				rootInv := *inv
+				rootInv.Command = root
+				rootInv.Args = args[dash:]
+				return root.Handler(&rootInv)
+			}
+		}
+		if cmd.Middleware == nil {
+			cmd.Middleware = afterDash
+		} else {
+			cmd.Middleware = serpent.Chain(afterDash, cmd.Middleware)
+		}
+	})
+}
+
+// ignoredOptions returns the options of a boundary run, hidden and set on a config nothing reads, for subcommands
+// that don't use them. Declaring them keeps the flags given to a subcommand from being set on the config of the
+// boundary run once more, which runAfterDash would otherwise run the jail with.
+func ignoredOptions() serpent.OptionSet {
+	var cliConfig config.CliConfig
+	opts := options(&cliConfig)
+	for i := range opts {
+		opts[i].Hidden = true
+	}
+	return opts
+}
+
+// newCliConfig returns an empty CliConfig that points at the default config file, if there is one.
+func newCliConfig() config.CliConfig {
+	cliConfig := config.CliConfig{}
+
+	// Set default config path if file exists - serpent will load it automatically
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultPath := filepath.Join(home, ".config", "coder_boundary", "config.yaml")
+		if _, err := os.Stat(defaultPath); err == nil {
+			cliConfig.Config = serpent.YAMLConfigPath(defaultPath)
+		}
+	}
+
+	return cliConfig
+}
+
+// options returns the options that configure a boundary run. Subcommands that must see the same
+// configuration as a real run, like explain, declare them again on their own CliConfig: serpent lets a
+// subcommand's flags shadow its parent's, so every value is set exactly once.
+func options(cliConfig *config.CliConfig) serpent.OptionSet {
+	return serpent.OptionSet{
+		{
+			Flag:        "config",
+			Env:         "BOUNDARY_CONFIG",
+			Description: "Path to YAML config file.",
+			Value:       &cliConfig.Config,
+			YAML:        "",
+		},
+		{
+			Flag:        "watch-config",
+			Env:         "BOUNDARY_WATCH_CONFIG",
+			Description: "Reload the rules when the config file changes, not only on SIGHUP. Only use it if the jailed command can't write to the config file.",
+			Value:       &cliConfig.WatchConfig,
+			YAML:        "watch_config",
+		},
+		{
+			Flag:        "allow",
+			Env:         "BOUNDARY_ALLOW",
+			Description: "Allow rule (repeatable). These are merged with allowlist from config file. Format: \"pattern\" or \"METHOD[,METHOD] pattern\".",
+			Value:       &cliConfig.AllowStrings,
+			YAML:        "", // CLI only, not loaded from YAML
+		},
+		{
+			Flag:        "", // No CLI flag, YAML only
+			Description: "Allowlist rules from config file (YAML only). Items are rule strings or rule objects with id, description, tags, methods, domains, paths and expires.",
+			Value:       &cliConfig.AllowList,
+			YAML:        "allowlist",
+		},
+		{
+			Flag:        "allow-preset",
+			Env:         "BOUNDARY_ALLOW_PRESET",
+			Description: "Allow the rules of a built-in preset, i.e. npm or github (repeatable). Same as --allow \"preset=NAME\". See `boundary presets list`.",
+			Value:       &cliConfig.AllowPresets,
+			YAML:        "allow_presets",
+		},
+		{
+			Flag:        "deny",
+			Env:         "BOUNDARY_DENY",
+			Description: "Deny rule (repeatable). Uses the same format as --allow and takes precedence over any matching allow rule. Merged with denylist from config file.",
+			Value:       &cliConfig.DenyStrings,
+			YAML:        "", // CLI only, not loaded from YAML
+		},
+		{
+			Flag:        "", // No CLI flag, YAML only
+			Description: "Denylist rules from config file (YAML only). Items are rule strings or rule objects, like in allowlist.",
+			Value:       &cliConfig.DenyList,
+			YAML:        "denylist",
+		},
+		{
+			Flag:        "log-level",
+			Env:         "BOUNDARY_LOG_LEVEL",
+			Description: "Set log level (error, warn, info, debug).",
+			Default:     "warn",
+			Value:       &cliConfig.LogLevel,
+			YAML:        "log_level",
+		},
+		{
+			Flag:        "log-dir",
+			Env:         "BOUNDARY_LOG_DIR",
+			Description: "Set a directory to write logs to rather than stderr.",
+			Value:       &cliConfig.LogDir,
+			YAML:        "log_dir",
+		},
+		{
+			Flag:        "proxy-port",
+			Env:         "PROXY_PORT",
+			Description: "Set a port for HTTP proxy.",
+			Default:     "8080",
+			Value:       &cliConfig.ProxyPort,
+			YAML:        "proxy_port",
+		},
+		{
+			Flag:        "pprof",
+			Env:         "BOUNDARY_PPROF",
+			Description: "Enable pprof profiling server.",
+			Value:       &cliConfig.PprofEnabled,
+			YAML:        "pprof_enabled",
+		},
+		{
+			Flag:        "pprof-port",
+			Env:         "BOUNDARY_PPROF_PORT",
+			Description: "Set port for pprof profiling server.",
+			Default:     "6060",
+			Value:       &cliConfig.PprofPort,
+			YAML:        "pprof_port",
+		},
+		{
+			Flag:        "jail-type",
+			Env:         "BOUNDARY_JAIL_TYPE",
+			Description: "Jail type to use for network isolation. Options: nsjail (default), landjail.",
+			Default:     "nsjail",
+			Value:       &cliConfig.JailType,
+			YAML:        "jail_type",
+		},
+		{
+			Flag:        "use-real-dns",
+			Env:         "BOUNDARY_USE_REAL_DNS",
+			Description: "Use real DNS in the jail instead of the dummy DNS (allows DNS exfiltration). Default: false.",
+			Value:       &cliConfig.UseRealDNS,
+			YAML:        "use_real_dns",
+		},
+		{
+			Flag:        "no-user-namespace",
+			Env:         "BOUNDARY_NO_USER_NAMESPACE",
+			Description: "Do not create a user namespace. Use in restricted environments that disallow user NS (e.g. Bottlerocket in EKS auto-mode).",
+			Value:       &cliConfig.NoUserNamespace,
+			YAML:        "no_user_namespace",
+		},
+		{
+			Flag:        "disable-audit-logs",
+			Env:         "DISABLE_AUDIT_LOGS",
+			Description: "Disable sending of audit logs to the workspace agent when set to true.",
+			Value:       &cliConfig.DisableAuditLogs,
+			YAML:        "disable_audit_logs",
+		},
+		{
+			Flag:        "log-proxy-socket-path",
+			Description: "Path to the socket where the boundary log proxy server listens for audit logs.",
+			// Important: this default must be the same default path used by the
+			// workspace agent to ensure agreement of the default socket path without
+			// explicit configuration.
+			Default: boundarylogproxy.DefaultSocketPath(),
+			// Important: this must be the same variable name used by the workspace agent
+			// to allow a single environment variable to configure both boundary and the
+			// workspace agent.
+			Env:   "CODER_AGENT_BOUNDARY_LOG_PROXY_SOCKET_PATH",
+			Value: &cliConfig.LogProxySocketPath,
+			YAML:  "", // CLI only, not loaded from YAML
+		},
+		{
+			Flag:        "path-mode",
+			Env:         "BOUNDARY_PATH_MODE",
+			Description: "What to do with requests whose path has dot segments (/a/../b) or empty segments (//a). Options: normalize (default) evaluates and forwards the canonical path, reject denies the request.",
+			Default:     "normalize",
+			Value:       &cliConfig.PathMode,
+			YAML:        "path_mode",
+		},
+		{
+			Flag:        "on-deny",
+			Env:         "BOUNDARY_ON_DENY",
+			Description: "What to do with requests no rule allows or denies. Options: deny (default), ask, which holds them and asks on the terminal, or over --ask-socket, whether to allow them.",
+			Default:     "deny",
+			Value:       &cliConfig.OnDeny,
+			YAML:        "on_deny",
+		},
+		{
+			Flag:        "ask-timeout",
+			Env:         "BOUNDARY_ASK_TIMEOUT",
+			Description: "How long --on-deny=ask waits for an answer before denying the request.",
+			Default:     "30s",
+			Value:       &cliConfig.AskTimeout,
+			YAML:        "ask_timeout",
+		},
+		{
+			Flag:        "ask-socket",
+			Env:         "BOUNDARY_ASK_SOCKET",
+			Description: "Ask over this Unix socket rather than on the terminal, answered with `boundary approve --socket PATH`.",
+			Value:       &cliConfig.AskSocket,
+			YAML:        "ask_socket",
+		},
+		{
+			Flag:        "enforcement",
+			Env:         "BOUNDARY_ENFORCEMENT",
+			Description: "Whether to act on the rules. Options: enforce (default) blocks the requests they deny, monitor audits what they decide but forwards every request, off forwards every request without evaluating them.",
+			Default:     "enforce",
+			Value:       &cliConfig.Enforcement,
+			YAML:        "enforcement",
+		},
+		{
+			Flag:        "learn",
+			Env:         "BOUNDARY_LEARN",
+			Description: "Learn a policy instead of enforcing one: forward the requests the rules deny and, when the command exits, print an allowlist that allows them.",
+			Value:       &cliConfig.Learn,
+			YAML:        "learn",
+		},
+		{
+			Flag:        "learn-output",
+			Env:         "BOUNDARY_LEARN_OUTPUT",
+			Description: "Write the allowlist --learn proposes to this file rather than stdout.",
+			Value:       &cliConfig.LearnOutput,
+			YAML:        "learn_output",
+		},
+		{
+			Flag:        "strict",
+			Env:         "BOUNDARY_STRICT",
+			Description: "Refuse to start when linting the allow and deny rules reports warnings or errors. See `boundary lint`.",
+			Value:       &cliConfig.Strict,
+			YAML:        "strict",
+		},
+		// Session correlation header injection options.
+		{
+			Flag:        "enable-session-correlation",
+			Env:         "BOUNDARY_SESSION_CORRELATION_ENABLED",
+			Description: "Enable session correlation header injection. When no inject targets are configured, the target is auto-derived from CODER_AGENT_URL (set automatically inside Coder workspaces). Disable for deployments without Coder AI Gateway in front.",
+			Value:       &cliConfig.SessionCorrelationEnabled,
+			YAML:        "session_correlation_enabled",
+		},
+		{
+			Flag:        "session-id-inject-target",
+			Env:         "BOUNDARY_SESSION_ID_INJECT_TARGET",
+			Description: `Inject target for session correlation headers. Repeat the flag once per target; each value describes exactly one target. Format: "domain=<host> [path=<glob>]". Example: --session-id-inject-target "domain=prod.coder.com path=/api/v2/aibridge/*".`,
+			Value:       &cliConfig.InjectSessionIDTarget,
+			YAML:        "", // CLI only, YAML uses session_id_inject_targets.
+		},
+		{
+			Flag:        "", // No CLI flag, YAML only.
+			Description: "Inject targets from config file (YAML only).",
+			Value:       &cliConfig.InjectSessionIDTargets,
+			YAML:        "session_id_inject_targets",
+		},
+	}
 }
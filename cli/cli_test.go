@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/coder/boundary/config"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSubcommandNamesAfterDash verifies that a command to run in the jail is
+// run even when it is named like a subcommand, and with the rules given once.
+func TestSubcommandNamesAfterDash(t *testing.T) {
+	var jailed []config.AppConfig
+	orig := runJail
+	t.Cleanup(func() { runJail = orig })
+	runJail = func(_ context.Context, _ *slog.Logger, appConfig config.AppConfig) error {
+		jailed = append(jailed, appConfig)
+		return nil
+	}
+
+	for _, target := range [][]string{
+		{"lint"},
+		{"explain", "GET", "https://example.com"},
+		{"presets", "list"},
+		{"approve"},
+	} {
+		jailed = nil
+		args := append([]string{"--allow=domain=example.com", "--jail-type=landjail", "--"}, target...)
+		inv := NewCommand("test").Invoke(args...)
+		inv.Stdout = &bytes.Buffer{}
+		inv.Stderr = &bytes.Buffer{}
+		require.NoError(t, inv.Run(), target)
+
+		require.Len(t, jailed, 1, target)
+		require.Equal(t, target, jailed[0].TargetCMD)
+		require.Equal(t, []config.RuleEntry{{Spec: "domain=example.com"}}, jailed[0].AllowRules, target)
+	}
+
+	// Before "--", they are subcommands.
+	jailed = nil
+	var out bytes.Buffer
+	inv := NewCommand("test").Invoke("presets", "show", "npm")
+	inv.Stdout = &out
+	require.NoError(t, inv.Run())
+	require.Empty(t, jailed)
+	require.Contains(t, out.String(), "# npm@")
+}
+
+// TestExplainPathMode verifies that explain evaluates the path the proxy
+// would, according to --path-mode.
+func TestExplainPathMode(t *testing.T) {
+	explain := func(args ...string) string {
+		var out bytes.Buffer
+		inv := NewCommand("test").Invoke(append([]string{"explain", "--allow=domain=example.com path=/public/*"}, args...)...)
+		inv.Stdout = &out
+		require.NoError(t, inv.Run())
+		return out.String()
+	}
+
+	out := explain("GET", "https://example.com/public/../admin")
+	require.Contains(t, out, "The path is normalized to https://example.com/admin\n")
+	require.Contains(t, out, "GET https://example.com/admin: DENIED")
+
+	out = explain("--path-mode=reject", "GET", "https://example.com/public/../public/x")
+	require.Equal(t, "GET https://example.com/public/../public/x: DENIED, the proxy rejects its path (--path-mode=reject): path has dot segments or empty segments\n", out)
+
+	// Encoded slashes hiding dot segments are refused in either mode.
+	out = explain("GET", "https://example.com/public/..%2Fadmin")
+	require.Contains(t, out, "DENIED, the proxy rejects its path (--path-mode=normalize)")
+
+	out = explain("GET", "https://example.com/public/x")
+	require.Contains(t, out, "GET https://example.com/public/x: ALLOWED")
+}
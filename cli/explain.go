@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/rulesengine"
+	"github.com/coder/serpent"
+)
+
+// explainCommand returns the `explain` subcommand. It takes the same options as a real run, so it evaluates
+// requests against exactly the rules that run would load from flags, environment and config file.
+func explainCommand() *serpent.Command {
+	cliConfig := newCliConfig()
+
+	return &serpent.Command{
+		Use:   "explain METHOD URL",
+		Short: "Explain how the configured rules evaluate a request",
+		Long: `Evaluate a request against the configured allow and deny rules without running anything, and show for every rule whether it matched and, if not, why.
+
+Examples:
+  # Why is this request denied?
+  boundary explain --allow "domain=github.com" GET https://api.github.com/repos
+
+  # Check a request against the rules in the config file
+  boundary explain --config ./boundary.yaml POST https://api.example.com/v1/users`,
+		Options:    options(&cliConfig),
+		Middleware: serpent.RequireNArgs(2),
+		Handler: func(inv *serpent.Invocation) error {
+			appConfig, err := config.NewAppConfigFromCliConfig(cliConfig, nil, os.Environ())
+			if err != nil {
+				return fmt.Errorf("failed to parse cli config file: %v", err)
+			}
+
+			rules, err := appConfig.Rules()
+			if err != nil {
+				return err
+			}
+
+			engine := rulesengine.NewRuleEngine(rules, slog.New(slog.DiscardHandler))
+			method, url := strings.ToUpper(inv.Args[0]), inv.Args[1]
+
+			// The rules see the path the proxy would forward, or nothing if --path-mode has it rejected.
+			canonical, err := canonicalRequestURL(url, appConfig.PathMode)
+			if err != nil {
+				_, err = fmt.Fprintf(inv.Stdout, "%s %s: DENIED, the proxy rejects its path (--path-mode=%s): %v\n", method, url, appConfig.PathMode, err)
+				return err
+			}
+			if canonical != url {
+				if _, err := fmt.Fprintf(inv.Stdout, "The path is normalized to %s\n", canonical); err != nil {
+					return err
+				}
+				url = canonical
+			}
+
+			return writeExplanation(inv.Stdout, method, url, engine.Explain(method, url))
+		},
+	}
+}
+
+// canonicalRequestURL returns url with the path the proxy evaluates and forwards instead, see
+// rulesengine.CanonicalizePath, or the error the proxy rejects the request with in mode.
+func canonicalRequestURL(url string, mode config.PathMode) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		// The engine explains that it can't match a URL that doesn't parse.
+		return url, nil
+	}
+	path, err := rulesengine.CanonicalizePath(u.EscapedPath())
+	if err == nil && path.Changed && mode == config.PathReject {
+		err = errors.New("path has dot segments or empty segments")
+	}
+	if err != nil {
+		return "", err
+	}
+	if !path.Changed {
+		return url, nil
+	}
+	u.Path, _ = neturl.PathUnescape(path.Escaped)
+	u.RawPath = path.Escaped
+	return u.String(), nil
+}
+
+func writeExplanation(w io.Writer, method, url string, explanation rulesengine.Explanation) error {
+	verdict := "DENIED"
+	if explanation.Result.Allowed {
+		verdict = "ALLOWED"
+	}
+	decidedBy := ruleName(explanation.Result.Rule, explanation.Result.RuleID)
+	if decidedBy == "" {
+		decidedBy = "no rule matched (default deny)"
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s: %s by %s\n\n", method, url, verdict, decidedBy); err != nil {
+		return err
+	}
+	if len(explanation.Rules) == 0 {
+		_, err := fmt.Fprintln(w, "No rules configured; all requests are denied.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KIND\tRULE\tRESULT")
+	for _, trace := range explanation.Rules {
+		kind := "allow"
+		if trace.Deny {
+			kind = "deny"
+		}
+		result := "match"
+		if !trace.Matched {
+			result = trace.Mismatch.String()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", kind, ruleName(trace.Rule, trace.RuleID), result)
+	}
+	return tw.Flush()
+}
+
+// ruleName names a rule by its spec and, for structured rules, its id.
+func ruleName(raw, id string) string {
+	if id == "" {
+		return raw
+	}
+	return fmt.Sprintf("%s [%s]", raw, id)
+}
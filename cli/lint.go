@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/rulesengine"
+	"github.com/coder/serpent"
+)
+
+// lintCommand returns the `lint` subcommand. Like explain, it takes the same options as a real run so it checks
+// exactly the rules that run would load.
+func lintCommand() *serpent.Command {
+	cliConfig := newCliConfig()
+
+	return &serpent.Command{
+		Use:   "lint",
+		Short: "Check the configured rules for shadowed, redundant and suspicious entries",
+		Long: `Check the configured allow and deny rules for problems: rules that can never take effect because a deny rule or an earlier rule already matches all their requests, and rules that allow far more than they probably should.
+
+Findings are reported as info, warning or error. The command fails when there are errors, and with --strict also when there are warnings.
+
+Examples:
+  # Check the rules in the config file
+  boundary lint --config ./boundary.yaml
+
+  # Fail on warnings as well, i.e. in CI
+  boundary lint --strict --allow "domain=*.github.com" --allow "domain=api.github.com"`,
+		Options:    options(&cliConfig),
+		Middleware: serpent.RequireNArgs(0),
+		Handler: func(inv *serpent.Invocation) error {
+			appConfig, err := config.NewAppConfigFromCliConfig(cliConfig, nil, os.Environ())
+			if err != nil {
+				return fmt.Errorf("failed to parse cli config file: %v", err)
+			}
+
+			rules, err := appConfig.Rules()
+			if err != nil {
+				return err
+			}
+
+			findings := rulesengine.Lint(rules)
+			if err := writeFindings(inv.Stdout, findings); err != nil {
+				return err
+			}
+
+			for _, f := range findings {
+				if f.Severity == rulesengine.SeverityError {
+					return fmt.Errorf("the rules have lint errors")
+				}
+			}
+			if appConfig.Strict && rulesengine.HasProblems(findings) {
+				return fmt.Errorf("the rules have lint warnings")
+			}
+			return nil
+		},
+	}
+}
+
+func writeFindings(w io.Writer, findings []rulesengine.Finding) error {
+	if len(findings) == 0 {
+		_, err := fmt.Fprintln(w, "No problems found.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SEVERITY\tCHECK\tRULE\tMESSAGE")
+	for _, f := range findings {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Severity, f.Check, f.Rule, f.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/coder/serpent"
+)
+
+// presetsCommand returns the `presets` subcommand, which shows the built-in presets `preset=NAME` and
+// --allow-preset expand to.
+func presetsCommand() *serpent.Command {
+	cmd := &serpent.Command{
+		Use:   "presets",
+		Short: "List and show the built-in rule presets",
+		Long: `Presets are curated rule sets for common ecosystems, built into boundary. Use one with --allow-preset NAME or a rule of just "preset=NAME"; "preset=NAME@VERSION" fails to start once the preset changes.
+
+Examples:
+  boundary presets list
+  boundary presets show npm`,
+		Options: ignoredOptions(),
+	}
+	cmd.AddSubcommands(presetsListCommand(), presetsShowCommand())
+	return cmd
+}
+
+func presetsListCommand() *serpent.Command {
+	return &serpent.Command{
+		Use:        "list",
+		Short:      "List the built-in presets",
+		Middleware: serpent.RequireNArgs(0),
+		Handler: func(inv *serpent.Invocation) error {
+			tw := tabwriter.NewWriter(inv.Stdout, 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(tw, "NAME\tVERSION\tRULES\tDESCRIPTION")
+			for _, p := range rulesengine.Presets() {
+				_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", p.Name, p.Version, len(p.Rules), p.Description)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func presetsShowCommand() *serpent.Command {
+	return &serpent.Command{
+		Use:        "show NAME",
+		Short:      "Print the rules a preset expands to",
+		Middleware: serpent.RequireNArgs(1),
+		Handler: func(inv *serpent.Invocation) error {
+			name := inv.Args[0]
+			preset, ok := rulesengine.LookupPreset(name)
+			if !ok {
+				return fmt.Errorf("unknown preset: %s", name)
+			}
+
+			if _, err := fmt.Fprintf(inv.Stdout, "# %s@%d: %s\n", preset.Name, preset.Version, preset.Description); err != nil {
+				return err
+			}
+			for _, rule := range preset.Rules {
+				if _, err := fmt.Fprintln(inv.Stdout, rule); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -2,11 +2,17 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/coder/boundary/rulesengine"
 	"github.com/coder/serpent"
 	"github.com/google/uuid"
 	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 )
 
 // JailType represents the type of jail to use for network isolation
@@ -28,6 +34,74 @@ func NewJailTypeFromString(str string) (JailType, error) {
 	}
 }
 
+// PathMode selects what the proxy does with requests whose path isn't canonical, see
+// rulesengine.CanonicalizePath.
+type PathMode string
+
+const (
+	// PathNormalize evaluates and forwards the canonical path instead.
+	PathNormalize PathMode = "normalize"
+	// PathReject refuses the request.
+	PathReject PathMode = "reject"
+)
+
+func NewPathModeFromString(str string) (PathMode, error) {
+	switch str {
+	case "normalize":
+		return PathNormalize, nil
+	case "reject":
+		return PathReject, nil
+	default:
+		return PathNormalize, fmt.Errorf("invalid PathMode: %s", str)
+	}
+}
+
+// OnDeny selects what the proxy does with requests that no rule allows or denies.
+type OnDeny string
+
+const (
+	// OnDenyDeny denies them, boundary's default deny.
+	OnDenyDeny OnDeny = "deny"
+	// OnDenyAsk holds them until the person running boundary allows or denies them.
+	OnDenyAsk OnDeny = "ask"
+)
+
+func NewOnDenyFromString(str string) (OnDeny, error) {
+	switch str {
+	case "deny":
+		return OnDenyDeny, nil
+	case "ask":
+		return OnDenyAsk, nil
+	default:
+		return OnDenyDeny, fmt.Errorf("invalid OnDeny: %s", str)
+	}
+}
+
+// EnforcementMode selects whether the proxy acts on the decisions of the rules.
+type EnforcementMode string
+
+const (
+	// EnforcementEnforce blocks the requests the rules deny.
+	EnforcementEnforce EnforcementMode = "enforce"
+	// EnforcementMonitor evaluates and audits every request, but forwards it whatever the decision.
+	EnforcementMonitor EnforcementMode = "monitor"
+	// EnforcementOff forwards every request without evaluating the rules.
+	EnforcementOff EnforcementMode = "off"
+)
+
+func NewEnforcementModeFromString(str string) (EnforcementMode, error) {
+	switch str {
+	case "enforce":
+		return EnforcementEnforce, nil
+	case "monitor":
+		return EnforcementMonitor, nil
+	case "off":
+		return EnforcementOff, nil
+	default:
+		return EnforcementEnforce, fmt.Errorf("invalid EnforcementMode: %s", str)
+	}
+}
+
 // AllowStringsArray is a custom type that implements pflag.Value to support
 // repeatable --allow flags without splitting on commas. This allows comma-separated
 // paths within a single allow rule (e.g., "path=/todos/1,/todos/2").
@@ -58,8 +132,12 @@ func (a AllowStringsArray) Value() []string {
 
 type CliConfig struct {
 	Config             serpent.YAMLConfigPath `yaml:"-"`
-	AllowListStrings   serpent.StringArray    `yaml:"allowlist"` // From config file
+	WatchConfig        serpent.Bool           `yaml:"watch_config"`
+	AllowList          RuleList               `yaml:"allowlist"` // From config file
 	AllowStrings       AllowStringsArray      `yaml:"-"`         // From CLI flags only
+	DenyList           RuleList               `yaml:"denylist"`  // From config file
+	DenyStrings        AllowStringsArray      `yaml:"-"`         // From CLI flags only
+	AllowPresets       serpent.StringArray    `yaml:"allow_presets"`
 	LogLevel           serpent.String         `yaml:"log_level"`
 	LogDir             serpent.String         `yaml:"log_dir"`
 	ProxyPort          serpent.Int64          `yaml:"proxy_port"`
@@ -70,6 +148,14 @@ type CliConfig struct {
 	NoUserNamespace    serpent.Bool           `yaml:"no_user_namespace"`
 	DisableAuditLogs   serpent.Bool           `yaml:"disable_audit_logs"`
 	LogProxySocketPath serpent.String         `yaml:"log_proxy_socket_path"`
+	Strict             serpent.Bool           `yaml:"strict"`
+	PathMode           serpent.String         `yaml:"path_mode"`
+	OnDeny             serpent.String         `yaml:"on_deny"`
+	AskTimeout         serpent.Duration       `yaml:"ask_timeout"`
+	AskSocket          serpent.String         `yaml:"ask_socket"`
+	Learn              serpent.Bool           `yaml:"learn"`
+	LearnOutput        serpent.String         `yaml:"learn_output"`
+	Enforcement        serpent.String         `yaml:"enforcement"`
 
 	// Session correlation header injection.
 	SessionCorrelationEnabled serpent.Bool        `yaml:"session_correlation_enabled"`
@@ -78,7 +164,8 @@ type CliConfig struct {
 }
 
 type AppConfig struct {
-	AllowRules         []string
+	AllowRules         []RuleEntry
+	DenyRules          []RuleEntry
 	LogLevel           string
 	LogDir             string
 	ProxyPort          int64
@@ -91,6 +178,23 @@ type AppConfig struct {
 	UserInfo           *UserInfo
 	DisableAuditLogs   bool
 	LogProxySocketPath string
+	// Strict refuses to start when linting the rules reports warnings or errors.
+	Strict   bool
+	PathMode PathMode
+	// OnDeny, AskTimeout and AskSocket decide what happens to requests denied
+	// by default. With OnDenyAsk, the question goes to AskSocket if set, or
+	// else to the controlling terminal, and is denied after AskTimeout.
+	OnDeny     OnDeny
+	AskTimeout time.Duration
+	AskSocket  string
+	// Learn forwards the requests the rules deny and, once the command exits,
+	// writes an allowlist that would have allowed them to LearnOutput, or to
+	// stdout if it is empty.
+	Learn       bool
+	LearnOutput string
+	// Enforcement decides whether the proxy blocks the requests the rules
+	// deny, only audits them (monitor) or doesn't evaluate the rules (off).
+	Enforcement EnforcementMode
 
 	// SessionCorrelation controls header injection for AI Bridge
 	// correlation. See SessionCorrelationConfig for details.
@@ -100,21 +204,71 @@ type AppConfig struct {
 	// all audit events produced by this boundary invocation into a
 	// single session. Set by Run, not by configuration.
 	SessionID uuid.UUID
+
+	// ConfigPath is the YAML config file, if any. ReloadRules reads its
+	// allowlist and denylist again.
+	ConfigPath string
+	// WatchConfig reloads the rules when ConfigPath changes, not only on
+	// SIGHUP.
+	WatchConfig bool
+	// fileAllowRules and fileDenyRules are how many of AllowRules and
+	// DenyRules come from the config file. They come first.
+	fileAllowRules int
+	fileDenyRules  int
+	// fileRules and flagRules hold the parsed rules of the config file, and
+	// of CLI flags and presets, see Rules. Copies of the config share them,
+	// and ReloadRules only replaces fileRules, so rules are parsed once: a
+	// relative from= or until= keeps the time it was resolved to at startup.
+	fileRules *parsedRules
+	flagRules *parsedRules
 }
 
 func NewAppConfigFromCliConfig(cfg CliConfig, targetCMD []string, environ []string) (AppConfig, error) {
 	// Merge allowlist from config file with allow from CLI flags
-	allowListStrings := cfg.AllowListStrings.Value()
+	allowList := cfg.AllowList.Value()
 	allowStrings := cfg.AllowStrings.Value()
 
 	// Combine allowlist (config file) with allow (CLI flags)
-	allAllowStrings := append(allowListStrings, allowStrings...)
+	allAllowRules := append(slices.Clip(allowList), SpecEntries(allowStrings)...)
+
+	// Presets are allow rules like any other, after the ones written out in full.
+	for _, name := range cfg.AllowPresets.Value() {
+		allAllowRules = append(allAllowRules, RuleEntry{Spec: "preset=" + name})
+	}
+
+	// Deny rules are merged the same way: config file first, then CLI flags.
+	allDenyRules := append(slices.Clip(cfg.DenyList.Value()), SpecEntries(cfg.DenyStrings.Value())...)
 
 	jailType, err := NewJailTypeFromString(cfg.JailType.Value())
 	if err != nil {
 		return AppConfig{}, err
 	}
 
+	pathMode, err := NewPathModeFromString(cfg.PathMode.Value())
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	onDeny, err := NewOnDenyFromString(cfg.OnDeny.Value())
+	if err != nil {
+		return AppConfig{}, err
+	}
+
+	if cfg.Learn.Value() && onDeny == OnDenyAsk {
+		return AppConfig{}, fmt.Errorf("--learn and --on-deny=ask can't be used together")
+	}
+
+	enforcement, err := NewEnforcementModeFromString(cfg.Enforcement.Value())
+	if err != nil {
+		return AppConfig{}, err
+	}
+	if enforcement != EnforcementEnforce && onDeny == OnDenyAsk {
+		return AppConfig{}, fmt.Errorf("--on-deny=ask needs --enforcement=enforce, nothing is denied otherwise")
+	}
+	if enforcement == EnforcementOff && cfg.Learn.Value() {
+		return AppConfig{}, fmt.Errorf("--learn needs the rules evaluated, it can't be used with --enforcement=off")
+	}
+
 	userInfo := GetUserInfo()
 
 	// Build session correlation config from CLI and YAML sources.
@@ -124,7 +278,8 @@ func NewAppConfigFromCliConfig(cfg CliConfig, targetCMD []string, environ []stri
 	}
 
 	return AppConfig{
-		AllowRules:         allAllowStrings,
+		AllowRules:         allAllowRules,
+		DenyRules:          allDenyRules,
 		LogLevel:           cfg.LogLevel.Value(),
 		LogDir:             cfg.LogDir.Value(),
 		ProxyPort:          cfg.ProxyPort.Value(),
@@ -137,10 +292,105 @@ func NewAppConfigFromCliConfig(cfg CliConfig, targetCMD []string, environ []stri
 		UserInfo:           userInfo,
 		DisableAuditLogs:   cfg.DisableAuditLogs.Value(),
 		LogProxySocketPath: cfg.LogProxySocketPath.Value(),
+		Strict:             cfg.Strict.Value(),
+		PathMode:           pathMode,
+		OnDeny:             onDeny,
+		AskTimeout:         cfg.AskTimeout.Value(),
+		AskSocket:          cfg.AskSocket.Value(),
+		Learn:              cfg.Learn.Value(),
+		LearnOutput:        cfg.LearnOutput.Value(),
+		Enforcement:        enforcement,
 		SessionCorrelation: sc,
+		ConfigPath:         cfg.Config.String(),
+		WatchConfig:        cfg.WatchConfig.Value(),
+		fileAllowRules:     len(allowList),
+		fileDenyRules:      len(cfg.DenyList.Value()),
+		fileRules:          &parsedRules{},
+		flagRules:          &parsedRules{},
 	}, nil
 }
 
+// ReloadRules returns a copy of the config with the allowlist and denylist
+// read again from the config file, merged with the rules from CLI flags and
+// presets as at startup. Only the rules of the file are parsed again, the
+// copy shares the others with c. Other settings of the file are not reloaded.
+// Without a config file, the copy has the same rules.
+func (c AppConfig) ReloadRules() (AppConfig, error) {
+	if c.ConfigPath == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(c.ConfigPath)
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var file struct {
+		AllowList RuleList `yaml:"allowlist"`
+		DenyList  RuleList `yaml:"denylist"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return AppConfig{}, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	c.AllowRules = append(slices.Clip(file.AllowList.Value()), c.AllowRules[c.fileAllowRules:]...)
+	c.DenyRules = append(slices.Clip(file.DenyList.Value()), c.DenyRules[c.fileDenyRules:]...)
+	c.fileAllowRules = len(file.AllowList)
+	c.fileDenyRules = len(file.DenyList)
+	c.fileRules = &parsedRules{}
+	return c, nil
+}
+
+// Rules parses the allow and deny rules of the config into a single slice
+// suitable for rulesengine.NewRuleEngine. The rules are parsed the first time
+// they're asked for, and the config and its copies return the same rules
+// afterwards; see ReloadRules for reading the config file again.
+func (c AppConfig) Rules() ([]rulesengine.Rule, error) {
+	fileAllow, fileDeny, err := c.fileRules.parse(c.AllowRules[:c.fileAllowRules], c.DenyRules[:c.fileDenyRules])
+	if err != nil {
+		return nil, err
+	}
+	flagAllow, flagDeny, err := c.flagRules.parse(c.AllowRules[c.fileAllowRules:], c.DenyRules[c.fileDenyRules:])
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(fileAllow, flagAllow, fileDeny, flagDeny), nil
+}
+
+// parsedRules parses allow and deny rule entries once, the first time they're
+// needed. A nil *parsedRules parses them every time.
+type parsedRules struct {
+	once  sync.Once
+	allow []rulesengine.Rule
+	deny  []rulesengine.Rule
+	err   error
+}
+
+func (p *parsedRules) parse(allow, deny []RuleEntry) ([]rulesengine.Rule, []rulesengine.Rule, error) {
+	if p == nil {
+		return parseAllowDenyEntries(allow, deny)
+	}
+	p.once.Do(func() {
+		p.allow, p.deny, p.err = parseAllowDenyEntries(allow, deny)
+	})
+	return p.allow, p.deny, p.err
+}
+
+func parseAllowDenyEntries(allow, deny []RuleEntry) ([]rulesengine.Rule, []rulesengine.Rule, error) {
+	ids := make(map[string]struct{})
+
+	allowRules, err := parseRuleEntries(allow, false, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse allow rules: %v", err)
+	}
+
+	denyRules, err := parseRuleEntries(deny, true, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse deny rules: %v", err)
+	}
+
+	return allowRules, denyRules, nil
+}
+
 // buildSessionCorrelation merges CLI and YAML inject target sources
 // and validates the resulting configuration. Inject targets use the same
 // "domain=... path=..." syntax as --allow rules so that matching semantics
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleEntry is a single allow or deny rule from the configuration: either a spec in the compact grammar, like
+// `method=GET domain=github.com`, or a structured rule from the config file. Exactly one of them is set.
+type RuleEntry struct {
+	Spec       string
+	Structured *rulesengine.StructuredRule
+}
+
+// SpecEntries wraps specs from CLI flags as rule entries.
+func SpecEntries(specs []string) []RuleEntry {
+	entries := make([]RuleEntry, 0, len(specs))
+	for _, spec := range specs {
+		entries = append(entries, RuleEntry{Spec: spec})
+	}
+	return entries
+}
+
+// RuleList is the allowlist or denylist of the config file. Each item is either a spec string or a mapping with
+// the fields of rulesengine.StructuredRule:
+//
+//	allowlist:
+//	  - domain=github.com
+//	  - id: npm-registry
+//	    tags: [team-web]
+//	    methods: [GET, HEAD]
+//	    domains: [registry.npmjs.org]
+type RuleList []RuleEntry
+
+var (
+	_ pflag.Value      = (*RuleList)(nil)
+	_ yaml.Unmarshaler = (*RuleList)(nil)
+)
+
+// Set implements pflag.Value. It appends the value as a spec.
+func (l *RuleList) Set(value string) error {
+	*l = append(*l, RuleEntry{Spec: value})
+	return nil
+}
+
+// String implements pflag.Value.
+func (l RuleList) String() string {
+	specs := make([]string, 0, len(l))
+	for _, e := range l {
+		if e.Structured != nil {
+			// A structured rule has no single spec, so its id stands in for it.
+			specs = append(specs, "id="+e.Structured.ID)
+			continue
+		}
+		specs = append(specs, e.Spec)
+	}
+	return strings.Join(specs, ",")
+}
+
+// Type implements pflag.Value.
+func (l RuleList) Type() string {
+	return "rule-list"
+}
+
+// Value returns the underlying slice of entries.
+func (l RuleList) Value() []RuleEntry {
+	return []RuleEntry(l)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *RuleList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		// An empty `allowlist:` key.
+		*l = nil
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: expected a list of rules", n.Line)
+	}
+
+	entries := make([]RuleEntry, 0, len(n.Content))
+	for _, item := range n.Content {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			entries = append(entries, RuleEntry{Spec: item.Value})
+
+		case yaml.MappingNode:
+			// Decoding ignores unknown fields, but a misspelled `method:` would silently widen the rule.
+			for i := 0; i < len(item.Content); i += 2 {
+				key := item.Content[i]
+				if _, ok := structuredRuleFields[key.Value]; !ok {
+					return fmt.Errorf("line %d: unknown rule field: %s", key.Line, key.Value)
+				}
+			}
+			var rule rulesengine.StructuredRule
+			if err := item.Decode(&rule); err != nil {
+				return fmt.Errorf("line %d: %v", item.Line, err)
+			}
+			entries = append(entries, RuleEntry{Structured: &rule})
+
+		default:
+			return fmt.Errorf("line %d: expected a rule string or a rule object", item.Line)
+		}
+	}
+	*l = entries
+	return nil
+}
+
+// structuredRuleFields holds the YAML field names of rulesengine.StructuredRule.
+var structuredRuleFields = func() map[string]struct{} {
+	t := reflect.TypeFor[rulesengine.StructuredRule]()
+	fields := make(map[string]struct{}, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		fields[name] = struct{}{}
+	}
+	return fields
+}()
+
+// parseRuleEntries parses allow or deny rule entries. ids holds the rule ids seen so far, since ids must be
+// unique across the allow and deny rules.
+func parseRuleEntries(entries []RuleEntry, deny bool, ids map[string]struct{}) ([]rulesengine.Rule, error) {
+	kind := "allow"
+	if deny {
+		kind = "deny"
+	}
+
+	var out []rulesengine.Rule
+	for i, e := range entries {
+		if e.Structured == nil {
+			parse := rulesengine.ParseAllowSpecs
+			if deny {
+				parse = rulesengine.ParseDenySpecs
+			}
+			rules, err := parse([]string{e.Spec})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, rules...)
+			continue
+		}
+
+		name := e.Structured.ID
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		} else {
+			if _, ok := ids[name]; ok {
+				return nil, fmt.Errorf("duplicate rule id: %s", name)
+			}
+			ids[name] = struct{}{}
+		}
+
+		rules, err := e.Structured.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s rule %s: %v", kind, name, err)
+		}
+		for _, r := range rules {
+			r.Deny = deny
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// AppendAllowRule adds spec at the end of the allowlist of the config file at path, and creates the allowlist if
+// the file has none. The rest of the file is kept, comments included, though it may be indented anew.
+func AppendAllowRule(path, spec string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if doc.Kind == 0 {
+		// An empty file.
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file is not a mapping")
+	}
+
+	var list *yaml.Node
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value == "allowlist" {
+			list = root.Content[i+1]
+			break
+		}
+	}
+	switch {
+	case list == nil:
+		list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "allowlist"}, list)
+	case list.Kind == yaml.ScalarNode && list.Tag == "!!null":
+		// An empty `allowlist:` key.
+		*list = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	case list.Kind != yaml.SequenceNode:
+		return fmt.Errorf("line %d: expected a list of rules", list.Line)
+	}
+	// A flow style list, like `allowlist: []`, is turned into a block list as it grows.
+	list.Style = 0
+	list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: spec})
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
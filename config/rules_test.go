@@ -0,0 +1,322 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/boundary/rulesengine"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRuleListUnmarshalYAML(t *testing.T) {
+	t.Parallel()
+
+	var file struct {
+		Allowlist RuleList `yaml:"allowlist"`
+	}
+	err := yaml.Unmarshal([]byte(`
+allowlist:
+  - domain=github.com
+  - id: npm-registry
+    description: Package installs.
+    tags: [team-web]
+    methods: [GET, HEAD]
+    domains: [registry.npmjs.org, registry.yarnpkg.com]
+    expires: 2026-12-31T17:00:00Z
+  - preset=pypi
+`), &file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := file.Allowlist.Value()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Spec != "domain=github.com" || entries[0].Structured != nil {
+		t.Errorf("expected spec entry, got %+v", entries[0])
+	}
+	if entries[2].Spec != "preset=pypi" || entries[2].Structured != nil {
+		t.Errorf("expected spec entry, got %+v", entries[2])
+	}
+
+	rule := entries[1].Structured
+	if rule == nil {
+		t.Fatalf("expected structured entry, got %+v", entries[1])
+	}
+	if rule.ID != "npm-registry" || rule.Description != "Package installs." {
+		t.Errorf("unexpected metadata: %+v", rule)
+	}
+	if !reflect.DeepEqual(rule.Tags, []string{"team-web"}) ||
+		!reflect.DeepEqual(rule.Methods, []string{"GET", "HEAD"}) ||
+		!reflect.DeepEqual(rule.Domains, []string{"registry.npmjs.org", "registry.yarnpkg.com"}) {
+		t.Errorf("unexpected patterns: %+v", rule)
+	}
+	if want := time.Date(2026, 12, 31, 17, 0, 0, 0, time.UTC); !rule.Expires.Equal(want) {
+		t.Errorf("expected expiry %v, got %v", want, rule.Expires)
+	}
+}
+
+func TestRuleListUnmarshalYAML_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "misspelled field",
+			input:   "allowlist:\n  - id: x\n    domain: [a.com]\n",
+			wantErr: "line 3: unknown rule field: domain",
+		},
+		{
+			name:    "not a list",
+			input:   "allowlist: domain=a.com\n",
+			wantErr: "expected a list of rules",
+		},
+		{
+			name:    "nested list",
+			input:   "allowlist:\n  - [domain=a.com]\n",
+			wantErr: "expected a rule string or a rule object",
+		},
+		{
+			name:    "invalid expiry",
+			input:   "allowlist:\n  - domains: [a.com]\n    expires: tomorrow\n",
+			wantErr: "line 2",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var file struct {
+				Allowlist RuleList `yaml:"allowlist"`
+			}
+			err := yaml.Unmarshal([]byte(tc.input), &file)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAppConfigRules_StructuredRules(t *testing.T) {
+	t.Parallel()
+
+	cli := baseCliConfig()
+	cli.AllowList = RuleList{
+		{Spec: "domain=github.com"},
+		{Structured: &rulesengine.StructuredRule{ID: "npm", Tags: []string{"team-web"}, Domains: []string{"registry.npmjs.org", "registry.yarnpkg.com"}}},
+	}
+	cli.DenyList = RuleList{
+		{Structured: &rulesengine.StructuredRule{ID: "no-admin", Paths: []string{"/admin/*"}}},
+	}
+	_ = cli.AllowStrings.Set("domain=example.com")
+
+	appCfg, err := NewAppConfigFromCliConfig(cli, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules, err := appCfg.Rules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Config file entries keep their order, followed by the CLI flags and then the deny rules.
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Raw+" "+r.ID+" "+strings.Join(r.Tags, ","))
+		if r.Deny != (r.ID == "no-admin") {
+			t.Errorf("unexpected Deny for %q", r.Raw)
+		}
+	}
+	want := []string{
+		"domain=github.com  ",
+		"domain=registry.npmjs.org npm team-web",
+		"domain=registry.yarnpkg.com npm team-web",
+		"domain=example.com  ",
+		"path=/admin/* no-admin ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected rules %q, got %q", want, got)
+	}
+}
+
+func TestAppConfigRules_DuplicateID(t *testing.T) {
+	t.Parallel()
+
+	appCfg := AppConfig{
+		AllowRules: []RuleEntry{{Structured: &rulesengine.StructuredRule{ID: "dup", Domains: []string{"a.com"}}}},
+		DenyRules:  []RuleEntry{{Structured: &rulesengine.StructuredRule{ID: "dup", Domains: []string{"b.com"}}}},
+	}
+	_, err := appCfg.Rules()
+	if err == nil || !strings.Contains(err.Error(), "duplicate rule id: dup") {
+		t.Fatalf("expected duplicate id error, got %v", err)
+	}
+}
+
+func TestAppConfigReloadRules(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+	}
+	writeFile("allowlist:\n  - domain=github.com\n")
+
+	// As serpent would have loaded it at startup.
+	cli := baseCliConfig()
+	_ = cli.Config.Set(path)
+	cli.AllowList = RuleList{{Spec: "domain=github.com"}}
+	_ = cli.AllowStrings.Set("domain=example.com")
+	_ = cli.AllowPresets.Set("npm")
+	_ = cli.DenyStrings.Set("path=/admin/*")
+
+	appCfg, err := NewAppConfigFromCliConfig(cli, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeFile(`
+allowlist:
+  - domain=gitlab.com
+  - id: pypi
+    domains: [pypi.org]
+denylist:
+  - method=DELETE
+`)
+	reloaded, err := appCfg.ReloadRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The file's rules are replaced, the ones from flags and presets are kept.
+	specs := func(entries []RuleEntry) []string {
+		var out []string
+		for _, e := range entries {
+			if e.Structured != nil {
+				out = append(out, "id="+e.Structured.ID)
+				continue
+			}
+			out = append(out, e.Spec)
+		}
+		return out
+	}
+	if want := []string{"domain=gitlab.com", "id=pypi", "domain=example.com", "preset=npm"}; !reflect.DeepEqual(specs(reloaded.AllowRules), want) {
+		t.Errorf("expected allow rules %q, got %q", want, specs(reloaded.AllowRules))
+	}
+	if want := []string{"method=DELETE", "path=/admin/*"}; !reflect.DeepEqual(specs(reloaded.DenyRules), want) {
+		t.Errorf("expected deny rules %q, got %q", want, specs(reloaded.DenyRules))
+	}
+	// The original config is left alone.
+	if want := []string{"domain=github.com", "domain=example.com", "preset=npm"}; !reflect.DeepEqual(specs(appCfg.AllowRules), want) {
+		t.Errorf("expected allow rules %q, got %q", want, specs(appCfg.AllowRules))
+	}
+
+	// Reloading again replaces the rules the last reload read.
+	writeFile("allowlist: []\n")
+	reloaded, err = reloaded.ReloadRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"domain=example.com", "preset=npm"}; !reflect.DeepEqual(specs(reloaded.AllowRules), want) {
+		t.Errorf("expected allow rules %q, got %q", want, specs(reloaded.AllowRules))
+	}
+
+	writeFile("allowlist:\n  - id: x\n    domain: [a.com]\n")
+	if _, err := reloaded.ReloadRules(); err == nil || !strings.Contains(err.Error(), "unknown rule field: domain") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestAppendAllowRule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "existing list",
+			input: `# Rules for the agent.
+allowlist:
+  - domain=github.com # code
+  - id: npm
+    domains: [registry.npmjs.org]
+log_level: info
+`,
+			want: `# Rules for the agent.
+allowlist:
+  - domain=github.com # code
+  - id: npm
+    domains: [registry.npmjs.org]
+  - domain=pypi.org
+log_level: info
+`,
+		},
+		{
+			name:  "no list",
+			input: "log_level: info\n",
+			want:  "log_level: info\nallowlist:\n  - domain=pypi.org\n",
+		},
+		{
+			name:  "empty list",
+			input: "allowlist: []\n",
+			want:  "allowlist:\n  - domain=pypi.org\n",
+		},
+		{
+			name:  "null list",
+			input: "allowlist:\n",
+			want:  "allowlist:\n  - domain=pypi.org\n",
+		},
+		{
+			name:  "empty file",
+			input: "",
+			want:  "allowlist:\n  - domain=pypi.org\n",
+		},
+		{
+			name:    "not a list",
+			input:   "allowlist: domain=github.com\n",
+			wantErr: "line 1: expected a list of rules",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tc.input), 0o600); err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+
+			err := AppendAllowRule(path, "domain=pypi.org")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read config file: %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("expected config file:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
@@ -32,7 +32,7 @@ func TestParseInjectTarget_ViaValidation(t *testing.T) {
 		},
 		{
 			name:    "unknown key",
-			input:   "domain=example.com port=443",
+			input:   "domain=example.com color=blue",
 			wantErr: true,
 		},
 	}
@@ -451,5 +451,8 @@ func TestBuildSessionCorrelation_AgentURLFallback(t *testing.T) {
 func baseCliConfig() CliConfig {
 	c := CliConfig{}
 	_ = c.JailType.Set("nsjail")
+	_ = c.PathMode.Set("normalize")
+	_ = c.OnDeny.Set("deny")
+	_ = c.Enforcement.Set("enforce")
 	return c
 }
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// WritableBy reports whether the user uid, whose primary group is gid, can change the file at path: write to it,
+// or replace it through one of the directories it is in. Root can change anything.
+func WritableBy(path string, uid, gid int) (bool, error) {
+	if uid == 0 {
+		return true, nil
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+
+	groups := []string{strconv.Itoa(gid)}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		if ids, err := u.GroupIds(); err == nil {
+			groups = append(groups, ids...)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if canWrite(info, uid, groups) {
+		return true, nil
+	}
+	for {
+		entry := info
+		dir := filepath.Dir(path)
+		info, err = os.Stat(dir)
+		if err != nil {
+			return false, err
+		}
+		// In a sticky directory, like /tmp, only the owners of an entry or of the directory can rename it.
+		sticky := info.Mode()&os.ModeSticky != 0
+		if canWrite(info, uid, groups) && (!sticky || owner(info) == uid || owner(entry) == uid) {
+			return true, nil
+		}
+		if dir == path {
+			return false, nil
+		}
+		path = dir
+	}
+}
+
+// canWrite reports whether the user uid, in groups, can write to the file of info. Owners can, since they can
+// change its mode.
+func canWrite(info os.FileInfo, uid int, groups []string) bool {
+	st := info.Sys().(*syscall.Stat_t)
+	perm := info.Mode().Perm()
+	switch {
+	case int(st.Uid) == uid:
+		return true
+	case slices.Contains(groups, strconv.Itoa(int(st.Gid))):
+		return perm&0o020 != 0
+	default:
+		return perm&0o002 != 0
+	}
+}
+
+func owner(info os.FileInfo) int {
+	return int(info.Sys().(*syscall.Stat_t).Uid)
+}
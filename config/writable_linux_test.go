@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritableBy(t *testing.T) {
+	t.Parallel()
+
+	const nobody = 65534
+	if os.Getuid() == nobody {
+		t.Skip("the test needs another user than nobody")
+	}
+
+	dir := t.TempDir()
+	sticky := filepath.Join(dir, "sticky")
+	open := filepath.Join(dir, "open")
+	for _, d := range []string{sticky, open} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Mkdir applies the umask, Chmod doesn't.
+	if err := os.Chmod(sticky, 0o777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		dir  string
+		perm os.FileMode
+		uid  int
+		want bool
+	}{
+		{name: "owner", dir: dir, perm: 0o400, uid: os.Getuid(), want: true},
+		{name: "root", dir: dir, perm: 0o400, uid: 0, want: true},
+		{name: "other", dir: dir, perm: 0o644, uid: nobody, want: false},
+		{name: "world writable", dir: dir, perm: 0o666, uid: nobody, want: true},
+		{name: "writable directory", dir: open, perm: 0o644, uid: nobody, want: true},
+		{name: "sticky directory", dir: sticky, perm: 0o644, uid: nobody, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tc.dir, tc.name+".yaml")
+			if err := os.WriteFile(path, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tc.perm); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := WritableBy(path, tc.uid, nobody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("WritableBy(%q, %d) = %v, want %v", path, tc.uid, got, tc.want)
+			}
+		})
+	}
+}
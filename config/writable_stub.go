@@ -0,0 +1,11 @@
+//go:build !linux
+
+package config
+
+import "errors"
+
+// WritableBy reports whether the user uid, whose primary group is gid, can change the file at path. It can't tell
+// on this platform.
+func WritableBy(path string, uid, gid int) (bool, error) {
+	return false, errors.New("can't tell who can write to files on this platform")
+}
@@ -14,12 +14,16 @@ import (
 
 	"github.com/coder/boundary/audit"
 	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/policy"
 	"github.com/coder/boundary/proxy"
 	"github.com/coder/boundary/rulesengine"
 )
 
 type LandJail struct {
 	proxyServer *proxy.Server
+	reloader    *policy.Reloader
+	approver    *policy.Approver // nil unless --on-deny=ask
+	learner     *policy.Learner  // nil unless --learn
 	logger      *slog.Logger
 	config      config.AppConfig
 }
@@ -40,11 +44,31 @@ func NewLandJail(
 		TLSConfig:    tlsConfig,
 		PprofEnabled: config.PprofEnabled,
 		PprofPort:    int(config.PprofPort),
+		PathMode:     config.PathMode,
+		Enforcement:  config.Enforcement,
 	})
 
+	// The reloader and approver change the policy of the running proxy.
+	reloader := policy.NewReloader(logger, config, proxyServer, auditor)
+	approver, err := policy.NewApprover(logger, config, reloader)
+	if err != nil {
+		return nil, err
+	}
+	if approver != nil {
+		proxyServer.SetApprover(approver)
+	}
+	var learner *policy.Learner
+	if config.Learn {
+		learner = policy.NewLearner()
+		proxyServer.SetLearner(learner)
+	}
+
 	return &LandJail{
 		config:      config,
 		proxyServer: proxyServer,
+		reloader:    reloader,
+		approver:    approver,
+		learner:     learner,
 		logger:      logger,
 	}, nil
 }
@@ -67,6 +91,10 @@ func (b *LandJail) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// Reload the policy on SIGHUP, or with --watch-config when the config file changes, without
+	// restarting the target process.
+	go b.reloader.Run(ctx)
+
 	// childErr receives the result of RunChildProcess so we can
 	// propagate the child's exit code to our caller.
 	childErr := make(chan error, 1)
@@ -170,5 +198,21 @@ func (b *LandJail) stopProxy() error {
 		}
 	}
 
+	// Stop asking about requests, which removes the ask socket
+	if b.approver != nil {
+		err := b.approver.Close()
+		if err != nil {
+			b.logger.Error("Failed to stop asking about denied requests", "error", err)
+		}
+	}
+
+	// Propose an allowlist once no more requests come in
+	if b.learner != nil {
+		err := b.learner.WriteProposal(b.logger, b.config.LearnOutput, os.Stdout)
+		if err != nil {
+			b.logger.Error("Failed to write the proposed allowlist", "error", err)
+		}
+	}
+
 	return nil
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/coder/boundary/audit"
 	"github.com/coder/boundary/config"
 	"github.com/coder/boundary/nsjail_manager/nsjail"
+	"github.com/coder/boundary/policy"
 	"github.com/coder/boundary/proxy"
 	"github.com/coder/boundary/rulesengine"
 )
@@ -22,6 +23,9 @@ import (
 type NSJailManager struct {
 	jailer      nsjail.Jailer
 	proxyServer *proxy.Server
+	reloader    *policy.Reloader
+	approver    *policy.Approver // nil unless --on-deny=ask
+	learner     *policy.Learner  // nil unless --learn
 	logger      *slog.Logger
 	config      config.AppConfig
 }
@@ -43,12 +47,32 @@ func NewNSJailManager(
 		TLSConfig:    tlsConfig,
 		PprofEnabled: config.PprofEnabled,
 		PprofPort:    int(config.PprofPort),
+		PathMode:     config.PathMode,
+		Enforcement:  config.Enforcement,
 	})
 
+	// The reloader and approver change the policy of the running proxy.
+	reloader := policy.NewReloader(logger, config, proxyServer, auditor)
+	approver, err := policy.NewApprover(logger, config, reloader)
+	if err != nil {
+		return nil, err
+	}
+	if approver != nil {
+		proxyServer.SetApprover(approver)
+	}
+	var learner *policy.Learner
+	if config.Learn {
+		learner = policy.NewLearner()
+		proxyServer.SetLearner(learner)
+	}
+
 	return &NSJailManager{
 		config:      config,
 		jailer:      jailer,
 		proxyServer: proxyServer,
+		reloader:    reloader,
+		approver:    approver,
+		learner:     learner,
 		logger:      logger,
 	}, nil
 }
@@ -71,6 +95,10 @@ func (b *NSJailManager) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// Reload the policy on SIGHUP, or with --watch-config when the config file changes, without
+	// restarting the target process.
+	go b.reloader.Run(ctx)
+
 	// childErr receives the result of RunChildProcess so we can
 	// propagate the child's exit code to our caller.
 	childErr := make(chan error, 1)
@@ -168,6 +196,22 @@ func (b *NSJailManager) stopProxyAndCleanupHost() error {
 		}
 	}
 
+	// Stop asking about requests, which removes the ask socket
+	if b.approver != nil {
+		err := b.approver.Close()
+		if err != nil {
+			b.logger.Error("Failed to stop asking about denied requests", "error", err)
+		}
+	}
+
+	// Propose an allowlist once no more requests come in
+	if b.learner != nil {
+		err := b.learner.WriteProposal(b.logger, b.config.LearnOutput, os.Stdout)
+		if err != nil {
+			b.logger.Error("Failed to write the proposed allowlist", "error", err)
+		}
+	}
+
 	// Close jailer
 	return b.jailer.Close()
 }
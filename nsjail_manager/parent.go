@@ -2,13 +2,14 @@ package nsjail_manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/coder/boundary/audit"
 	"github.com/coder/boundary/config"
 	"github.com/coder/boundary/nsjail_manager/nsjail"
-	"github.com/coder/boundary/rulesengine"
+	"github.com/coder/boundary/policy"
 	"github.com/coder/boundary/tls"
 )
 
@@ -17,16 +18,16 @@ func RunParent(ctx context.Context, logger *slog.Logger, config config.AppConfig
 		logger.Warn("No allow rules specified; all network traffic will be denied by default")
 	}
 
-	// Parse allow rules
-	allowRules, err := rulesengine.ParseAllowSpecs(config.AllowRules)
+	// Parse allow and deny rules, reporting policy problems before anything runs; in strict mode they are fatal
+	ruleEngine, err := policy.Load(ctx, logger, config)
+	if errors.Is(err, policy.ErrStrict) {
+		return fmt.Errorf("refusing to start in strict mode: %v", err)
+	}
 	if err != nil {
-		logger.Error("Failed to parse allow rules", "error", err)
-		return fmt.Errorf("failed to parse allow rules: %v", err)
+		logger.Error("Failed to parse rules", "error", err)
+		return err
 	}
 
-	// Create rule engine
-	ruleEngine := rulesengine.NewRuleEngine(allowRules, logger)
-
 	// Create auditor
 	auditor, err := audit.SetupAuditor(ctx, logger, config.DisableAuditLogs, config.LogProxySocketPath, config.SessionID)
 	if err != nil {
@@ -0,0 +1,585 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/proxy"
+	"github.com/coder/boundary/rulesengine"
+)
+
+// Decision is the answer to a request that no rule allows, with --on-deny=ask.
+type Decision int
+
+const (
+	// DecisionDeny denies the request.
+	DecisionDeny Decision = iota
+	// DecisionOnce allows the request, and nothing else.
+	DecisionOnce
+	// DecisionSession allows the request and adds its rule to the policy until boundary exits.
+	DecisionSession
+	// DecisionPersist allows the request and adds its rule to the allowlist of the config file.
+	DecisionPersist
+)
+
+// ParseDecision parses an answer: deny, once, session or persist, or their first letter.
+func ParseDecision(s string) (Decision, error) {
+	switch strings.ToLower(s) {
+	case "d", "deny":
+		return DecisionDeny, nil
+	case "o", "once":
+		return DecisionOnce, nil
+	case "s", "session":
+		return DecisionSession, nil
+	case "p", "persist":
+		return DecisionPersist, nil
+	default:
+		return DecisionDeny, fmt.Errorf("invalid decision: %q", s)
+	}
+}
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionOnce:
+		return "once"
+	case DecisionSession:
+		return "session"
+	case DecisionPersist:
+		return "persist"
+	default:
+		return "deny"
+	}
+}
+
+// Question asks about a request that no rule allows. Over the ask socket, it is sent as a line of JSON and
+// answered with an Answer.
+type Question struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Rule is the allow rule that approving the request for the session, or persisting it, adds.
+	Rule string `json:"rule"`
+}
+
+// Answer is the reply to the Question with the same ID. Decision is parsed with ParseDecision.
+type Answer struct {
+	ID       int    `json:"id"`
+	Decision string `json:"decision"`
+}
+
+func (q Question) prompt() string {
+	return fmt.Sprintf("boundary: %s %s is not allowed.\n  Allow [o]nce, for the [s]ession, [p]ersist %q to the config file, or [d]eny? ", q.Method, q.URL, q.Rule)
+}
+
+// prompter puts questions to the person running boundary.
+type prompter interface {
+	// ask returns the decision on q, or an error if there was none before ctx was done.
+	ask(ctx context.Context, q Question) (Decision, error)
+	Close() error
+}
+
+// Approver asks about requests that no rule allows, and adds the rules that get approved to the policy. It
+// implements proxy.Approver.
+type Approver struct {
+	logger   *slog.Logger
+	reloader *Reloader
+	prompter prompter
+	timeout  time.Duration
+
+	// turn is held by the request being asked about, so that there is one question at a time.
+	turn   chan struct{}
+	nextID int
+}
+
+var _ proxy.Approver = (*Approver)(nil)
+
+// NewApprover creates an approver that asks over the Unix socket cfg.AskSocket, or on the controlling terminal
+// if it is empty; the token that connections to the socket need is printed on stderr. Requests not decided on
+// within cfg.AskTimeout are denied. Approved rules are added through
+// reloader. It returns nil unless cfg.OnDeny is config.OnDenyAsk.
+func NewApprover(logger *slog.Logger, cfg config.AppConfig, reloader *Reloader) (*Approver, error) {
+	if cfg.OnDeny != config.OnDenyAsk {
+		return nil, nil
+	}
+
+	var p prompter
+	if cfg.AskSocket != "" {
+		token, err := newAskToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ask socket token: %v", err)
+		}
+		p, err = listenSocketPrompter(cfg.AskSocket, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on ask socket: %v", err)
+		}
+		// The token goes to the terminal before the jailed command starts, so that the command never gets to see it.
+		_, _ = fmt.Fprintf(os.Stderr, "boundary: answer questions with `boundary approve --socket %s`, token %s\n", cfg.AskSocket, token)
+	} else {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("--on-deny=ask needs a controlling terminal, or --ask-socket: %v", err)
+		}
+		p = newTerminalPrompter(tty, tty, tty)
+	}
+	return newApprover(logger, p, cfg.AskTimeout, reloader), nil
+}
+
+func newApprover(logger *slog.Logger, p prompter, timeout time.Duration, reloader *Reloader) *Approver {
+	return &Approver{
+		logger:   logger,
+		reloader: reloader,
+		prompter: p,
+		timeout:  timeout,
+		turn:     make(chan struct{}, 1),
+	}
+}
+
+// Approve implements proxy.Approver. It waits for the request's turn to be asked about, and denies it when there
+// is no decision within the timeout.
+func (a *Approver) Approve(req rulesengine.Request) rulesengine.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	select {
+	case a.turn <- struct{}{}:
+		defer func() { <-a.turn }()
+	case <-ctx.Done():
+		a.logger.Warn("No decision in time, denying", "method", req.Method, "url", req.URL)
+		return rulesengine.Result{}
+	}
+
+	// An earlier question may have added a rule for this request while it waited.
+	if result := a.reloader.server.RuleEngine().EvaluateRequest(req); result.Allowed {
+		return result
+	}
+
+	rule, err := ruleFor(req.URL)
+	if err != nil {
+		a.logger.Warn("Can't ask about request", "method", req.Method, "url", req.URL, "error", err)
+		return rulesengine.Result{}
+	}
+	a.nextID++
+	decision, err := a.prompter.ask(ctx, Question{ID: a.nextID, Method: req.Method, URL: req.URL, Rule: rule})
+	if err != nil {
+		a.logger.Warn("No decision, denying", "method", req.Method, "url", req.URL, "error", err)
+		return rulesengine.Result{}
+	}
+
+	reason := fmt.Sprintf("approved %s: %s", decision, rule)
+	switch decision {
+	case DecisionOnce:
+		return rulesengine.Result{Allowed: true}
+	case DecisionPersist:
+		err := a.reloader.Persist(ctx, rule, reason)
+		if err == nil {
+			return a.granted(req, rule)
+		}
+		a.logger.Error("Failed to persist approved rule, allowing it for the session", "rule", rule, "error", err)
+		fallthrough
+	case DecisionSession:
+		if err := a.reloader.AllowForSession(rule, reason); err != nil {
+			a.logger.Error("Failed to add approved rule, allowing the request once", "rule", rule, "error", err)
+			return rulesengine.Result{Allowed: true}
+		}
+		return a.granted(req, rule)
+	default:
+		return rulesengine.Result{}
+	}
+}
+
+// granted returns the result of req under the policy rule was just added to, so that the request gets the limit
+// and metadata of the rule that allows it, like the requests after it.
+func (a *Approver) granted(req rulesengine.Request, rule string) rulesengine.Result {
+	if result := a.reloader.server.RuleEngine().EvaluateRequest(req); result.Allowed {
+		return result
+	}
+	return rulesengine.Result{Allowed: true, Rule: rule}
+}
+
+// Close stops asking. Requests waiting for a decision are denied.
+func (a *Approver) Close() error {
+	return a.prompter.Close()
+}
+
+// ruleFor returns the allow rule for the host of url, which approving it for the session or persisting it adds.
+func ruleFor(url string) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", errors.New("request has no host")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return "ip=" + addr.String(), nil
+	}
+	return "domain=" + host, nil
+}
+
+// terminalPrompter asks on a terminal, and reads the answers from it.
+type terminalPrompter struct {
+	out    io.Writer
+	closer io.Closer
+	lines  chan string
+}
+
+func newTerminalPrompter(in io.Reader, out io.Writer, closer io.Closer) *terminalPrompter {
+	p := &terminalPrompter{out: out, closer: closer, lines: make(chan string)}
+	go func() {
+		defer close(p.lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			p.lines <- scanner.Text()
+		}
+	}()
+	return p
+}
+
+func (p *terminalPrompter) ask(ctx context.Context, q Question) (Decision, error) {
+	// Drop a line typed while nothing was asked, like a late answer to a question that timed out.
+	select {
+	case <-p.lines:
+	default:
+	}
+
+	_, _ = fmt.Fprintf(p.out, "\n%s", q.prompt())
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return DecisionDeny, io.EOF
+			}
+			decision, err := ParseDecision(strings.TrimSpace(line))
+			if err != nil {
+				_, _ = fmt.Fprint(p.out, "  Please answer o, s, p or d: ")
+				continue
+			}
+			return decision, nil
+		case <-ctx.Done():
+			_, _ = fmt.Fprintln(p.out, "\n  No answer in time, denied.")
+			return DecisionDeny, ctx.Err()
+		}
+	}
+}
+
+func (p *terminalPrompter) Close() error {
+	return p.closer.Close()
+}
+
+// socketPrompter asks whoever is connected to a Unix socket, usually `boundary approve`. The jailed command can
+// reach the socket too, so a connection has to send the token of the run first, and only one is held at a time:
+// others are turned away until it is gone.
+type socketPrompter struct {
+	listener net.Listener
+	token    string
+	// done is closed once the listener is.
+	done chan struct{}
+
+	mu sync.Mutex
+	// conn is the connection questions go to, or nil.
+	conn *askConn
+	// connected is closed when conn is set, and replaced once it is gone.
+	connected chan struct{}
+}
+
+// askConn is an authenticated connection to the ask socket.
+type askConn struct {
+	net.Conn
+	// answers receives the lines read from the connection. Those that come while nobody waits for them are
+	// dropped.
+	answers chan []byte
+	// gone is closed once the connection is.
+	gone chan struct{}
+}
+
+// askHello is the first line sent on a connection to the ask socket, and askWelcome the reply to it. Error is
+// set if the connection is turned away.
+type askHello struct {
+	Token string `json:"token"`
+}
+
+type askWelcome struct {
+	Error string `json:"error,omitempty"`
+}
+
+// askHelloTimeout is how long a connection to the ask socket has to send its askHello.
+const askHelloTimeout = 10 * time.Second
+
+// newAskToken returns a random token for the ask socket.
+func newAskToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func listenSocketPrompter(path, token string) (*socketPrompter, error) {
+	// A socket left over from an earlier run would make listening fail.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The questions show what the jailed command is up to, and the answers change the policy.
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	p := &socketPrompter{
+		listener:  listener,
+		token:     token,
+		done:      make(chan struct{}),
+		connected: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p, nil
+}
+
+// serve checks the token sent on conn and, unless another connection is held, makes it the one questions go to
+// until it is closed.
+func (p *socketPrompter) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(askHelloTimeout))
+	// ReadSlice keeps the line to the size of the buffer.
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	var hello askHello
+	if err := json.Unmarshal(line, &hello); err != nil || subtle.ConstantTimeCompare([]byte(hello.Token), []byte(p.token)) != 1 {
+		welcome(conn, "wrong token")
+		return
+	}
+
+	c := &askConn{Conn: conn, answers: make(chan []byte, 16), gone: make(chan struct{})}
+	p.mu.Lock()
+	if p.conn != nil {
+		p.mu.Unlock()
+		welcome(conn, "another boundary approve is connected")
+		return
+	}
+	p.conn = c
+	close(p.connected)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.conn = nil
+		p.connected = make(chan struct{})
+		p.mu.Unlock()
+		close(c.gone)
+	}()
+
+	welcome(conn, "")
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		select {
+		case c.answers <- line:
+		default:
+		}
+	}
+}
+
+func welcome(conn net.Conn, reason string) {
+	line, _ := json.Marshal(askWelcome{Error: reason})
+	_ = conn.SetWriteDeadline(time.Now().Add(askHelloTimeout))
+	_, _ = conn.Write(append(line, '\n'))
+	_ = conn.SetWriteDeadline(time.Time{})
+}
+
+func (p *socketPrompter) ask(ctx context.Context, q Question) (Decision, error) {
+	question, err := json.Marshal(q)
+	if err != nil {
+		return DecisionDeny, err
+	}
+
+	for {
+		// Wait for a connection.
+		p.mu.Lock()
+		c, connected := p.conn, p.connected
+		p.mu.Unlock()
+		if c == nil {
+			select {
+			case <-connected:
+				continue
+			case <-p.done:
+				return DecisionDeny, net.ErrClosed
+			case <-ctx.Done():
+				return DecisionDeny, ctx.Err()
+			}
+		}
+
+		decision, err := p.exchange(ctx, c, question, q.ID)
+		if errors.Is(err, errConnGone) {
+			// Ask the next one.
+			continue
+		}
+		return decision, err
+	}
+}
+
+var (
+	errInvalidAnswer = errors.New("invalid answer")
+	errConnGone      = errors.New("connection gone")
+)
+
+// exchange sends question on c and reads answers until the one for id, or until ctx is done. Answers to earlier
+// questions, which came too late, are skipped.
+func (p *socketPrompter) exchange(ctx context.Context, c *askConn, question []byte, id int) (Decision, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.SetWriteDeadline(deadline); err != nil {
+		return DecisionDeny, err
+	}
+	if _, err := c.Write(append(question, '\n')); err != nil {
+		if ctx.Err() != nil {
+			return DecisionDeny, ctx.Err()
+		}
+		// serve notices the connection is gone once it is closed.
+		_ = c.Close()
+		<-c.gone
+		return DecisionDeny, errConnGone
+	}
+	for {
+		select {
+		case line := <-c.answers:
+			var answer Answer
+			if err := json.Unmarshal(line, &answer); err != nil {
+				return DecisionDeny, fmt.Errorf("%w: %v", errInvalidAnswer, err)
+			}
+			if answer.ID != id {
+				continue
+			}
+			decision, err := ParseDecision(answer.Decision)
+			if err != nil {
+				return DecisionDeny, fmt.Errorf("%w: %v", errInvalidAnswer, err)
+			}
+			return decision, nil
+		case <-c.gone:
+			return DecisionDeny, errConnGone
+		case <-ctx.Done():
+			return DecisionDeny, ctx.Err()
+		}
+	}
+}
+
+// disconnect closes the connection questions go to, if any.
+func (p *socketPrompter) disconnect() {
+	p.mu.Lock()
+	c := p.conn
+	p.mu.Unlock()
+	if c != nil {
+		_ = c.Close()
+	}
+}
+
+func (p *socketPrompter) Close() error {
+	err := p.listener.Close()
+	p.disconnect()
+	return err
+}
+
+// AnswerQuestions connects to the ask socket at path and puts each question it gets to the person at in and out,
+// until the connection is closed. It is the other end of --ask-socket. The first line of in is the token boundary
+// printed when it started.
+func AnswerQuestions(path string, in io.Reader, out io.Writer) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to connect to ask socket: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	answers := bufio.NewScanner(in)
+	questions := bufio.NewScanner(conn)
+	_, _ = fmt.Fprint(out, "Token printed by boundary: ")
+	if !answers.Scan() {
+		if err := answers.Err(); err != nil {
+			return err
+		}
+		return errors.New("no token")
+	}
+	hello, err := json.Marshal(askHello{Token: strings.TrimSpace(answers.Text())})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(hello, '\n')); err != nil {
+		return fmt.Errorf("failed to send token: %v", err)
+	}
+	if !questions.Scan() {
+		if err := questions.Err(); err != nil {
+			return fmt.Errorf("failed to connect to ask socket: %v", err)
+		}
+		return errors.New("failed to connect to ask socket: connection closed")
+	}
+	var welcome askWelcome
+	if err := json.Unmarshal(questions.Bytes(), &welcome); err != nil {
+		return fmt.Errorf("invalid reply: %v", err)
+	}
+	if welcome.Error != "" {
+		return fmt.Errorf("boundary refused the connection: %s", welcome.Error)
+	}
+
+	_, _ = fmt.Fprintf(out, "Waiting for requests to approve on %s\n", path)
+	for questions.Scan() {
+		var q Question
+		if err := json.Unmarshal(questions.Bytes(), &q); err != nil {
+			return fmt.Errorf("invalid question: %v", err)
+		}
+
+		_, _ = fmt.Fprintf(out, "\n%s", q.prompt())
+		var decision Decision
+		for {
+			if !answers.Scan() {
+				return answers.Err()
+			}
+			decision, err = ParseDecision(strings.TrimSpace(answers.Text()))
+			if err == nil {
+				break
+			}
+			_, _ = fmt.Fprint(out, "  Please answer o, s, p or d: ")
+		}
+
+		answer, err := json.Marshal(Answer{ID: q.ID, Decision: decision.String()})
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(append(answer, '\n')); err != nil {
+			return fmt.Errorf("failed to send answer: %v", err)
+		}
+	}
+	return questions.Err()
+}
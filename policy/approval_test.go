@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedPrompter answers with the next decision, or not at all once it runs out.
+type scriptedPrompter struct {
+	decisions []Decision
+	asked     []Question
+}
+
+func (p *scriptedPrompter) ask(ctx context.Context, q Question) (Decision, error) {
+	p.asked = append(p.asked, q)
+	if len(p.decisions) == 0 {
+		<-ctx.Done()
+		return DecisionDeny, ctx.Err()
+	}
+	d := p.decisions[0]
+	p.decisions = p.decisions[1:]
+	return d, nil
+}
+
+func (p *scriptedPrompter) Close() error { return nil }
+
+func request(url string) rulesengine.Request {
+	return rulesengine.Request{Method: "GET", URL: url}
+}
+
+func TestParseDecision(t *testing.T) {
+	for input, want := range map[string]Decision{
+		"d": DecisionDeny, "deny": DecisionDeny,
+		"o": DecisionOnce, "ONCE": DecisionOnce,
+		"s": DecisionSession, "session": DecisionSession,
+		"p": DecisionPersist, "persist": DecisionPersist,
+	} {
+		got, err := ParseDecision(input)
+		require.NoError(t, err, input)
+		require.Equal(t, want, got, input)
+	}
+	_, err := ParseDecision("yes")
+	require.ErrorContains(t, err, `invalid decision: "yes"`)
+}
+
+func TestRuleFor(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://PyPI.org/simple/requests/": "domain=pypi.org",
+		"http://10.0.0.1:8080/":             "ip=10.0.0.1",
+		"https://[::1]/":                    "ip=::1",
+	} {
+		got, err := ruleFor(url)
+		require.NoError(t, err, url)
+		require.Equal(t, want, got, url)
+	}
+}
+
+func TestApprover(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false)
+	prompter := &scriptedPrompter{decisions: []Decision{DecisionOnce, DecisionDeny, DecisionSession, DecisionPersist}}
+	approver := newApprover(slog.New(slog.NewTextHandler(io.Discard, nil)), prompter, time.Second, reloader)
+
+	// Once allows just the request.
+	result := approver.Approve(request("https://pypi.org/simple/"))
+	require.True(t, result.Allowed)
+	require.Empty(t, result.Rule)
+	require.False(t, server.RuleEngine().Evaluate("GET", "https://pypi.org/simple/").Allowed)
+
+	result = approver.Approve(request("https://pypi.org/simple/"))
+	require.False(t, result.Allowed)
+	require.Empty(t, result.Rule)
+
+	// A session approval adds the rule to the policy, but not to the config file.
+	result = approver.Approve(request("https://pypi.org/simple/"))
+	require.True(t, result.Allowed)
+	require.Equal(t, "domain=pypi.org", result.Rule)
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://pypi.org/simple/").Allowed)
+	changes := auditor.getChanges()
+	require.Len(t, changes, 1)
+	require.Equal(t, "approved session: domain=pypi.org", changes[0].Reason)
+	require.Equal(t, 2, changes[0].Rules)
+
+	// The rule is there now, so there's nothing to ask.
+	result = approver.Approve(request("https://pypi.org/project/"))
+	require.True(t, result.Allowed)
+	require.Equal(t, "domain=pypi.org", result.Rule)
+	require.Len(t, prompter.asked, 3)
+
+	// A persisted approval goes to the config file, and session rules survive the reload.
+	result = approver.Approve(request("https://registry.npmjs.org/left-pad"))
+	require.True(t, result.Allowed)
+	require.Equal(t, "domain=registry.npmjs.org", result.Rule)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "allowlist:\n  - domain=github.com\n  - domain=registry.npmjs.org\n", string(data))
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://registry.npmjs.org/").Allowed)
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://pypi.org/").Allowed)
+	require.Equal(t, "approved persist: domain=registry.npmjs.org", auditor.getChanges()[1].Reason)
+
+	// Without an answer in time, the request is denied.
+	start := time.Now()
+	approver.timeout = 50 * time.Millisecond
+	require.False(t, approver.Approve(request("https://example.com/")).Allowed)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestTerminalPrompter(t *testing.T) {
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	var out strings.Builder
+	prompter := newTerminalPrompter(inR, &out, inR)
+	defer prompter.Close()
+
+	go func() {
+		_, _ = io.WriteString(inW, "yes\ns\n")
+	}()
+	decision, err := prompter.ask(context.Background(), Question{Method: "GET", URL: "https://pypi.org/", Rule: "domain=pypi.org"})
+	require.NoError(t, err)
+	require.Equal(t, DecisionSession, decision)
+	require.Contains(t, out.String(), `GET https://pypi.org/ is not allowed.`)
+	require.Contains(t, out.String(), `[p]ersist "domain=pypi.org"`)
+	require.Contains(t, out.String(), "Please answer o, s, p or d")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	decision, err = prompter.ask(ctx, Question{Method: "GET", URL: "https://example.com/"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, DecisionDeny, decision)
+}
+
+func TestSocketPrompter(t *testing.T) {
+	// Unix socket paths are short, too short for most test temp dirs.
+	dir, err := os.MkdirTemp("", "boundary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ask.sock")
+
+	prompter, err := listenSocketPrompter(path, "secret")
+	require.NoError(t, err)
+	defer prompter.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Nobody is connected yet, so nobody answers.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = prompter.ask(ctx, Question{ID: 1})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Without the token, the connection is turned away.
+	err = AnswerQuestions(path, strings.NewReader("guess\np\n"), io.Discard)
+	require.ErrorContains(t, err, "wrong token")
+
+	var out strings.Builder
+	done := make(chan error, 1)
+	go func() {
+		done <- AnswerQuestions(path, strings.NewReader("secret\np\n"), &out)
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	decision, err := prompter.ask(ctx, Question{ID: 2, Method: "POST", URL: "https://api.openai.com/v1/responses", Rule: "domain=api.openai.com"})
+	require.NoError(t, err)
+	require.Equal(t, DecisionPersist, decision)
+
+	// A second connection doesn't take over from the first, even with the token.
+	err = AnswerQuestions(path, strings.NewReader("secret\no\n"), io.Discard)
+	require.ErrorContains(t, err, "another boundary approve is connected")
+
+	// The answering side is done once boundary drops the connection.
+	prompter.disconnect()
+	require.NoError(t, <-done)
+	require.Contains(t, out.String(), "POST https://api.openai.com/v1/responses is not allowed.")
+
+	// Once it is gone, the next connection gets the questions.
+	go func() {
+		done <- AnswerQuestions(path, strings.NewReader("secret\ns\n"), io.Discard)
+	}()
+	decision, err = prompter.ask(ctx, Question{ID: 3, Method: "GET", URL: "https://example.com/", Rule: "domain=example.com"})
+	require.NoError(t, err)
+	require.Equal(t, DecisionSession, decision)
+	prompter.disconnect()
+	require.NoError(t, <-done)
+}
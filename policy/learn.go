@@ -0,0 +1,285 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coder/boundary/rulesengine"
+	"golang.org/x/net/publicsuffix"
+	"gopkg.in/yaml.v3"
+)
+
+// maxLearnedPaths is how many path patterns a proposed rule lists before it allows every path of its hosts instead.
+const maxLearnedPaths = 8
+
+// Learner records the requests the rules deny while boundary learns the policy (--learn), and proposes an
+// allowlist that allows them. It implements proxy.Learner.
+type Learner struct {
+	mu sync.Mutex
+	// denied are the requests no rule allowed.
+	denied map[learnedRequest]struct{}
+	// blocked are the requests a deny rule blocked. No allow rule changes that, so they aren't proposed.
+	blocked map[blockedRequest]struct{}
+}
+
+type learnedRequest struct {
+	method string
+	host   string
+	path   string
+}
+
+type blockedRequest struct {
+	method string
+	url    string
+	rule   string
+}
+
+// NewLearner creates a learner that hasn't seen any requests yet.
+func NewLearner() *Learner {
+	return &Learner{
+		denied:  make(map[learnedRequest]struct{}),
+		blocked: make(map[blockedRequest]struct{}),
+	}
+}
+
+// Learn records a request the rules denied.
+func (l *Learner) Learn(req rulesengine.Request, result rulesengine.Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if result.Rule != "" {
+		l.blocked[blockedRequest{method: req.Method, url: req.URL, rule: result.Rule}] = struct{}{}
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	l.denied[learnedRequest{
+		method: strings.ToUpper(req.Method),
+		host:   rulesengine.CanonicalHost(u.Hostname()),
+		path:   path,
+	}] = struct{}{}
+}
+
+// Proposal returns an allowlist for the config file that allows every request the rules denied by default. Hosts
+// sharing a registrable parent domain are collapsed into a wildcard for it, and paths sharing a first segment into
+// a prefix. Requests a deny rule blocked are listed in comments.
+func (l *Learner) Proposal() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	specs := proposeRules(slices.Collect(maps.Keys(l.denied)))
+
+	var buf bytes.Buffer
+	if len(specs) == 0 {
+		buf.WriteString("# boundary --learn saw no requests that the rules deny by default.\n")
+	} else {
+		fmt.Fprintf(&buf, "# Proposed by boundary --learn from %d denied requests.\n", len(l.denied))
+		buf.WriteString("# Review the rules before adding them to your config file.\n")
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(struct {
+		Allowlist []string `yaml:"allowlist"`
+	}{Allowlist: specs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the allowlist: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode the allowlist: %v", err)
+	}
+
+	if len(l.blocked) > 0 {
+		blocked := slices.SortedFunc(maps.Keys(l.blocked), func(a, b blockedRequest) int {
+			return strings.Compare(a.url+" "+a.method, b.url+" "+b.method)
+		})
+		buf.WriteString("# These requests were blocked by deny rules, which the allowlist can't change:\n")
+		for _, b := range blocked {
+			fmt.Fprintf(&buf, "#   %s %s (%s)\n", b.method, b.url, b.rule)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteProposal writes the proposal to path, or to stdout if path is empty.
+func (l *Learner) WriteProposal(logger *slog.Logger, path string, stdout io.Writer) error {
+	data, err := l.Proposal()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err = stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write the proposed allowlist: %v", err)
+	}
+	logger.Info("Wrote the proposed allowlist", "path", path)
+	return nil
+}
+
+// proposeRules returns de-duplicated allow rules, sorted by host, that allow the requests.
+func proposeRules(requests []learnedRequest) []string {
+	hosts := make(map[string]struct{})
+	for _, req := range requests {
+		hosts[req.host] = struct{}{}
+	}
+	hostKeys := collapseHosts(slices.Collect(maps.Keys(hosts)))
+
+	type group struct {
+		methods map[string]struct{}
+		paths   map[string]struct{}
+		reqs    []learnedRequest
+	}
+	groups := make(map[string]*group)
+	for _, req := range requests {
+		key := hostKeys[req.host]
+		g, ok := groups[key]
+		if !ok {
+			g = &group{methods: make(map[string]struct{}), paths: make(map[string]struct{})}
+			groups[key] = g
+		}
+		g.methods[req.method] = struct{}{}
+		g.paths[req.path] = struct{}{}
+		g.reqs = append(g.reqs, req)
+	}
+
+	var specs []string
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		g := groups[key]
+		methods := "method=" + strings.Join(slices.Sorted(maps.Keys(g.methods)), ",")
+		spec := strings.Join([]string{methods, key}, " ")
+		if paths := collapsePaths(slices.Collect(maps.Keys(g.paths))); paths != "" {
+			withPaths := spec + " path=" + paths
+			// Path patterns are easy to get subtly wrong, so a rule that doesn't allow every request it was
+			// proposed for allows all paths instead.
+			if allowsAll(withPaths, g.reqs) {
+				spec = withPaths
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// collapseHosts maps each host to the domain or ip key of the rule proposed for it. Two or more subdomains of a
+// registrable domain become a wildcard for it; the domain itself stays a rule of its own, since the wildcard
+// doesn't match it.
+func collapseHosts(hosts []string) map[string]string {
+	children := make(map[string]int)
+	for _, host := range hosts {
+		if parent, ok := parentDomain(host); ok {
+			children[parent]++
+		}
+	}
+
+	keys := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		if net.ParseIP(host) != nil {
+			keys[host] = "ip=" + host
+			continue
+		}
+		if parent, ok := parentDomain(host); ok && children[parent] >= 2 {
+			keys[host] = "domain=*." + parent
+			continue
+		}
+		keys[host] = "domain=" + host
+	}
+	return keys
+}
+
+// parentDomain returns the domain host is a direct subdomain of, if that is at least a registrable domain.
+// Wildcards for public suffixes like *.com or *.co.uk are never proposed.
+func parentDomain(host string) (string, bool) {
+	if net.ParseIP(host) != nil {
+		return "", false
+	}
+	_, parent, ok := strings.Cut(host, ".")
+	if !ok {
+		return "", false
+	}
+	if _, err := publicsuffix.EffectiveTLDPlusOne(parent); err != nil {
+		return "", false
+	}
+	return parent, true
+}
+
+// collapsePaths returns the value of a path key that matches the paths, or "" if the rule is better off without
+// one. Paths sharing a first segment become a prefix for it.
+func collapsePaths(paths []string) string {
+	bySegment := make(map[string][]string)
+	for _, p := range paths {
+		if p == "/" {
+			// The root path pattern matches every path.
+			return ""
+		}
+		segment, _, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+		bySegment[segment] = append(bySegment[segment], p)
+	}
+
+	var patterns []string
+	for _, segment := range slices.Sorted(maps.Keys(bySegment)) {
+		if !isPlainPath(segment) {
+			return ""
+		}
+		group := bySegment[segment]
+		prefix := "/" + segment
+		if len(group) == 1 && isPlainPath(group[0]) {
+			patterns = append(patterns, group[0])
+			continue
+		}
+		// A trailing * matches at least one more segment, so the prefix itself needs its own pattern.
+		if slices.Contains(group, prefix) {
+			patterns = append(patterns, prefix)
+		}
+		patterns = append(patterns, prefix+"/*")
+	}
+	if len(patterns) > maxLearnedPaths {
+		return ""
+	}
+	return strings.Join(patterns, ",")
+}
+
+// isPlainPath reports whether a path can be used as a path pattern as it is.
+func isPlainPath(p string) bool {
+	return !strings.ContainsAny(p, ", \t*") && !strings.HasSuffix(p, "/")
+}
+
+// allowsAll reports whether the allow rule spec allows every one of the requests.
+func allowsAll(spec string, reqs []learnedRequest) bool {
+	rules, err := rulesengine.ParseAllowSpecs([]string{spec})
+	if err != nil {
+		return false
+	}
+	engine := rulesengine.NewRuleEngine(rules, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, req := range reqs {
+		if !engine.Evaluate(req.method, "https://"+hostPort(req.host)+req.path).Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+func hostPort(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollapseHosts(t *testing.T) {
+	require.Equal(t, map[string]string{
+		"api.github.com":         "domain=*.github.com",
+		"uploads.github.com":     "domain=*.github.com",
+		"github.com":             "domain=github.com",
+		"pypi.org":               "domain=pypi.org",
+		"files.pythonhosted.org": "domain=files.pythonhosted.org",
+		// Never a wildcard for a public suffix.
+		"example.co.uk": "domain=example.co.uk",
+		"other.co.uk":   "domain=other.co.uk",
+		"10.0.0.1":      "ip=10.0.0.1",
+		"::1":           "ip=::1",
+	}, collapseHosts([]string{
+		"api.github.com", "uploads.github.com", "github.com", "pypi.org", "files.pythonhosted.org",
+		"example.co.uk", "other.co.uk", "10.0.0.1", "::1",
+	}))
+}
+
+func TestCollapsePaths(t *testing.T) {
+	for _, tc := range []struct {
+		paths []string
+		want  string
+	}{
+		{paths: []string{"/simple/requests/"}, want: "/simple/*"},
+		{paths: []string{"/v1/models"}, want: "/v1/models"},
+		{paths: []string{"/v1/models", "/v1/responses", "/health"}, want: "/health,/v1/*"},
+		{paths: []string{"/repos", "/repos/coder/boundary"}, want: "/repos,/repos/*"},
+		// The root path allows every path anyway.
+		{paths: []string{"/", "/v1/models"}, want: ""},
+		{paths: []string{"/a,b/c"}, want: ""},
+		{paths: []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8", "/9"}, want: ""},
+	} {
+		require.Equal(t, tc.want, collapsePaths(tc.paths), "%v", tc.paths)
+	}
+}
+
+func TestLearnerProposal(t *testing.T) {
+	l := NewLearner()
+	denied := rulesengine.Result{}
+	for _, req := range []rulesengine.Request{
+		{Method: "GET", URL: "https://api.github.com/repos/coder/boundary"},
+		{Method: "POST", URL: "https://uploads.github.com/repos/coder/boundary/releases"},
+		{Method: "GET", URL: "https://PyPI.org/simple/requests/"},
+		{Method: "GET", URL: "https://pypi.org/simple/requests/"},
+		{Method: "GET", URL: "https://pypi.org/simple/urllib3/"},
+		{Method: "GET", URL: "http://10.0.0.1:8080/"},
+	} {
+		l.Learn(req, denied)
+	}
+	l.Learn(rulesengine.Request{Method: "GET", URL: "https://evil.com/"}, rulesengine.Result{Rule: "domain=evil.com"})
+
+	data, err := l.Proposal()
+	require.NoError(t, err)
+	require.Equal(t, `# Proposed by boundary --learn from 5 denied requests.
+# Review the rules before adding them to your config file.
+allowlist:
+  - method=GET,POST domain=*.github.com path=/repos/*
+  - method=GET domain=pypi.org path=/simple/*
+  - method=GET ip=10.0.0.1
+# These requests were blocked by deny rules, which the allowlist can't change:
+#   GET https://evil.com/ (domain=evil.com)
+`, string(data))
+
+	// The proposal allows everything that was denied.
+	path := filepath.Join(t.TempDir(), "proposal.yaml")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	require.NoError(t, l.WriteProposal(logger, path, nil))
+	_, reloader, server, _ := setup(t, "allowlist:\n  - domain=example.com\n", false)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(reloader.config.ConfigPath, data, 0o600))
+	require.NoError(t, reloader.Reload(t.Context(), "SIGHUP"))
+	require.True(t, server.RuleEngine().Evaluate("POST", "https://uploads.github.com/repos/coder/boundary/releases").Allowed)
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://pypi.org/simple/urllib3/").Allowed)
+	require.True(t, server.RuleEngine().Evaluate("GET", "http://10.0.0.1:8080/").Allowed)
+	require.False(t, server.RuleEngine().Evaluate("GET", "https://pypi.org/project/").Allowed)
+
+	var out bytes.Buffer
+	require.NoError(t, NewLearner().WriteProposal(logger, "", &out))
+	require.Equal(t, "# boundary --learn saw no requests that the rules deny by default.\nallowlist: []\n", out.String())
+}
+
+func TestLearnerCanonicalHosts(t *testing.T) {
+	l := NewLearner()
+	for _, url := range []string{
+		"https://Example.COM./",
+		"https://example.com/",
+		"https://bücher.de/",
+		"https://xn--bcher-kva.de/",
+	} {
+		l.Learn(rulesengine.Request{Method: "GET", URL: url}, rulesengine.Result{})
+	}
+
+	// Each host is proposed once, in the form rules match it in.
+	data, err := l.Proposal()
+	require.NoError(t, err)
+	require.Equal(t, `# Proposed by boundary --learn from 2 denied requests.
+# Review the rules before adding them to your config file.
+allowlist:
+  - method=GET domain=example.com
+  - method=GET domain=xn--bcher-kva.de
+`, string(data))
+}
@@ -0,0 +1,237 @@
+// Package policy loads the allow and deny rules of a boundary run, and reloads them into the running proxy when
+// the process gets SIGHUP, or with --watch-config when the config file changes.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coder/boundary/audit"
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/proxy"
+	"github.com/coder/boundary/rulesengine"
+)
+
+// ErrStrict is returned in strict mode for rules that linting finds problems with.
+var ErrStrict = errors.New("the rules have lint warnings or errors, run `boundary lint` for details")
+
+// defaultPollInterval is how often the config file is checked for changes.
+const defaultPollInterval = 2 * time.Second
+
+// Load parses the rules of cfg, logs what linting them finds and returns an engine for them. In strict mode,
+// rules with lint warnings or errors are refused with ErrStrict.
+func Load(ctx context.Context, logger *slog.Logger, cfg config.AppConfig) (rulesengine.Engine, error) {
+	rules, err := loadRules(ctx, logger, cfg)
+	if err != nil {
+		return rulesengine.Engine{}, err
+	}
+	return rulesengine.NewRuleEngine(rules, logger), nil
+}
+
+func loadRules(ctx context.Context, logger *slog.Logger, cfg config.AppConfig) ([]rulesengine.Rule, error) {
+	rules, err := cfg.Rules()
+	if err != nil {
+		return nil, err
+	}
+
+	findings := rulesengine.Lint(rules)
+	for _, f := range findings {
+		level := slog.LevelWarn
+		if f.Severity == rulesengine.SeverityInfo {
+			level = slog.LevelInfo
+		}
+		logger.Log(ctx, level, "Policy lint finding", "severity", f.Severity.String(), "check", f.Check, "rule", f.Rule, "message", f.Message)
+	}
+	if cfg.Strict && rulesengine.HasProblems(findings) {
+		return nil, ErrStrict
+	}
+
+	return rules, nil
+}
+
+// Reloader replaces the rule engine of a running proxy with one for the rules read again from the config, or with
+// rules added while it runs.
+type Reloader struct {
+	logger       *slog.Logger
+	server       *proxy.Server
+	auditor      audit.Auditor
+	pollInterval time.Duration
+
+	// mu serializes changes of the policy, so that config and the rules below always make up the policy the
+	// proxy enforces.
+	mu     sync.Mutex
+	config config.AppConfig
+	// rules are the rules of config, as the proxy enforces them.
+	rules []rulesengine.Rule
+	// sessionRules are allow rules added by AllowForSession. They come after the rules of config.
+	sessionRules []rulesengine.Rule
+	// file is the state of the config file when the reloader was created, see Run.
+	file fileState
+}
+
+// NewReloader creates a reloader for a proxy that was started with the rules of cfg. Policy changes are
+// reported to auditor if it implements audit.PolicyAuditor.
+func NewReloader(logger *slog.Logger, cfg config.AppConfig, server *proxy.Server, auditor audit.Auditor) *Reloader {
+	return &Reloader{
+		logger:       logger,
+		server:       server,
+		auditor:      auditor,
+		pollInterval: defaultPollInterval,
+		config:       cfg,
+		rules:        server.RuleEngine().Rules(),
+		file:         statFile(cfg.ConfigPath),
+	}
+}
+
+// Reload reads the rules again and switches the proxy over to them. Rules that don't parse, or that strict mode
+// refuses, are rejected: the proxy keeps the policy it has and the error is returned. reason says what triggered
+// the reload in the audit event.
+func (r *Reloader) Reload(ctx context.Context, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reloadLocked(ctx, reason)
+}
+
+func (r *Reloader) reloadLocked(ctx context.Context, reason string) error {
+	cfg, err := r.config.ReloadRules()
+	if err != nil {
+		return err
+	}
+	rules, err := loadRules(ctx, r.logger, cfg)
+	if err != nil {
+		return err
+	}
+	r.config = cfg
+	r.rules = rules
+	r.swapLocked(reason)
+	return nil
+}
+
+// AllowForSession adds an allow rule to the policy until boundary exits. It is kept across reloads, but not
+// written to the config file.
+func (r *Reloader) AllowForSession(spec, reason string) error {
+	rules, err := rulesengine.ParseAllowSpecs([]string{spec})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessionRules = append(r.sessionRules, rules...)
+	r.swapLocked(reason)
+	return nil
+}
+
+// Persist adds an allow rule to the allowlist of the config file and reloads the policy from it. It refuses to
+// if the jailed command could write to the file, since rules it adds itself would then be kept too.
+func (r *Reloader) Persist(ctx context.Context, spec, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.config.ConfigPath == "" {
+		return errors.New("there is no config file, set one with --config")
+	}
+	// The jailed command runs as the user boundary was started by.
+	writable, err := config.WritableBy(r.config.ConfigPath, r.config.UserInfo.Uid, r.config.UserInfo.Gid)
+	if err != nil {
+		return fmt.Errorf("failed to check who can write to the config file: %v", err)
+	}
+	if writable {
+		return errors.New("the jailed command can write to the config file, so rules aren't persisted to it")
+	}
+	if err := config.AppendAllowRule(r.config.ConfigPath, spec); err != nil {
+		return err
+	}
+	return r.reloadLocked(ctx, reason)
+}
+
+// swapLocked switches the proxy over to the rules of config and the session rules, and audits the change.
+func (r *Reloader) swapLocked(reason string) {
+	rules := append(slices.Clip(r.rules), r.sessionRules...)
+	engine := rulesengine.NewRuleEngine(rules, r.logger)
+
+	current := r.server.RuleEngine()
+	if engine.Hash() == current.Hash() {
+		r.logger.Info("Policy unchanged", "hash", current.Hash(), "reason", reason)
+		return
+	}
+
+	old := r.server.SetRuleEngine(engine)
+	if pa, ok := r.auditor.(audit.PolicyAuditor); ok {
+		pa.AuditPolicyChange(audit.PolicyChange{
+			OldHash: old.Hash(),
+			NewHash: engine.Hash(),
+			Rules:   len(rules),
+			Reason:  reason,
+		})
+	}
+}
+
+// Run reloads the policy whenever the process gets SIGHUP, and with WatchConfig whenever the config file changes,
+// until ctx is done. The file is polled rather than watched, since editors often replace it instead of writing to
+// it.
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// A jailed command that can write to the config file could allow itself anything if it was watched.
+	path := r.config.ConfigPath
+	var poll <-chan time.Time
+	if r.config.WatchConfig && path != "" {
+		ticker := time.NewTicker(r.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	last := r.file
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload(ctx, "SIGHUP")
+		case <-poll:
+			state := statFile(path)
+			if state == last {
+				continue
+			}
+			last = state
+			r.reload(ctx, "config file changed")
+		}
+	}
+}
+
+func (r *Reloader) reload(ctx context.Context, reason string) {
+	r.logger.Info("Reloading policy", "reason", reason)
+	if err := r.Reload(ctx, reason); err != nil {
+		r.logger.Error("Rejected the new policy, keeping the current one", "reason", reason, "error", err)
+	}
+}
+
+// fileState is what tells a config file apart from the one seen before. A missing file has the zero state.
+type fileState struct {
+	modTime int64
+	size    int64
+}
+
+func statFile(path string) fileState {
+	if path == "" {
+		return fileState{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
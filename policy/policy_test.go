@@ -0,0 +1,215 @@
+package policy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/boundary/audit"
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/proxy"
+	"github.com/stretchr/testify/require"
+)
+
+type policyAuditor struct {
+	mu      sync.Mutex
+	changes []audit.PolicyChange
+}
+
+func (a *policyAuditor) AuditRequest(audit.Request) {}
+
+func (a *policyAuditor) AuditPolicyChange(change audit.PolicyChange) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.changes = append(a.changes, change)
+}
+
+func (a *policyAuditor) getChanges() []audit.PolicyChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]audit.PolicyChange(nil), a.changes...)
+}
+
+// setup writes the config file and starts from its rules and the --allow rules allow, as a boundary run would.
+func setup(t *testing.T, content string, strict bool, allow ...string) (path string, reloader *Reloader, server *proxy.Server, auditor *policyAuditor) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	cli := config.CliConfig{}
+	require.NoError(t, cli.Config.Set(path))
+	require.NoError(t, cli.JailType.Set("nsjail"))
+	require.NoError(t, cli.PathMode.Set("normalize"))
+	require.NoError(t, cli.OnDeny.Set("deny"))
+	require.NoError(t, cli.Enforcement.Set("enforce"))
+	require.NoError(t, cli.Strict.Set(boolString(strict)))
+	for _, spec := range allow {
+		require.NoError(t, cli.AllowStrings.Set(spec))
+	}
+	cfg, err := config.NewAppConfigFromCliConfig(cli, nil, nil)
+	require.NoError(t, err)
+	// The jailed command runs as a user that can't write to the config file, so that rules can be persisted.
+	cfg.UserInfo = &config.UserInfo{Uid: 65534, Gid: 65534}
+	// serpent reads the file at startup; reading it again stands in for that.
+	cfg, err = cfg.ReloadRules()
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine, err := Load(context.Background(), logger, cfg)
+	require.NoError(t, err)
+
+	auditor = &policyAuditor{}
+	server = proxy.NewProxyServer(proxy.Config{RuleEngine: engine, Auditor: auditor, Logger: logger})
+	return path, NewReloader(logger, cfg, server, auditor), server, auditor
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func TestReload(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false)
+	startHash := server.RuleEngine().Hash()
+	require.False(t, server.RuleEngine().Evaluate("GET", "https://gitlab.com/").Allowed)
+
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=github.com\n  - domain=gitlab.com\n"), 0o600))
+	require.NoError(t, reloader.Reload(context.Background(), "SIGHUP"))
+
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://gitlab.com/").Allowed)
+	newHash := server.RuleEngine().Hash()
+	require.NotEqual(t, startHash, newHash)
+	require.Equal(t, []audit.PolicyChange{{OldHash: startHash, NewHash: newHash, Rules: 2, Reason: "SIGHUP"}}, auditor.getChanges())
+
+	// Reloading the same rules changes nothing.
+	require.NoError(t, reloader.Reload(context.Background(), "SIGHUP"))
+	require.Len(t, auditor.getChanges(), 1)
+
+	// An invalid config is rejected and the policy kept.
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=test..com\n"), 0o600))
+	require.ErrorContains(t, reloader.Reload(context.Background(), "SIGHUP"), "failed to parse domain")
+	require.Equal(t, newHash, server.RuleEngine().Hash())
+	require.Len(t, auditor.getChanges(), 1)
+}
+
+func TestReloadKeepsFlagRules(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false, "domain=gitlab.com until=1h")
+	startHash := server.RuleEngine().Hash()
+	rules, err := reloader.config.Rules()
+	require.NoError(t, err)
+	until := rules[1].Until
+	require.False(t, until.IsZero())
+
+	// The relative until= of the flag isn't measured again, so the policy is unchanged.
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, reloader.Reload(context.Background(), "SIGHUP"))
+	require.Equal(t, startHash, server.RuleEngine().Hash())
+	require.Empty(t, auditor.getChanges())
+
+	// The rules of the file are read again, and the flag's rule keeps its bound.
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=example.com\n"), 0o600))
+	require.NoError(t, reloader.Reload(context.Background(), "SIGHUP"))
+	require.Len(t, auditor.getChanges(), 1)
+	rules, err = reloader.config.Rules()
+	require.NoError(t, err)
+	require.Equal(t, []string{"domain=example.com", "domain=gitlab.com until=1h"}, []string{rules[0].Raw, rules[1].Raw})
+	require.Equal(t, until, rules[1].Until)
+}
+
+func TestAllowForSession(t *testing.T) {
+	_, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false, "domain=gitlab.com until=1h")
+	start := server.RuleEngine().Rules()
+
+	require.NoError(t, reloader.AllowForSession("domain=example.com", "approved"))
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://example.com/").Allowed)
+	// The rules the proxy was started with are kept as they were.
+	require.Equal(t, start, server.RuleEngine().Rules()[:len(start)])
+	require.Len(t, auditor.getChanges(), 1)
+
+	// The session's rule is kept across reloads, and reloading the same config changes nothing.
+	require.NoError(t, reloader.Reload(context.Background(), "SIGHUP"))
+	require.True(t, server.RuleEngine().Evaluate("GET", "https://example.com/").Allowed)
+	require.Len(t, auditor.getChanges(), 1)
+}
+
+func TestPersistRefusesWritableConfig(t *testing.T) {
+	path, reloader, server, _ := setup(t, "allowlist:\n  - domain=github.com\n", false)
+	// The file belongs to the user running the test, who the jailed command runs as now.
+	reloader.config.UserInfo = &config.UserInfo{Uid: os.Getuid(), Gid: os.Getgid()}
+
+	err := reloader.Persist(context.Background(), "domain=evil.com", "approved persist: domain=evil.com")
+	require.ErrorContains(t, err, "the jailed command can write to the config file")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "allowlist:\n  - domain=github.com\n", string(data))
+	require.False(t, server.RuleEngine().Evaluate("GET", "https://evil.com/").Allowed)
+}
+
+func TestReloadStrict(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", true)
+	hash := server.RuleEngine().Hash()
+
+	// A rule shadowed by another one is a lint warning, which strict mode refuses.
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=github.com\n  - domain=github.com method=GET\n"), 0o600))
+	require.ErrorIs(t, reloader.Reload(context.Background(), "SIGHUP"), ErrStrict)
+	require.Equal(t, hash, server.RuleEngine().Hash())
+	require.Empty(t, auditor.getChanges())
+}
+
+func TestRunReloadsChangedFile(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false)
+	reloader.pollInterval = 10 * time.Millisecond
+	reloader.config.WatchConfig = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloader.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// A different size is a change even if the modification time has too coarse a resolution to tell.
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=gitlab.com\n  - domain=github.com\n"), 0o600))
+	require.Eventually(t, func() bool {
+		return server.RuleEngine().Evaluate("GET", "https://gitlab.com/").Allowed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	changes := auditor.getChanges()
+	require.Len(t, changes, 1)
+	require.Equal(t, "config file changed", changes[0].Reason)
+}
+
+func TestRunIgnoresChangedFileByDefault(t *testing.T) {
+	path, reloader, server, auditor := setup(t, "allowlist:\n  - domain=github.com\n", false)
+	reloader.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloader.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Without WatchConfig, a change only takes effect on SIGHUP.
+	require.NoError(t, os.WriteFile(path, []byte("allowlist:\n  - domain=gitlab.com\n  - domain=github.com\n"), 0o600))
+	require.Never(t, func() bool {
+		return server.RuleEngine().Evaluate("GET", "https://gitlab.com/").Allowed
+	}, 200*time.Millisecond, 10*time.Millisecond)
+	require.Empty(t, auditor.getChanges())
+}
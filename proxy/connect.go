@@ -79,9 +79,10 @@ package proxy
 import (
 	"bufio"
 	"crypto/tls"
-	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 )
 
 // handleCONNECT handles HTTP CONNECT requests for tunneling.
@@ -106,16 +107,27 @@ func (p *Server) handleCONNECT(conn net.Conn, req *http.Request) {
 
 	p.logger.Debug("CONNECT tunnel established", "target", req.Host)
 
+	// The port is only needed for passthrough connections, see handleCONNECTTunnel.
+	var port uint16
+	if _, portStr, err := net.SplitHostPort(req.Host); err == nil {
+		if n, err := strconv.ParseUint(portStr, 10, 16); err == nil {
+			port = uint16(n)
+		}
+	}
+
 	// Handle the tunnel - decrypt TLS and process each HTTP request
-	p.handleCONNECTTunnel(conn)
+	p.handleCONNECTTunnel(conn, port)
 }
 
 // handleCONNECTTunnel handles the tunnel after CONNECT is established.
 //
 // This function:
-//  1. Wraps the connection with TLS.Server to decrypt traffic from the client
+//  1. Wraps the connection with TLS.Server to decrypt traffic from the client, unless a passthrough
+//     rule allows the server name the client sends, see passThrough. port is the port of the CONNECT
+//     target, which passed through connections go to.
 //  2. Performs the TLS handshake
-//  3. Reads HTTP requests from the tunnel in a loop
+//  3. Reads HTTP requests from the tunnel in a loop, see serveRequests, or serves the
+//     streams of an HTTP/2 connection if the client negotiated h2, see serveHTTP2
 //  4. Processes each request separately (rule evaluation, forwarding)
 //
 // Important: The actual destination for each request is determined by the Host
@@ -124,7 +136,13 @@ func (p *Server) handleCONNECT(conn net.Conn, req *http.Request) {
 //
 // The connection lifecycle is managed by handleHTTPConnection's defer, which
 // closes the connection when this function returns.
-func (p *Server) handleCONNECTTunnel(conn net.Conn) {
+func (p *Server) handleCONNECTTunnel(conn net.Conn, port uint16) {
+	// CONNECT means the client is using us explicitly, so there's no original destination.
+	conn, passedThrough := p.passThrough(conn, port, netip.Addr{})
+	if passedThrough {
+		return
+	}
+
 	// Wrap connection with TLS server to decrypt traffic
 	tlsConn := tls.Server(conn, p.tlsConfig)
 
@@ -136,23 +154,12 @@ func (p *Server) handleCONNECTTunnel(conn net.Conn) {
 
 	p.logger.Debug("✅ TLS handshake successful in CONNECT tunnel")
 
-	// Process HTTP requests in a loop
-	reader := bufio.NewReader(tlsConn)
-	for {
-		// Read HTTP request from tunnel
-		req, err := http.ReadRequest(reader)
-		if err != nil {
-			if err == io.EOF {
-				p.logger.Debug("CONNECT tunnel closed by client")
-				break
-			}
-			p.logger.Error("Failed to read HTTP request from CONNECT tunnel", "error", err)
-			break
-		}
-
-		p.logger.Debug("🔒 HTTP Request in CONNECT tunnel", "method", req.Method, "url", req.URL.String(), "target", req.Host)
-
-		// Process this request - check if allowed and forward to target
-		p.processHTTPRequest(tlsConn, req, true)
+	// CONNECT means the client is using us explicitly, so there's no original destination.
+	if negotiatedHTTP2(tlsConn) {
+		p.serveHTTP2(tlsConn, netip.Addr{})
+		return
 	}
+
+	// Process HTTP requests in a loop
+	p.serveRequests(tlsConn, bufio.NewReader(tlsConn), true, netip.Addr{})
 }
@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+
+	"golang.org/x/net/http2"
+)
+
+// negotiatedHTTP2 reports whether the client chose HTTP/2 during the TLS handshake.
+func negotiatedHTTP2(conn *tls.Conn) bool {
+	return conn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS
+}
+
+// serveHTTP2 serves the streams of an HTTP/2 client connection until the client closes it or it has been idle
+// for the idle timeout. Every stream is a request of its own, evaluated, audited and forwarded like those of
+// HTTP/1.1 connections, and its trailers are passed on, as gRPC needs. dst is passed on to processHTTPRequest.
+func (p *Server) serveHTTP2(conn *tls.Conn, dst netip.Addr) {
+	server := &http2.Server{IdleTimeout: p.idleTimeout}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			p.logger.Debug("HTTP/2 Request", "method", req.Method, "url", req.URL.String())
+			if !p.processHTTPRequest(http2Writer{w: w}, req, true, dst) {
+				// Nothing more can be sent, so reset the stream rather than end it as if it were complete.
+				panic(http.ErrAbortHandler)
+			}
+		}),
+	})
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultIdleTimeout is how long a client connection may wait for its next request before the proxy closes it.
+const defaultIdleTimeout = 90 * time.Second
+
+// maxDrainBytes is how much of a request body the proxy reads and discards, when forwarding didn't, to get to
+// the next request on the connection. Connections with more left are closed instead.
+const maxDrainBytes = 256 << 10
+
+// serveRequests processes the requests a client sends over conn, one after the other, until it closes the
+// connection, asks for it to be closed (Connection: close, or HTTP/1.0 without keep-alive), or sends no request
+// within the idle timeout. Pipelined requests are read from reader as the previous one is done, so responses go
+// out in order. https and dst are passed on to processHTTPRequest.
+//
+// Each request is evaluated against the rules on its own, so a client can't reuse a connection to get around
+// them.
+func (p *Server) serveRequests(conn net.Conn, reader *bufio.Reader, https bool, dst netip.Addr) {
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(p.idleTimeout)); err != nil {
+			p.logger.Error("Failed to set idle timeout", "error", err)
+			return
+		}
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+				p.logger.Debug("Connection closed by client")
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				p.logger.Debug("Closing idle connection", "idle_timeout", p.idleTimeout)
+			default:
+				p.logger.Error("Failed to read HTTP request", "error", err)
+			}
+			return
+		}
+		// The idle timeout is for waiting on a request, not for the request or its response.
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			p.logger.Error("Failed to clear idle timeout", "error", err)
+			return
+		}
+
+		if req.Method == http.MethodConnect && !https {
+			// The connection becomes a tunnel; handleCONNECTTunnel serves the requests inside it.
+			p.handleCONNECT(conn, req)
+			return
+		}
+
+		p.logger.Debug("HTTP Request", "method", req.Method, "url", req.URL.String(), "https", https)
+		body := &requestBody{body: req.Body}
+		req.Body = body
+		if !p.processHTTPRequest(http1Writer{conn: conn, reader: reader, req: req}, req, https, dst) || req.Close {
+			return
+		}
+		if !body.drain(maxDrainBytes) {
+			p.logger.Debug("Closing connection with an unread request body")
+			return
+		}
+	}
+}
+
+// requestBody is the body of a request read from a client connection. Forwarding the request closes it, but
+// that leaves the connection alone: whatever forwarding didn't read is drained afterwards, so the next request
+// can be read.
+type requestBody struct {
+	mu     sync.Mutex
+	body   io.ReadCloser
+	closed bool
+	eof    bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, http.ErrBodyReadAfterClose
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *requestBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+// drain reads and discards the rest of the body, and reports whether the connection is at the next request.
+// More than limit bytes left aren't worth reading.
+func (b *requestBody) drain(limit int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	if b.eof {
+		return true
+	}
+	_, err := io.CopyN(io.Discard, b.body, limit+1)
+	return errors.Is(err, io.EOF)
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// originalDestination returns the address and port the client originally connected to before iptables REDIRECT
+// sent the connection to the proxy. It returns an invalid address when the connection wasn't redirected, e.g.
+// when the client used the proxy explicitly.
+func originalDestination(conn net.Conn) netip.AddrPort {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}
+	}
+
+	var addr netip.Addr
+	var port uint16
+	_ = rawConn.Control(func(fd uintptr) {
+		// SO_ORIGINAL_DST fills in a sockaddr_in. IPv6Mreq is the 20 byte struct the kernel needs to write into;
+		// x/sys/unix has no dedicated getter for it.
+		if mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST); err == nil {
+			sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&mreq.Multiaddr[0]))
+			if sa.Family == unix.AF_INET {
+				addr = netip.AddrFrom4(sa.Addr)
+				port = networkPort(&sa.Port)
+			}
+			return
+		}
+		// IP6T_SO_ORIGINAL_DST has the same value as SO_ORIGINAL_DST and fills in a sockaddr_in6, which fits in
+		// IPv6MTUInfo.
+		if info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST); err == nil {
+			if info.Addr.Family == unix.AF_INET6 {
+				addr = netip.AddrFrom16(info.Addr.Addr).Unmap()
+				port = networkPort(&info.Addr.Port)
+			}
+		}
+	})
+
+	// For connections that weren't redirected conntrack reports the proxy's own address.
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr.IsValid() {
+		if localAddr, ok := netip.AddrFromSlice(local.IP); ok && localAddr.Unmap() == addr.Unmap() {
+			return netip.AddrPort{}
+		}
+	}
+
+	if !addr.IsValid() {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(addr, port)
+}
+
+// networkPort reads the port of a raw sockaddr, which the kernel stores in network byte order.
+func networkPort(port *uint16) uint16 {
+	return binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(port))[:])
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"net"
+	"net/netip"
+)
+
+// originalDestination always returns an invalid address on non-Linux platforms, which have no transparent mode.
+func originalDestination(conn net.Conn) netip.AddrPort {
+	return netip.AddrPort{}
+}
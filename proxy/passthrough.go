@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coder/boundary/audit"
+	"github.com/coder/boundary/rulesengine"
+)
+
+// defaultClientHelloTimeout is how long a client has to send its ClientHello.
+const defaultClientHelloTimeout = 10 * time.Second
+
+// errPeeked stops the handshake peekClientHello parses the ClientHello with.
+var errPeeked = errors.New("peeked at the ClientHello")
+
+// passThrough tunnels the TLS connection on conn to its server without decrypting it, if a passthrough rule
+// (inspect=false) allows the server name of its ClientHello, and reports whether it did. This is for clients that
+// pin certificates or use mTLS, which can't work through the proxy's own certificates. port is the port the client
+// connected to, zero if unknown, and dst the address it originally connected to in transparent mode.
+//
+// Connections that aren't passed through are decrypted and their requests evaluated as usual, on the returned
+// connection, which replays the ClientHello.
+func (p *Server) passThrough(conn net.Conn, port uint16, dst netip.Addr) (net.Conn, bool) {
+	engine := p.ruleEngine.Load()
+	if !engine.HasPassthrough() {
+		return conn, false
+	}
+
+	serverName, conn, err := peekClientHello(conn, p.helloTimeout)
+	if err != nil {
+		// Closing the connection is how the client learns about it.
+		p.logger.Debug("Failed to read ClientHello", "error", err)
+		return conn, true
+	}
+	result := engine.EvaluateConnection(rulesengine.Connection{
+		ServerName:  serverName,
+		Port:        int(port),
+		Destination: dst,
+	})
+	if !result.Allowed {
+		if result.Rule != "" {
+			p.logger.Debug("Decrypting connection a deny rule applies to", "server_name", serverName, "rule", result.Rule)
+		}
+		return conn, false
+	}
+
+	if port == 0 {
+		port = 443
+	}
+	// The connection goes to the server name that was allowed, not to whatever address the client connected to, or
+	// a client could reach any server by sending an allowed name. Only a rule that allowed the address itself
+	// sends it there.
+	host := serverName
+	if result.Destination.IsValid() {
+		host = result.Destination.String()
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	hostPort := serverName
+	if port != 443 {
+		hostPort = net.JoinHostPort(serverName, strconv.Itoa(int(port)))
+	}
+	p.auditor.AuditRequest(audit.Request{
+		Method:         http.MethodConnect,
+		URL:            "https://" + hostPort,
+		Host:           hostPort,
+		Allowed:        true,
+		Action:         audit.ActionForward,
+		Passthrough:    true,
+		Rule:           result.Rule,
+		RuleID:         result.RuleID,
+		RuleTags:       result.RuleTags,
+		SequenceNumber: p.seqCounter.Next(),
+	})
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	upstream, err := dialer.Dial("tcp", address)
+	if err != nil {
+		// Closing the connection is how the client learns about it.
+		p.logger.Error("Failed to connect to passthrough server", "address", address, "error", err)
+		return conn, true
+	}
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	p.logger.Debug("🔓 Passing TLS connection through", "server_name", serverName, "address", address)
+	sent, received, err := splice(conn, conn, upstream)
+	p.logger.Debug("Passthrough connection closed", "server_name", serverName, "bytes_sent", sent, "bytes_received", received, "error", err)
+	return conn, true
+}
+
+// peekClientHello reads the ClientHello of the TLS connection on conn, without answering it, and returns the
+// server name the client asked for, empty if it didn't send one. The returned connection replays everything read
+// from conn, so the handshake can still be done on it, by the proxy or by the server it is passed through to.
+// A client that doesn't send its ClientHello within timeout gets an error, so that it can't hold the connection
+// open.
+func peekClientHello(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", conn, err
+	}
+	var peeked bytes.Buffer
+	var serverName string
+	// The handshake stops as soon as the ClientHello is parsed, and nothing it writes reaches the client.
+	err := tls.Server(readOnlyConn{Conn: conn, r: io.TeeReader(conn, &peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errPeeked
+		},
+	}).Handshake()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return "", conn, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", conn, err
+	}
+	return serverName, &connectionWrapper{Conn: conn, buf: peeked.Bytes()}, nil
+}
+
+// readOnlyConn reads from r instead of the connection, and fails every write.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c readOnlyConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
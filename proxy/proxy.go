@@ -2,16 +2,17 @@ package proxy
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"strings"
@@ -25,7 +26,8 @@ import (
 
 // Server handles HTTP and HTTPS requests with rule-based filtering
 type Server struct {
-	ruleEngine       rulesengine.Engine
+	// ruleEngine is swapped by SetRuleEngine while requests are being served.
+	ruleEngine       atomic.Pointer[rulesengine.Engine]
 	auditor          audit.Auditor
 	logger           *slog.Logger
 	tlsConfig        *tls.Config
@@ -35,6 +37,13 @@ type Server struct {
 	sessionID        string
 	seqCounter       audit.SequenceCounter
 	forwardTransport http.RoundTripper
+	pathMode         config.PathMode
+	enforcement      config.EnforcementMode
+	idleTimeout      time.Duration
+	helloTimeout     time.Duration
+	limiter          *limiter
+	approver         Approver // nil unless requests no rule allows are asked about
+	learner          Learner  // nil unless learning the policy instead of enforcing it
 
 	listener     net.Listener
 	pprofServer  *http.Server
@@ -65,12 +74,21 @@ type Config struct {
 	// backend servers. Defaults to http.DefaultTransport when nil. Set in
 	// tests to trust self-signed backend certificates.
 	ForwardTransport http.RoundTripper
+	// PathMode selects what happens to requests whose path isn't canonical. The zero value normalizes.
+	PathMode config.PathMode
+	// Enforcement selects whether requests the rules deny are blocked. The zero value enforces the rules.
+	Enforcement config.EnforcementMode
+	// IdleTimeout is how long a client connection is kept open while waiting for its next request. Zero means
+	// 90 seconds.
+	IdleTimeout time.Duration
+	// ClientHelloTimeout is how long a TLS client has to send its ClientHello when passthrough rules need its
+	// server name. Zero means 10 seconds.
+	ClientHelloTimeout time.Duration
 }
 
 // NewProxyServer creates a new proxy server instance
 func NewProxyServer(config Config) *Server {
-	return &Server{
-		ruleEngine:       config.RuleEngine,
+	p := &Server{
 		auditor:          config.Auditor,
 		logger:           config.Logger,
 		tlsConfig:        config.TLSConfig,
@@ -80,7 +98,58 @@ func NewProxyServer(config Config) *Server {
 		injectEngine:     config.InjectEngine,
 		sessionID:        config.SessionID,
 		forwardTransport: config.ForwardTransport,
+		pathMode:         config.PathMode,
+		enforcement:      config.Enforcement,
+		idleTimeout:      config.IdleTimeout,
+		helloTimeout:     config.ClientHelloTimeout,
+		limiter:          newLimiter(),
 	}
+	if p.idleTimeout == 0 {
+		p.idleTimeout = defaultIdleTimeout
+	}
+	if p.helloTimeout == 0 {
+		p.helloTimeout = defaultClientHelloTimeout
+	}
+	p.ruleEngine.Store(&config.RuleEngine)
+	return p
+}
+
+// RuleEngine returns the rule engine requests are currently evaluated with.
+func (p *Server) RuleEngine() *rulesengine.Engine {
+	return p.ruleEngine.Load()
+}
+
+// Approver decides whether a request that no rule allows may go through anyway, for instance by asking the person
+// running boundary. See Server.SetApprover.
+type Approver interface {
+	// Approve decides whether req may go through. The result is used as if the rules engine had returned it: if
+	// it allows req, its rule is the allow rule req was granted as, with the limit, id and tags of that rule, and
+	// it has no rule when req was approved once only.
+	Approve(req rulesengine.Request) rulesengine.Result
+}
+
+// SetApprover makes the proxy hold requests denied by default, rather than by a deny rule, until approver decides
+// on them. It must be called before Start.
+func (p *Server) SetApprover(approver Approver) {
+	p.approver = approver
+}
+
+// Learner records the requests the rules deny, see Server.SetLearner.
+type Learner interface {
+	Learn(req rulesengine.Request, result rulesengine.Result)
+}
+
+// SetLearner makes the proxy forward requests the rules deny, by default or by a deny rule, after handing them to
+// learner. Nothing is blocked then. It must be called before Start.
+func (p *Server) SetLearner(learner Learner) {
+	p.learner = learner
+}
+
+// SetRuleEngine replaces the rule engine, for instance when the policy is reloaded, and returns the one it
+// replaced. Requests evaluated from then on use the new engine; requests already past evaluation are not affected.
+// Rate limit and quota counts are kept for the rules both engines have in common.
+func (p *Server) SetRuleEngine(engine rulesengine.Engine) *rulesengine.Engine {
+	return p.ruleEngine.Swap(&engine)
 }
 
 // Start starts the HTTP proxy server with TLS termination capability
@@ -181,6 +250,10 @@ func (p *Server) isStopped() bool {
 }
 
 func (p *Server) handleConnectionWithTLSDetection(conn net.Conn) {
+	// In transparent mode the client didn't connect to us but to its real destination. Remember where that was
+	// so ip rules can match it; this has to happen on the raw TCP connection.
+	dst := originalDestination(conn)
+
 	// Detect protocol using TLS handshake detection
 	wrappedConn, isTLS, err := p.isTLSConnection(conn)
 	if err != nil {
@@ -194,10 +267,10 @@ func (p *Server) handleConnectionWithTLSDetection(conn net.Conn) {
 	}
 	if isTLS {
 		p.logger.Debug("🔒 Detected TLS connection - handling as HTTPS")
-		p.handleTLSConnection(wrappedConn)
+		p.handleTLSConnection(wrappedConn, dst)
 	} else {
 		p.logger.Debug("🌐 Detected HTTP connection")
-		p.handleHTTPConnection(wrappedConn)
+		p.handleHTTPConnection(wrappedConn, dst.Addr())
 	}
 }
 
@@ -209,7 +282,7 @@ func (p *Server) isTLSConnection(conn net.Conn) (net.Conn, bool, error) {
 		return nil, false, fmt.Errorf("failed to read first byte from connection: %v, read %v bytes", err, n)
 	}
 
-	connWrapper := &connectionWrapper{conn, buf, false}
+	connWrapper := &connectionWrapper{Conn: conn, buf: buf}
 
 	// TLS detection based on first byte:
 	// 0x16 (22) = TLS Handshake
@@ -225,7 +298,7 @@ func (p *Server) isTLSConnection(conn net.Conn) (net.Conn, bool, error) {
 	return connWrapper, isTLS, nil
 }
 
-func (p *Server) handleHTTPConnection(conn net.Conn) {
+func (p *Server) handleHTTPConnection(conn net.Conn, dst netip.Addr) {
 	defer func() {
 		err := conn.Close()
 		if err != nil {
@@ -233,23 +306,20 @@ func (p *Server) handleHTTPConnection(conn net.Conn) {
 		}
 	}()
 
-	// Read HTTP request
-	req, err := http.ReadRequest(bufio.NewReader(conn))
-	if err != nil {
-		p.logger.Error("Failed to read HTTP request", "error", err)
-		return
-	}
+	p.logger.Debug("🌐 HTTP connection")
+	p.serveRequests(conn, bufio.NewReader(conn), false, dst)
+}
 
-	if req.Method == http.MethodConnect {
-		p.handleCONNECT(conn, req)
+func (p *Server) handleTLSConnection(conn net.Conn, dst netip.AddrPort) {
+	// Connections a passthrough rule allows are tunnelled to their server without being decrypted.
+	conn, passedThrough := p.passThrough(conn, dst.Port(), dst.Addr())
+	if passedThrough {
+		if err := conn.Close(); err != nil {
+			p.logger.Error("Failed to close connection", "error", err)
+		}
 		return
 	}
 
-	p.logger.Debug("🌐 HTTP Request", "method", req.Method, "url", req.URL.String())
-	p.processHTTPRequest(conn, req, false)
-}
-
-func (p *Server) handleTLSConnection(conn net.Conn) {
 	// Create TLS connection
 	tlsConn := tls.Server(conn, p.tlsConfig)
 
@@ -268,54 +338,155 @@ func (p *Server) handleTLSConnection(conn net.Conn) {
 
 	p.logger.Debug("✅ TLS handshake successful")
 
-	// Read HTTP request over TLS
-	req, err := http.ReadRequest(bufio.NewReader(tlsConn))
-	if err != nil {
-		p.logger.Error("Failed to read HTTPS request", "error", err)
+	if negotiatedHTTP2(tlsConn) {
+		p.logger.Debug("🔒 HTTP/2 connection")
+		p.serveHTTP2(tlsConn, dst.Addr())
 		return
 	}
 
-	p.logger.Debug("🔒 HTTPS Request", "method", req.Method, "url", req.URL.String())
-	p.processHTTPRequest(tlsConn, req, true)
+	// One handshake serves every request the client sends over the connection.
+	p.logger.Debug("🔒 HTTPS connection")
+	p.serveRequests(tlsConn, bufio.NewReader(tlsConn), true, dst.Addr())
 }
 
-func (p *Server) processHTTPRequest(conn net.Conn, req *http.Request, https bool) {
+// processHTTPRequest evaluates and forwards a single request. dst is the address the client originally
+// connected to in transparent mode, and invalid otherwise. It reports whether the connection can carry
+// another request.
+func (p *Server) processHTTPRequest(w clientWriter, req *http.Request, https bool, dst netip.Addr) bool {
 	p.logger.Debug("   Host", "host", req.Host)
 	p.logger.Debug("   User-Agent", "user-agent", req.Header.Get("User-Agent"))
 
-	// Construct fully qualified URL for rule evaluation and auditing.
-	// In boundary's normal transparent proxy operation, req.URL only contains
-	// the path since clients don't know they're going through a proxy.
-	// When clients explicitly configure a proxy, req.URL contains the full URL.
-	fullURL := req.URL.String()
-	if req.URL.Scheme == "" {
-		scheme := "http"
-		if https {
-			scheme = "https"
+	// Rules are evaluated against the canonical path, and that is also the path that gets forwarded, so the
+	// upstream server gets exactly the request that was allowed. See rulesengine.CanonicalizePath.
+	path, err := rulesengine.CanonicalizePath(req.URL.EscapedPath())
+	if err == nil && path.Changed && p.pathMode == config.PathReject {
+		err = errors.New("path has dot segments or empty segments")
+	}
+	if err != nil {
+		p.logger.Info("Rejecting request with a non-canonical path", "method", req.Method, "host", req.Host, "path", req.URL.EscapedPath(), "error", err)
+		p.auditor.AuditRequest(audit.Request{
+			Method:         req.Method,
+			URL:            requestURL(req, https),
+			Host:           req.Host,
+			Allowed:        false,
+			Action:         audit.ActionBlock,
+			SequenceNumber: p.seqCounter.Next(),
+		})
+		p.writeInvalidPathResponse(w, req, err)
+		return true
+	}
+	if path.Changed {
+		p.logger.Debug("Normalized request path", "from", req.URL.EscapedPath(), "to", path.Escaped)
+		req.URL.Path, _ = url.PathUnescape(path.Escaped)
+		req.URL.RawPath = path.Escaped
+	}
+
+	fullURL := requestURL(req, https)
+
+	evalReq := rulesengine.Request{
+		Method:      req.Method,
+		URL:         fullURL,
+		Header:      req.Header,
+		Destination: dst,
+	}
+	// With enforcement off, the rules aren't evaluated at all.
+	result := rulesengine.Result{Allowed: true}
+	if p.enforcement != config.EnforcementOff {
+		result = p.ruleEngine.Load().EvaluateRequest(evalReq)
+	}
+
+	// Requests that no rule decided can be approved by hand. Those blocked by a deny rule stay blocked.
+	approved := false
+	if !result.Allowed && result.Rule == "" && p.approver != nil {
+		if approval := p.approver.Approve(evalReq); approval.Allowed {
+			result = approval
+			approved = true
 		}
-		fullURL = scheme + "://" + req.Host + fullURL
 	}
 
-	result := p.ruleEngine.Evaluate(req.Method, fullURL)
+	// When learning, requests the rules deny are recorded and forwarded anyway.
+	learned := false
+	if !result.Allowed && p.learner != nil {
+		p.learner.Learn(evalReq, result)
+		learned = true
+	}
+
+	// The rule that allowed the request may limit how often it does. Requests over the limit are neither
+	// allowed nor denied by a rule, so they are audited as rate limited.
+	rateLimited := false
+	var retryAfter time.Duration
+	if result.Allowed && !result.Limit.IsZero() {
+		var ok bool
+		ok, retryAfter = p.limiter.allow(result.LimitKey, limitHost(req), result.Limit, time.Now())
+		rateLimited = !ok
+	}
+
+	// In monitor mode the decision is audited, but nothing is blocked.
+	action := audit.ActionForward
+	if p.enforcement != config.EnforcementMonitor && (rateLimited || (!result.Allowed && !learned)) {
+		action = audit.ActionBlock
+	}
 
 	seqNum := p.seqCounter.Next()
 
+	upgrade := ""
+	if isUpgradeRequest(req) {
+		upgrade = req.Header.Get("Upgrade")
+	}
+
 	p.auditor.AuditRequest(audit.Request{
 		Method:         req.Method,
 		URL:            fullURL,
 		Host:           req.Host,
-		Allowed:        result.Allowed,
+		Allowed:        result.Allowed && !rateLimited,
+		Action:         action,
+		RateLimited:    rateLimited,
+		Upgrade:        upgrade,
+		Approved:       approved,
+		Learned:        learned,
 		Rule:           result.Rule,
+		RuleID:         result.RuleID,
+		RuleTags:       result.RuleTags,
+		ExpiredRule:    result.ExpiredRule,
 		SequenceNumber: seqNum,
 	})
 
-	if !result.Allowed {
-		p.writeBlockedResponse(conn, req)
-		return
+	if action == audit.ActionBlock {
+		if rateLimited {
+			p.writeRateLimitedResponse(w, req, result.Rule, retryAfter)
+		} else {
+			p.writeBlockedResponse(w, req)
+		}
+		return true
 	}
 
 	// Forward request to destination
-	p.forwardRequest(conn, req, https, seqNum)
+	return p.forwardRequest(w, req, https, seqNum, result.Destination)
+}
+
+// requestURL returns the fully qualified URL of a request for rule evaluation and auditing.
+// In boundary's normal transparent proxy operation, req.URL only contains
+// the path since clients don't know they're going through a proxy.
+// When clients explicitly configure a proxy, req.URL contains the full URL.
+// Either way the URL carries the real scheme, and req.Host carries the port
+// whenever it isn't the scheme's default, so scheme and port rules see
+// what the client actually connected to.
+func requestURL(req *http.Request, https bool) string {
+	if req.URL.Scheme != "" {
+		return req.URL.String()
+	}
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.String()
+}
+
+// limitHost returns the host of the request as the rules engine matched it, which per=host limits count by. It
+// comes from req.Host, like the URL the rules are evaluated against, since req.URL has no host for requests
+// that don't name the proxy, which is most of them.
+func limitHost(req *http.Request) string {
+	return rulesengine.CanonicalHost((&url.URL{Host: req.Host}).Hostname())
 }
 
 // shouldInjectHeaders reports whether the request URL matches any
@@ -332,13 +503,21 @@ func (p *Server) shouldInjectHeaders(fullURL string) bool {
 	return p.injectEngine.Evaluate("", fullURL).Allowed
 }
 
-func (p *Server) forwardRequest(conn net.Conn, req *http.Request, https bool, seqNum int32) {
+// forwardRequest forwards the request to its destination. When pinned is valid the request was allowed
+// because of the address the client originally connected to, so we connect to that address instead of
+// resolving req.Host again.
+func (p *Server) forwardRequest(w clientWriter, req *http.Request, https bool, seqNum int32, pinned netip.Addr) bool {
+	transport := p.forwardTransport // nil → http.DefaultTransport
+	if pinned.IsValid() {
+		transport = p.pinnedTransport(pinned)
+	}
+
 	// Create HTTP client
 	client := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse // Don't follow redirects
 		},
-		Transport: p.forwardTransport, // nil → http.DefaultTransport
+		Transport: transport,
 	}
 
 	scheme := "http"
@@ -364,7 +543,7 @@ func (p *Server) forwardRequest(conn net.Conn, req *http.Request, https bool, se
 	newReq, err := http.NewRequest(req.Method, targetURL.String(), body)
 	if err != nil {
 		p.logger.Error("can't create http request", "error", err)
-		return
+		return false
 	}
 
 	// Copy headers
@@ -378,6 +557,13 @@ func (p *Server) forwardRequest(conn net.Conn, req *http.Request, https bool, se
 		}
 	}
 
+	// Connection isn't passed on, but an upgrade needs it to reach the upstream server. Only HTTP/1.x client
+	// connections can switch protocols.
+	u, canUpgrade := w.(upgrader)
+	if canUpgrade && isUpgradeRequest(req) {
+		newReq.Header.Set("Connection", "Upgrade")
+	}
+
 	if p.shouldInjectHeaders(targetURL.String()) {
 		newReq.Header.Set(config.SessionIDHeaderName, p.sessionID)
 		newReq.Header.Set(config.SequenceNumberHeaderName, strconv.Itoa(int(seqNum)))
@@ -386,8 +572,9 @@ func (p *Server) forwardRequest(conn net.Conn, req *http.Request, https bool, se
 	// Make request to destination
 	resp, err := client.Do(newReq)
 	if err != nil {
+		// Closing the connection is how the client learns about it.
 		p.logger.Error("Failed to forward HTTPS request", "error", err)
-		return
+		return false
 	}
 
 	p.logger.Debug("🔒 HTTPS Response", "status code", resp.StatusCode, "status", resp.Status)
@@ -398,48 +585,59 @@ func (p *Server) forwardRequest(conn net.Conn, req *http.Request, https bool, se
 		"URL", newReq.URL,
 	)
 
-	// Read the body and explicitly set Content-Length header, otherwise client can hung up on the request.
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		p.logger.Error("can't read response body", "error", err)
-		return
-	}
-	resp.Header.Add("Content-Length", strconv.Itoa(len(bodyBytes)))
-	resp.ContentLength = int64(len(bodyBytes))
-	err = resp.Body.Close()
-	if err != nil {
-		p.logger.Error("Failed to close HTTP response body", "error", err)
-		return
+	// The upstream server agreed to switch protocols, so the transport handed over its connection.
+	if resp.StatusCode == http.StatusSwitchingProtocols && canUpgrade {
+		if _, ok := resp.Body.(io.ReadWriteCloser); ok {
+			p.forwardUpgrade(u, req, requestURL(req, https), resp, seqNum)
+			return false
+		}
 	}
-	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-	// The downstream client (Claude) always communicates over HTTP/1.1.
-	// However, Go's default HTTP client may negotiate an HTTP/2 connection
-	// with the upstream server via ALPN during TLS handshake.
-	// This can cause the response's Proto field to be set to "HTTP/2.0",
-	// which would produce an invalid response for an HTTP/1.1 client.
-	// To prevent this mismatch, we explicitly normalize the response
-	// to HTTP/1.1 before writing it back to the client.
-	resp.Proto = "HTTP/1.1"
-	resp.ProtoMajor = 1
-	resp.ProtoMinor = 1
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			p.logger.Error("Failed to close HTTP response body", "error", err)
+		}
+	}()
 
 	// Copy response back to client
-	err = resp.Write(conn)
+	err = w.writeResponse(resp)
 	if err != nil {
 		p.logger.Error("Failed to forward back HTTP response",
 			"error", err,
 			"host", req.Host,
 			"method", req.Method,
-			//"bodyBytes", string(bodyBytes),
 		)
-		return
+		return false
 	}
 
 	p.logger.Debug("Successfully wrote to connection")
+	return !resp.Close
 }
 
-func (p *Server) writeBlockedResponse(conn net.Conn, req *http.Request) {
+// pinnedTransport returns a transport that dials addr for every request, keeping the port and, for TLS,
+// the server name of the request URL.
+func (p *Server) pinnedTransport(addr netip.Addr) http.RoundTripper {
+	base, ok := p.forwardTransport.(*http.Transport)
+	if !ok || base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	// The transport is thrown away after this request, so don't leave idle connections behind.
+	transport.DisableKeepAlives = true
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+	}
+	return transport
+}
+
+func (p *Server) writeBlockedResponse(w clientWriter, req *http.Request) {
 	// Create a response object
 	resp := &http.Response{
 		Status:        "403 Forbidden",
@@ -478,7 +676,7 @@ For more help: https://github.com/coder/boundary
 	resp.ContentLength = int64(len(body))
 
 	// Copy response back to client
-	err := resp.Write(conn)
+	err := w.writeResponse(resp)
 	if err != nil {
 		p.logger.Error("Failed to write blocker response", "error", err)
 		return
@@ -487,17 +685,85 @@ For more help: https://github.com/coder/boundary
 	p.logger.Debug("Successfully wrote to connection")
 }
 
-// connectionWrapper lets us "unread" the peeked byte
+// writeInvalidPathResponse answers a request that was refused because of its path, before any rule was
+// evaluated.
+func (p *Server) writeInvalidPathResponse(w clientWriter, req *http.Request, reason error) {
+	body := fmt.Sprintf(`🚫 Request Blocked by Boundary
+
+Request: %s %s
+Reason: %v
+
+Boundary evaluates and forwards canonical paths only. Send the request without
+dot segments (/a/../b) or empty segments (//a), including ones spelled with
+encoded slashes (%%2F) or backslashes.
+`,
+		req.Method, req.URL.EscapedPath(), reason)
+
+	resp := &http.Response{
+		Status:        "400 Bad Request",
+		StatusCode:    http.StatusBadRequest,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	resp.Header.Set("Content-Type", "text/plain")
+
+	if err := w.writeResponse(resp); err != nil {
+		p.logger.Error("Failed to write invalid path response", "error", err)
+	}
+}
+
+// writeRateLimitedResponse answers a request that exceeded the rate limit or quota of the rule that allowed it.
+// retryAfter is zero when the quota is used up, in which case there is no point in retrying and no Retry-After
+// header is sent.
+func (p *Server) writeRateLimitedResponse(w clientWriter, req *http.Request, rule string, retryAfter time.Duration) {
+	reason := "The rule's quota is used up; no more requests are allowed until boundary restarts."
+	if retryAfter > 0 {
+		reason = "The rule's rate limit is exceeded; retry later."
+	}
+	body := fmt.Sprintf(`🚫 Request Blocked by Boundary
+
+Request: %s %s
+Rule: %s
+
+%s
+`,
+		req.Method, req.URL.EscapedPath(), rule, reason)
+
+	resp := &http.Response{
+		Status:        "429 Too Many Requests",
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	resp.Header.Set("Content-Type", "text/plain")
+	if retryAfter > 0 {
+		// Retry-After is in whole seconds; round up so clients don't come back too early.
+		resp.Header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	}
+
+	if err := w.writeResponse(resp); err != nil {
+		p.logger.Error("Failed to write rate limited response", "error", err)
+	}
+}
+
+// connectionWrapper lets us "unread" the peeked bytes
 type connectionWrapper struct {
 	net.Conn
-	buf     []byte
-	bufUsed bool
+	buf []byte
 }
 
 func (c *connectionWrapper) Read(p []byte) (int, error) {
-	if !c.bufUsed && len(c.buf) > 0 {
+	if len(c.buf) > 0 {
 		n := copy(p, c.buf)
-		c.bufUsed = true
+		c.buf = c.buf[n:]
 		return n, nil
 	}
 	return c.Conn.Read(p)
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pathApprover approves requests by path, and records what it was asked about.
+type pathApprover struct {
+	mu      sync.Mutex
+	asked   []string
+	results map[string]rulesengine.Result // path -> result; a result without a rule approves once
+}
+
+func (a *pathApprover) Approve(req rulesengine.Request) rulesengine.Result {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return rulesengine.Result{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.asked = append(a.asked, u.Path)
+	return a.results[u.Path]
+}
+
+func (a *pathApprover) getAsked() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.asked...)
+}
+
+func TestApproverThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := serverURL.Hostname()
+
+	auditor := &capturingAuditor{}
+	granted := rulesengine.Result{
+		Allowed:  true,
+		Rule:     "domain=" + host + " rate=1/h",
+		RuleID:   "approved",
+		RuleTags: []string{"session"},
+		Limit:    rulesengine.Limit{Rate: 1, Interval: time.Hour},
+	}
+	approver := &pathApprover{results: map[string]rulesengine.Result{
+		"/once":    {Allowed: true},
+		"/session": granted,
+		"/limited": granted,
+		"/blocked": granted,
+	}}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain="+host+" path=/allowed"),
+		WithDeniedRule("path=/blocked"),
+		WithAuditor(auditor),
+		WithApprover(approver),
+	).Start()
+	defer pt.Stop()
+
+	get := func(path string) int {
+		resp, err := pt.proxyClient.Get(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get("/allowed"))
+	require.Equal(t, http.StatusOK, get("/once"))
+	require.Equal(t, http.StatusOK, get("/session"))
+	// The limit of the rule the request was granted as is enforced.
+	require.Equal(t, http.StatusTooManyRequests, get("/limited"))
+	require.Equal(t, http.StatusForbidden, get("/other"))
+	// Deny rules aren't up for approval.
+	require.Equal(t, http.StatusForbidden, get("/blocked"))
+
+	require.Equal(t, []string{"/once", "/session", "/limited", "/other"}, approver.getAsked())
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 6)
+	for i, want := range []struct {
+		allowed, approved, rateLimited bool
+		rule, ruleID                   string
+	}{
+		{allowed: true, rule: "domain=" + host + " path=/allowed"},
+		{allowed: true, approved: true},
+		{allowed: true, approved: true, rule: granted.Rule, ruleID: "approved"},
+		{approved: true, rateLimited: true, rule: granted.Rule, ruleID: "approved"},
+		{},
+		{rule: "path=/blocked"},
+	} {
+		assert.Equal(t, want.allowed, requests[i].Allowed, "request %d", i)
+		assert.Equal(t, want.approved, requests[i].Approved, "request %d", i)
+		assert.Equal(t, want.rateLimited, requests[i].RateLimited, "request %d", i)
+		assert.Equal(t, want.rule, requests[i].Rule, "request %d", i)
+		assert.Equal(t, want.ruleID, requests[i].RuleID, "request %d", i)
+		assert.True(t, strings.HasPrefix(requests[i].URL, server.URL), "request %d", i)
+	}
+}
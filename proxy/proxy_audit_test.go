@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/coder/boundary/audit"
+	"github.com/coder/boundary/rulesengine"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -159,6 +160,82 @@ func TestAuditURLIsFullyFormed_HTTP(t *testing.T) {
 	})
 }
 
+func TestAuditCarriesRuleIDAndTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	auditor := &capturingAuditor{}
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain="+serverURL.Hostname()+" path=/plain"),
+		WithStructuredRule(rulesengine.StructuredRule{
+			ID:      "backend-api",
+			Tags:    []string{"team-api", "ci"},
+			Methods: []string{"GET"},
+			Domains: []string{serverURL.Hostname()},
+			Paths:   []string{"/api/*"},
+		}),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	for _, path := range []string{"/api/users", "/plain"} {
+		resp, err := pt.proxyClient.Get(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 2)
+
+	assert.Equal(t, "method=GET domain="+serverURL.Hostname()+" path=/api/*", requests[0].Rule)
+	assert.Equal(t, "backend-api", requests[0].RuleID)
+	assert.Equal(t, []string{"team-api", "ci"}, requests[0].RuleTags)
+
+	// Rules written as strings have no metadata.
+	assert.Equal(t, "domain="+serverURL.Hostname()+" path=/plain", requests[1].Rule)
+	assert.Empty(t, requests[1].RuleID)
+	assert.Empty(t, requests[1].RuleTags)
+}
+
+func TestAuditReportsExpiredRule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	auditor := &capturingAuditor{}
+
+	expired := "domain=" + serverURL.Hostname() + " until=2020-01-01T00:00:00Z"
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule(expired),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	resp, err := pt.proxyClient.Get(server.URL + "/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 1)
+	assert.False(t, requests[0].Allowed)
+	assert.Empty(t, requests[0].Rule)
+	assert.Equal(t, expired, requests[0].ExpiredRule)
+}
+
 func TestAuditURLIsFullyFormed_HTTPS(t *testing.T) {
 	auditor := &capturingAuditor{}
 
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/coder/boundary/audit"
+	"github.com/coder/boundary/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforcementModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := serverURL.Hostname()
+
+	type outcome struct {
+		status      int
+		allowed     bool
+		rateLimited bool
+		action      audit.Action
+	}
+	for _, tc := range []struct {
+		mode config.EnforcementMode
+		// want is the outcome of requests to /allowed, /limited (twice), /other and /blocked.
+		want []outcome
+	}{
+		{
+			mode: config.EnforcementEnforce,
+			want: []outcome{
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusTooManyRequests, rateLimited: true, action: audit.ActionBlock},
+				{status: http.StatusForbidden, action: audit.ActionBlock},
+				{status: http.StatusForbidden, action: audit.ActionBlock},
+			},
+		},
+		{
+			mode: config.EnforcementMonitor,
+			want: []outcome{
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, rateLimited: true, action: audit.ActionForward},
+				{status: http.StatusOK, action: audit.ActionForward},
+				{status: http.StatusOK, action: audit.ActionForward},
+			},
+		},
+		{
+			mode: config.EnforcementOff,
+			want: []outcome{
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+				{status: http.StatusOK, allowed: true, action: audit.ActionForward},
+			},
+		},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			auditor := &capturingAuditor{}
+			pt := NewProxyTest(t,
+				WithCertManager(t.TempDir()),
+				WithAllowedRule("domain="+host+" path=/allowed"),
+				WithAllowedRule("domain="+host+" path=/limited quota=1"),
+				WithDeniedRule("path=/blocked"),
+				WithAuditor(auditor),
+				WithEnforcement(tc.mode),
+			).Start()
+			defer pt.Stop()
+
+			var statuses []int
+			for _, path := range []string{"/allowed", "/limited", "/limited", "/other", "/blocked"} {
+				resp, err := pt.proxyClient.Get(server.URL + path)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				statuses = append(statuses, resp.StatusCode)
+			}
+
+			requests := auditor.getRequests()
+			require.Len(t, requests, len(tc.want))
+			for i, want := range tc.want {
+				assert.Equal(t, want.status, statuses[i], "request %d", i)
+				assert.Equal(t, want.allowed, requests[i].Allowed, "request %d", i)
+				assert.Equal(t, want.rateLimited, requests[i].RateLimited, "request %d", i)
+				assert.Equal(t, want.action, requests[i].Action, "request %d", i)
+			}
+			if tc.mode != config.EnforcementOff {
+				// The decision is audited with the rule that made it, whatever the proxy did.
+				assert.Equal(t, "path=/blocked", requests[4].Rule)
+			}
+		})
+	}
+}
@@ -42,10 +42,18 @@ type ProxyTest struct {
 	configDir          string
 	startupDelay       time.Duration
 	allowedRules       []string
+	deniedRules        []string
+	structuredRules    []rulesengine.StructuredRule
 	auditor            audit.Auditor
 	sessionCorrelation config.SessionCorrelationConfig
 	sessionID          string
 	forwardTransport   http.RoundTripper
+	pathMode           config.PathMode
+	enforcement        config.EnforcementMode
+	approver           Approver
+	learner            Learner
+	idleTimeout        time.Duration
+	helloTimeout       time.Duration
 }
 
 // ProxyTestOption is a function that configures ProxyTest
@@ -106,6 +114,13 @@ func WithAllowedRule(rule string) ProxyTestOption {
 	}
 }
 
+// WithDeniedRule adds a deny rule (e.g., "domain=example.com path=/admin/*")
+func WithDeniedRule(rule string) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.deniedRules = append(pt.deniedRules, rule)
+	}
+}
+
 // WithAuditor sets a custom auditor for capturing audit requests
 func WithAuditor(auditor audit.Auditor) ProxyTestOption {
 	return func(pt *ProxyTest) {
@@ -137,6 +152,56 @@ func WithForwardTransport(transport http.RoundTripper) ProxyTestOption {
 	}
 }
 
+// WithStructuredRule adds a structured allow rule, as written in the config file, after the other allow rules.
+func WithStructuredRule(rule rulesengine.StructuredRule) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.structuredRules = append(pt.structuredRules, rule)
+	}
+}
+
+// WithPathMode sets what the proxy does with requests whose path isn't canonical.
+func WithPathMode(mode config.PathMode) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.pathMode = mode
+	}
+}
+
+// WithEnforcement sets whether the proxy blocks the requests the rules deny.
+func WithEnforcement(mode config.EnforcementMode) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.enforcement = mode
+	}
+}
+
+// WithApprover sets the approver asked about requests that no rule allows.
+func WithApprover(approver Approver) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.approver = approver
+	}
+}
+
+// WithLearner sets the learner handed the requests the rules deny, which are then forwarded.
+func WithLearner(learner Learner) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.learner = learner
+	}
+}
+
+// WithIdleTimeout sets how long the proxy keeps an idle client connection open.
+func WithIdleTimeout(timeout time.Duration) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.idleTimeout = timeout
+	}
+}
+
+// WithClientHelloTimeout sets how long the proxy waits for the ClientHello of a connection passthrough rules
+// may apply to.
+func WithClientHelloTimeout(timeout time.Duration) ProxyTestOption {
+	return func(pt *ProxyTest) {
+		pt.helloTimeout = timeout
+	}
+}
+
 // Start starts the proxy server
 func (pt *ProxyTest) Start() *ProxyTest {
 	pt.t.Helper()
@@ -147,6 +212,14 @@ func (pt *ProxyTest) Start() *ProxyTest {
 
 	testRules, err := rulesengine.ParseAllowSpecs(pt.allowedRules)
 	require.NoError(pt.t, err, "Failed to parse test rules")
+	for _, rule := range pt.structuredRules {
+		compiled, err := rule.Compile()
+		require.NoError(pt.t, err, "Failed to compile structured test rule")
+		testRules = append(testRules, compiled...)
+	}
+	denyRules, err := rulesengine.ParseDenySpecs(pt.deniedRules)
+	require.NoError(pt.t, err, "Failed to parse test deny rules")
+	testRules = append(testRules, denyRules...)
 
 	ruleEngine := rulesengine.NewRuleEngine(testRules, logger)
 
@@ -199,7 +272,17 @@ func (pt *ProxyTest) Start() *ProxyTest {
 		InjectEngine:       injectEngine,
 		SessionID:          pt.sessionID,
 		ForwardTransport:   pt.forwardTransport,
+		PathMode:           pt.pathMode,
+		Enforcement:        pt.enforcement,
+		IdleTimeout:        pt.idleTimeout,
+		ClientHelloTimeout: pt.helloTimeout,
 	})
+	if pt.approver != nil {
+		pt.server.SetApprover(pt.approver)
+	}
+	if pt.learner != nil {
+		pt.server.SetLearner(pt.learner)
+	}
 
 	err = pt.server.Start()
 	require.NoError(pt.t, err, "Failed to start server")
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHTTP2 verifies that clients negotiating h2 are served HTTP/2, with a
+// decision per stream and the trailers gRPC needs, both when they connect to
+// the proxy directly and through a CONNECT tunnel.
+func TestHTTP2(t *testing.T) {
+	// A gRPC-like upstream, which sends its status as an undeclared trailer.
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = io.WriteString(w, "reply to "+r.URL.Path+" over "+r.Proto)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	// TLS SNI requires a hostname, not an IP address.
+	target := "https://localhost:" + upstreamURL.Port()
+
+	auditor := &capturingAuditor{}
+	//nolint:gosec
+	insecureTransport := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain=localhost path=/pkg.Greeter/*"),
+		WithAuditor(auditor),
+		WithForwardTransport(insecureTransport),
+	).Start()
+	defer pt.Stop()
+
+	proxyAddr := "localhost:" + strconv.Itoa(pt.port)
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	require.NoError(t, err)
+
+	//nolint:gosec
+	clients := map[string]*http.Client{
+		// Like a redirected client in transparent mode, connecting to the proxy as if it were the server.
+		"direct": {Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, proxyAddr)
+			},
+		}},
+		"connect": {Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}},
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			start := len(auditor.getRequests())
+
+			resp, err := client.Post(target+"/pkg.Greeter/SayHello", "application/grpc", nil)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, 2, resp.ProtoMajor)
+			require.Equal(t, "reply to /pkg.Greeter/SayHello over HTTP/2.0", string(body))
+			require.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+
+			// Another stream on the same connection gets a decision of its own.
+			resp, err = client.Post(target+"/pkg.Admin/Shutdown", "application/grpc", nil)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, http.StatusForbidden, resp.StatusCode)
+			require.Equal(t, 2, resp.ProtoMajor)
+
+			requests := auditor.getRequests()[start:]
+			require.Len(t, requests, 2)
+			assert.True(t, requests[0].Allowed)
+			assert.Equal(t, target+"/pkg.Greeter/SayHello", requests[0].URL)
+			assert.False(t, requests[1].Allowed)
+			assert.Equal(t, target+"/pkg.Admin/Shutdown", requests[1].URL)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readResponse reads the next response from the connection, and its body.
+func readResponse(t *testing.T, reader *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp, string(body)
+}
+
+// TestKeepAliveHTTP verifies that a plain HTTP connection carries pipelined
+// requests until the client asks for it to be closed.
+func TestKeepAliveHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	auditor := &capturingAuditor{}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain(serverURL.Hostname()),
+		WithDeniedRule("path=/blocked"),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(pt.port))
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	// Three requests in one go. The body of the blocked one is never forwarded, and must not be taken for the
+	// next request.
+	host := serverURL.Host
+	_, err = io.WriteString(conn,
+		"GET "+server.URL+"/one HTTP/1.1\r\nHost: "+host+"\r\n\r\n"+
+			"POST "+server.URL+"/blocked HTTP/1.1\r\nHost: "+host+"\r\nContent-Length: 5\r\n\r\nhello"+
+			"GET "+server.URL+"/two HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, body := readResponse(t, reader)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/one", body)
+	require.False(t, resp.Close)
+
+	resp, _ = readResponse(t, reader)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = readResponse(t, reader)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/two", body)
+	require.True(t, resp.Close)
+
+	// The proxy closes the connection as asked.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Len(t, auditor.getRequests(), 3)
+}
+
+// TestKeepAliveUpstreamHeaders verifies that the upstream server's connection
+// headers don't reach the client, which keeps its connection to the proxy,
+// and that responses to HEAD requests aren't sent as chunked, nor close it.
+func TestKeepAliveUpstreamHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Without a length, like a streamed response would have.
+			_ = http.NewResponseController(w).Flush()
+			return
+		}
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Keep-Alive", "timeout=1")
+		w.Header().Set("Proxy-Connection", "close")
+		w.Header().Set("Upgrade", "h2c")
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain(serverURL.Hostname()),
+	).Start()
+	defer pt.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(pt.port))
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+	reader := bufio.NewReader(conn)
+
+	host := serverURL.Host
+	for _, path := range []string{"/one", "/two"} {
+		_, err = io.WriteString(conn, "GET "+server.URL+path+" HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+		require.NoError(t, err)
+
+		resp, body := readResponse(t, reader)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, path, body)
+		require.False(t, resp.Close)
+		for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Upgrade"} {
+			require.Empty(t, resp.Header.Values(name), name)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodHead, server.URL+"/head", nil)
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "HEAD "+server.URL+"/head HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(reader, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.TransferEncoding)
+	require.Empty(t, resp.Header.Values("Transfer-Encoding"))
+	require.False(t, resp.Close)
+
+	// Nothing follows the head of the response, and the connection is still there for the next request.
+	_, err = io.WriteString(conn, "GET "+server.URL+"/after-head HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	resp, body := readResponse(t, reader)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/after-head", body)
+}
+
+// TestKeepAliveTLS verifies that a TLS connection to the proxy serves
+// several requests with one handshake, and is closed once idle.
+func TestKeepAliveTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	// TLS SNI requires a hostname, not an IP address.
+	host := "localhost:" + serverURL.Port()
+
+	//nolint:gosec
+	insecureTransport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain("localhost"),
+		WithForwardTransport(insecureTransport),
+		WithIdleTimeout(100*time.Millisecond),
+	).Start()
+	defer pt.Stop()
+
+	//nolint:gosec
+	conn, err := tls.Dial("tcp", "localhost:"+strconv.Itoa(pt.port), &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "localhost",
+	})
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/one", "/two"} {
+		_, err = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+		require.NoError(t, err)
+
+		resp, body := readResponse(t, reader)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, path, body)
+		require.False(t, resp.Close)
+	}
+
+	// Without another request, the proxy closes the connection after the idle timeout.
+	start := time.Now()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
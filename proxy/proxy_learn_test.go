@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLearner records the requests it is handed.
+type recordingLearner struct {
+	mu      sync.Mutex
+	learned []rulesengine.Result
+}
+
+func (l *recordingLearner) Learn(_ rulesengine.Request, result rulesengine.Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.learned = append(l.learned, result)
+}
+
+func (l *recordingLearner) getLearned() []rulesengine.Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]rulesengine.Result(nil), l.learned...)
+}
+
+func TestLearnerThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := serverURL.Hostname()
+
+	auditor := &capturingAuditor{}
+	learner := &recordingLearner{}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain="+host+" path=/allowed"),
+		WithDeniedRule("path=/blocked"),
+		WithAuditor(auditor),
+		WithLearner(learner),
+	).Start()
+	defer pt.Stop()
+
+	// Nothing is blocked while learning, not even by deny rules.
+	for _, path := range []string{"/allowed", "/other", "/blocked"} {
+		resp, err := pt.proxyClient.Get(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	require.Equal(t, []rulesengine.Result{{}, {Rule: "path=/blocked"}}, learner.getLearned())
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 3)
+	for i, want := range []struct {
+		allowed, learned bool
+	}{
+		{allowed: true},
+		{learned: true},
+		{learned: true},
+	} {
+		assert.Equal(t, want.allowed, requests[i].Allowed, "request %d", i)
+		assert.Equal(t, want.learned, requests[i].Learned, "request %d", i)
+	}
+}
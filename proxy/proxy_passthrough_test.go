@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPassthrough verifies that a TLS connection to a server a passthrough
+// rule allows reaches the server undecrypted, and that other connections are
+// still decrypted and their requests evaluated.
+func TestPassthrough(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream"))
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	target := "localhost:" + serverURL.Port()
+
+	auditor := &capturingAuditor{}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("sni=localhost inspect=false"),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	// The client does the handshake with the server itself, so it sees the server's certificate.
+	tunnel, err := pt.establishExplicitCONNECT(target)
+	require.NoError(t, err)
+	certs := tunnel.tlsConn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, certs)
+	require.Equal(t, server.Certificate().Raw, certs[0].Raw)
+
+	body, err := tunnel.sendRequest(target, "/anything")
+	require.NoError(t, err)
+	require.Equal(t, "upstream", string(body))
+	require.NoError(t, tunnel.close())
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 1)
+	require.Equal(t, http.MethodConnect, requests[0].Method)
+	require.Equal(t, "https://"+target, requests[0].URL)
+	require.Equal(t, "sni=localhost inspect=false", requests[0].Rule)
+	require.True(t, requests[0].Passthrough)
+	require.True(t, requests[0].Allowed)
+
+	// No passthrough rule matches other server names, so those connections are decrypted, and the passthrough
+	// rule doesn't allow the requests in them.
+	tunnel, err = pt.establishExplicitCONNECT("example.com:" + serverURL.Port())
+	require.NoError(t, err)
+	certs = tunnel.tlsConn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, certs)
+	require.NotEqual(t, server.Certificate().Raw, certs[0].Raw)
+	require.NoError(t, tunnel.sendRequestAndExpectDeny("example.com", "/anything"))
+	require.NoError(t, tunnel.close())
+
+	requests = auditor.getRequests()
+	require.Len(t, requests, 2)
+	require.False(t, requests[1].Passthrough)
+	require.False(t, requests[1].Allowed)
+}
+
+// TestPassthroughStalledClient verifies that a client that doesn't send its
+// ClientHello doesn't hold its connection open.
+func TestPassthroughStalledClient(t *testing.T) {
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("sni=localhost inspect=false"),
+		WithClientHelloTimeout(100*time.Millisecond),
+	).Start()
+	defer pt.Stop()
+
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(pt.port))
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	_, err = io.WriteString(conn, "CONNECT localhost:443 HTTP/1.1\r\nHost: localhost:443\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Nothing is sent, so the proxy gives up and closes the connection.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = reader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter()
+	limit := rulesengine.Limit{Rate: 2, Interval: time.Minute}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// The bucket starts full, so a burst of Rate requests goes through.
+	for range 2 {
+		ok, _ := l.allow("rule", "a.example.com", limit, start)
+		require.True(t, ok)
+	}
+	ok, retryAfter := l.allow("rule", "a.example.com", limit, start)
+	require.False(t, ok)
+	require.Equal(t, 30*time.Second, retryAfter)
+
+	// A token comes back every 30 seconds.
+	ok, retryAfter = l.allow("rule", "a.example.com", limit, start.Add(20*time.Second))
+	require.False(t, ok)
+	require.Equal(t, 10*time.Second, retryAfter)
+	ok, _ = l.allow("rule", "a.example.com", limit, start.Add(30*time.Second))
+	require.True(t, ok)
+
+	// Without per=host, every host counts against the same bucket.
+	ok, _ = l.allow("rule", "b.example.com", limit, start.Add(30*time.Second))
+	require.False(t, ok)
+
+	// Other rules have buckets of their own.
+	ok, _ = l.allow("other rule", "a.example.com", limit, start.Add(30*time.Second))
+	require.True(t, ok)
+}
+
+func TestLimiterPerHost(t *testing.T) {
+	l := newLimiter()
+	limit := rulesengine.Limit{Rate: 1, Interval: time.Hour, PerHost: true}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	ok, _ := l.allow("rule", "a.example.com", limit, now)
+	require.True(t, ok)
+	ok, _ = l.allow("rule", "a.example.com", limit, now)
+	require.False(t, ok)
+	ok, _ = l.allow("rule", "b.example.com", limit, now)
+	require.True(t, ok)
+}
+
+func TestLimiterQuota(t *testing.T) {
+	l := newLimiter()
+	limit := rulesengine.Limit{Rate: 1, Interval: time.Second, Quota: 2}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	ok, _ := l.allow("rule", "", limit, now)
+	require.True(t, ok)
+
+	// A rate limited request doesn't use up the quota.
+	ok, retryAfter := l.allow("rule", "", limit, now)
+	require.False(t, ok)
+	require.Equal(t, time.Second, retryAfter)
+
+	ok, _ = l.allow("rule", "", limit, now.Add(time.Second))
+	require.True(t, ok)
+
+	// Once the quota is used up, waiting doesn't help.
+	ok, retryAfter = l.allow("rule", "", limit, now.Add(time.Hour))
+	require.False(t, ok)
+	require.Zero(t, retryAfter)
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter()
+	limit := rulesengine.Limit{Rate: 1, Interval: time.Minute, PerHost: true}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		ok, _ := l.allow("rule", host, limit, now)
+		require.True(t, ok)
+	}
+	ok, _ := l.allow("quota", "a.example.com", rulesengine.Limit{Quota: 1, PerHost: true}, now)
+	require.True(t, ok)
+	require.Len(t, l.buckets, 3)
+
+	// Once refilled, the buckets of a host are dropped, but those counting towards a quota are kept.
+	now = now.Add(limiterSweepInterval)
+	ok, _ = l.allow("rule", "a.example.com", limit, now)
+	require.True(t, ok)
+	require.Len(t, l.buckets, 2)
+	require.Equal(t, 1, l.hosts["rule"])
+	ok, _ = l.allow("quota", "a.example.com", rulesengine.Limit{Quota: 1, PerHost: true}, now)
+	require.False(t, ok)
+}
+
+func TestLimiterMaxHosts(t *testing.T) {
+	l := newLimiter()
+	limit := rulesengine.Limit{Quota: 1, PerHost: true}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for i := range maxHostBuckets {
+		ok, _ := l.allow("rule", strconv.Itoa(i)+".example.com", limit, now)
+		require.True(t, ok)
+	}
+
+	// Hosts past the cap share a bucket.
+	ok, _ := l.allow("rule", "new.example.com", limit, now)
+	require.True(t, ok)
+	ok, _ = l.allow("rule", "other.example.com", limit, now)
+	require.False(t, ok)
+	require.Len(t, l.buckets, maxHostBuckets+1)
+}
+
+func TestRateLimitThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := serverURL.Hostname()
+
+	auditor := &capturingAuditor{}
+
+	rateRule := "domain=" + host + " path=/rate rate=2/h"
+	quotaRule := "domain=" + host + " path=/quota quota=1"
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule(rateRule),
+		WithAllowedRule(quotaRule),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	get := func(path string) *http.Response {
+		resp, err := pt.proxyClient.Get(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, get("/rate").StatusCode)
+	require.Equal(t, http.StatusOK, get("/rate").StatusCode)
+	resp := get("/rate")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	// A token comes back every 30 minutes, less the time the first requests took.
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	require.InDelta(t, 1800, retryAfter, 10)
+
+	require.Equal(t, http.StatusOK, get("/quota").StatusCode)
+	resp = get("/quota")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Retry-After"))
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 5)
+	for i, wantLimited := range []bool{false, false, true, false, true} {
+		assert.Equal(t, !wantLimited, requests[i].Allowed, "request %d", i)
+		assert.Equal(t, wantLimited, requests[i].RateLimited, "request %d", i)
+	}
+	assert.Equal(t, rateRule, requests[2].Rule)
+	assert.Equal(t, quotaRule, requests[4].Rule)
+}
+
+// TestRateLimitPerHostThroughProxy verifies that per=host limits count every
+// host separately for requests that don't name the host in their URL: plain
+// HTTP in origin form, as redirected connections send it, and HTTPS.
+func TestRateLimitPerHostThroughProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	// Every host is served by the test servers, plain HTTP or TLS by port.
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			target := httpServer.Listener.Addr().String()
+			if strings.HasSuffix(address, ":443") {
+				target = tlsServer.Listener.Addr().String()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, target)
+		},
+	}
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain=*.example.com rate=1/h per=host"),
+		WithForwardTransport(transport),
+	).Start()
+	defer pt.Stop()
+
+	t.Run("origin form", func(t *testing.T) {
+		conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(pt.port))
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+		reader := bufio.NewReader(conn)
+
+		for _, tc := range []struct {
+			host string
+			want int
+		}{
+			{"a.example.com", http.StatusOK},
+			{"B.Example.com:80", http.StatusOK},
+			{"a.example.com", http.StatusTooManyRequests},
+			{"b.example.com", http.StatusTooManyRequests},
+		} {
+			_, err := io.WriteString(conn, "GET /x HTTP/1.1\r\nHost: "+tc.host+"\r\n\r\n")
+			require.NoError(t, err)
+			resp, _ := readResponse(t, reader)
+			require.Equal(t, tc.want, resp.StatusCode, tc.host)
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		for _, tc := range []struct {
+			host string
+			want int
+		}{
+			{"c.example.com", http.StatusOK},
+			{"d.example.com", http.StatusOK},
+			{"c.example.com", http.StatusTooManyRequests},
+		} {
+			tunnel, err := pt.establishExplicitCONNECT(tc.host + ":443")
+			require.NoError(t, err)
+			_, err = io.WriteString(tunnel.tlsConn, "GET /x HTTP/1.1\r\nHost: "+tc.host+"\r\n\r\n")
+			require.NoError(t, err)
+			resp, _ := readResponse(t, tunnel.reader)
+			require.Equal(t, tc.want, resp.StatusCode, tc.host)
+			require.NoError(t, tunnel.close())
+		}
+	})
+}
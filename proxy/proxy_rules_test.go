@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/coder/boundary/config"
+	"github.com/coder/boundary/rulesengine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHeaderRulesThroughProxy verifies that the proxy hands request headers to
+// the rules engine, so header-based allow and deny rules are enforced.
+func TestHeaderRulesThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	auditor := &capturingAuditor{}
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain="+serverURL.Hostname()+" header=X-Org:acme"),
+		WithDeniedRule("header=Cookie"),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+		wantRule   string
+	}{
+		{
+			name:       "required header present",
+			header:     http.Header{"X-Org": {"acme"}},
+			wantStatus: http.StatusOK,
+			wantRule:   "domain=" + serverURL.Hostname() + " header=X-Org:acme",
+		},
+		{
+			name:       "required header missing",
+			header:     http.Header{},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "denied header present",
+			header:     http.Header{"X-Org": {"acme"}, "Cookie": {"session=1"}},
+			wantStatus: http.StatusForbidden,
+			wantRule:   "header=Cookie",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+			require.NoError(t, err)
+			req.Header = tt.header
+
+			resp, err := pt.proxyClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			requests := auditor.getRequests()
+			require.NotEmpty(t, requests)
+			require.Equal(t, tt.wantRule, requests[len(requests)-1].Rule)
+		})
+	}
+}
+
+// TestSchemeAndPortRulesThroughProxy verifies that the proxy evaluates rules
+// against the scheme and port the client actually used.
+func TestSchemeAndPortRulesThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		rule       string
+		wantStatus int
+	}{
+		{
+			name:       "matching port",
+			rule:       "domain=" + serverURL.Hostname() + " port=" + serverURL.Port(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "other port",
+			rule:       "domain=" + serverURL.Hostname() + " port=1",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "http scheme",
+			rule:       "scheme=http domain=" + serverURL.Hostname(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "https required",
+			rule:       "scheme=https domain=" + serverURL.Hostname(),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := NewProxyTest(t,
+				WithCertManager(t.TempDir()),
+				WithAllowedRule(tt.rule),
+			).Start()
+			defer pt.Stop()
+
+			pt.ExpectGetViaProxy(server.URL+"/", tt.wantStatus)
+		})
+	}
+}
+
+// TestIPRulesThroughProxy verifies that ip rules match requests to IP-literal
+// hosts.
+func TestIPRulesThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		rule       string
+		wantStatus int
+	}{
+		{
+			name:       "address in prefix",
+			rule:       "ip=127.0.0.0/8",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "exact address",
+			rule:       "ip=127.0.0.1 port=" + serverURL.Port(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "address outside prefix",
+			rule:       "ip=10.0.0.0/8",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := NewProxyTest(t,
+				WithCertManager(t.TempDir()),
+				WithAllowedRule(tt.rule),
+			).Start()
+			defer pt.Stop()
+
+			pt.ExpectGetViaProxy(server.URL+"/", tt.wantStatus)
+		})
+	}
+}
+
+// TestPathCanonicalizationThroughProxy verifies that the proxy evaluates and
+// forwards the same canonical path, so dot segments can't be used to reach a
+// path the rules deny.
+func TestPathCanonicalizationThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.EscapedPath())
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	host := serverURL.Hostname()
+
+	tests := []struct {
+		name       string
+		mode       config.PathMode
+		path       string
+		wantStatus int
+		wantPath   string
+	}{
+		{
+			name:       "canonical path",
+			mode:       config.PathNormalize,
+			path:       "/api/v1",
+			wantStatus: http.StatusOK,
+			wantPath:   "/api/v1",
+		},
+		{
+			name:       "traversal into a denied path",
+			mode:       config.PathNormalize,
+			path:       "/api/v1/../../admin/users",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "traversal into an allowed path is forwarded normalized",
+			mode:       config.PathNormalize,
+			path:       "/admin/../api/v1",
+			wantStatus: http.StatusOK,
+			wantPath:   "/api/v1",
+		},
+		{
+			name:       "empty segments are forwarded collapsed",
+			mode:       config.PathNormalize,
+			path:       "//api//v1",
+			wantStatus: http.StatusOK,
+			wantPath:   "/api/v1",
+		},
+		{
+			name:       "encoded slash is forwarded as sent",
+			mode:       config.PathNormalize,
+			path:       "/api/@scope%2Fpkg",
+			wantStatus: http.StatusOK,
+			wantPath:   "/api/@scope%2Fpkg",
+		},
+		{
+			name:       "traversal behind an encoded slash",
+			mode:       config.PathNormalize,
+			path:       "/api/..%2F..%2Fadmin",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "reject mode refuses dot segments",
+			mode:       config.PathReject,
+			path:       "/admin/../api/v1",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "reject mode refuses empty segments",
+			mode:       config.PathReject,
+			path:       "/api//v1",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "reject mode forwards canonical paths",
+			mode:       config.PathReject,
+			path:       "/api/v1",
+			wantStatus: http.StatusOK,
+			wantPath:   "/api/v1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := NewProxyTest(t,
+				WithCertManager(t.TempDir()),
+				WithAllowedRule("domain="+host+" path=/api/**"),
+				WithDeniedRule("domain="+host+" path=/admin/**"),
+				WithPathMode(tt.mode),
+			).Start()
+			defer pt.Stop()
+
+			resp, err := pt.proxyClient.Get(server.URL + tt.path)
+			require.NoError(t, err)
+			defer resp.Body.Close() //nolint:errcheck
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus == http.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantPath, string(body))
+			}
+		})
+	}
+}
+
+// TestSetRuleEngineThroughProxy verifies that swapping the rule engine of a
+// running proxy applies to the next request, with no restart.
+func TestSetRuleEngineThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedRule("domain="+serverURL.Hostname()+" path=/old"),
+	).Start()
+	defer pt.Stop()
+
+	get := func(path string) int {
+		resp, err := pt.proxyClient.Get(server.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get("/old"))
+	require.Equal(t, http.StatusForbidden, get("/new"))
+
+	rules, err := rulesengine.ParseAllowSpecs([]string{"domain=" + serverURL.Hostname() + " path=/new"})
+	require.NoError(t, err)
+	engine := rulesengine.NewRuleEngine(rules, slog.Default())
+	oldHash := pt.server.RuleEngine().Hash()
+	old := pt.server.SetRuleEngine(engine)
+	require.Equal(t, oldHash, old.Hash())
+	require.Equal(t, engine.Hash(), pt.server.RuleEngine().Hash())
+
+	require.Equal(t, http.StatusForbidden, get("/old"))
+	require.Equal(t, http.StatusOK, get("/new"))
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStreamingResponse verifies that the proxy passes on a response as it
+// arrives, rather than once the upstream server has finished it.
+func TestStreamingResponse(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// The rest of the stream only comes once the client has seen the first event.
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain(serverURL.Hostname()),
+	).Start()
+	defer pt.Stop()
+
+	resp, err := pt.proxyClient.Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "HTTP/1.1", resp.Proto)
+	require.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "data: first\n", line)
+	close(received)
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "\ndata: second\n\n", string(rest))
+}
+
+// TestStreamingResponseKeepsContentLength verifies that a response of known
+// length is streamed with its Content-Length, and a bodyless one without a
+// body.
+func TestStreamingResponseKeepsContentLength(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain(serverURL.Hostname()),
+	).Start()
+	defer pt.Stop()
+
+	resp, err := pt.proxyClient.Get(server.URL + "/tarball.tgz")
+	require.NoError(t, err)
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, int64(len(body)), resp.ContentLength)
+	require.Empty(t, resp.TransferEncoding)
+	require.Equal(t, body, string(got))
+
+	resp, err = pt.proxyClient.Get(server.URL + "/empty")
+	require.NoError(t, err)
+	got, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Empty(t, resp.TransferEncoding)
+	require.Empty(t, got)
+}
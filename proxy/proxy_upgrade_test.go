@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/coder/boundary/audit"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeAuditor captures upgraded connections as well as requests.
+type upgradeAuditor struct {
+	capturingAuditor
+	mu       sync.Mutex
+	upgrades []audit.Upgrade
+}
+
+func (a *upgradeAuditor) AuditUpgrade(upgrade audit.Upgrade) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.upgrades = append(a.upgrades, upgrade)
+}
+
+func (a *upgradeAuditor) getUpgrades() []audit.Upgrade {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]audit.Upgrade{}, a.upgrades...)
+}
+
+// TestUpgrade verifies that an allowed upgrade request switches the
+// connection to the upstream server's protocol, and that the upgraded
+// connection is audited once it's closed.
+func TestUpgrade(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			http.Error(w, "not an upgrade", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+
+		_, _ = io.WriteString(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = rw.Flush()
+		// Echo one message, then hang up.
+		msg := make([]byte, 5)
+		if _, err := io.ReadFull(rw, msg); err != nil {
+			return
+		}
+		_, _ = rw.Write(append(msg, msg...))
+		_ = rw.Flush()
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	auditor := &upgradeAuditor{}
+	pt := NewProxyTest(t,
+		WithCertManager(t.TempDir()),
+		WithAllowedDomain(serverURL.Hostname()),
+		WithDeniedRule("path=/blocked"),
+		WithAuditor(auditor),
+	).Start()
+	defer pt.Stop()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(pt.port))
+		require.NoError(t, err)
+		return conn, bufio.NewReader(conn)
+	}
+	request := func(path string) string {
+		return "GET " + server.URL + path + " HTTP/1.1\r\nHost: " + serverURL.Host +
+			"\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"
+	}
+
+	// The first message is sent along with the request, before the upgrade is done.
+	conn, reader := dial()
+	defer conn.Close() //nolint:errcheck
+	_, err = io.WriteString(conn, request("/ws")+"hello")
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	require.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+
+	echo, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "hellohello", string(echo))
+
+	upgrades := auditor.getUpgrades()
+	require.Len(t, upgrades, 1)
+	require.Equal(t, "GET", upgrades[0].Method)
+	require.Equal(t, server.URL+"/ws", upgrades[0].URL)
+	require.Equal(t, "websocket", upgrades[0].Protocol)
+	require.Equal(t, int64(5), upgrades[0].BytesSent)
+	require.Equal(t, int64(10), upgrades[0].BytesReceived)
+	require.Positive(t, upgrades[0].Duration)
+
+	requests := auditor.getRequests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "websocket", requests[0].Upgrade)
+	require.Equal(t, upgrades[0].SequenceNumber, requests[0].SequenceNumber)
+
+	// A denied upgrade request gets a plain 403, and nothing is upgraded.
+	conn, reader = dial()
+	defer conn.Close() //nolint:errcheck
+	_, err = io.WriteString(conn, request("/blocked"))
+	require.NoError(t, err)
+	resp, _ = readResponse(t, reader)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Len(t, auditor.getUpgrades(), 1)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/coder/boundary/rulesengine"
+)
+
+const (
+	// limiterSweepInterval is how often buckets that don't hold anything worth keeping are dropped.
+	limiterSweepInterval = time.Minute
+	// maxHostBuckets is how many hosts a `per=host` rule counts separately. Further hosts share a bucket.
+	maxHostBuckets = 10000
+)
+
+// limiter enforces the rate limits and quotas of allow rules, see rulesengine.Limit. Counts are kept per rule,
+// keyed by its rulesengine.Result.LimitKey, or per rule and host for `per=host`. A bucket is dropped once it has
+// refilled, unless it counts towards a quota, since a new one would be the same.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[limitKey]*bucket
+	// hosts is how many buckets of each rule are for a single host.
+	hosts     map[string]int
+	lastSweep time.Time
+}
+
+type limitKey struct {
+	rule string
+	host string
+}
+
+// bucket is a token bucket holding up to Limit.Rate tokens, refilled at Limit.Rate tokens per Limit.Interval,
+// together with the number of requests let through so far for the quota.
+type bucket struct {
+	limit  rulesengine.Limit
+	tokens float64
+	last   time.Time
+	used   int
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: make(map[limitKey]*bucket), hosts: make(map[string]int)}
+}
+
+// allow reports whether a request allowed by the rule with LimitKey rule may go through, and takes it into account
+// if so. When it may not, retryAfter is how long until it may, or zero when the quota is used up and waiting won't
+// help.
+func (l *limiter) allow(rule, host string, limit rulesengine.Limit, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	key := limitKey{rule: rule}
+	if limit.PerHost {
+		key.host = host
+	}
+	b, found := l.buckets[key]
+	if !found && key.host != "" && l.hosts[rule] >= maxHostBuckets {
+		// Hosts past the cap count together, which keeps the map from growing without bound.
+		key.host = ""
+		b, found = l.buckets[key]
+	}
+	if !found {
+		b = &bucket{limit: limit, tokens: float64(limit.Rate), last: now}
+		l.buckets[key] = b
+		if key.host != "" {
+			l.hosts[rule]++
+		}
+	}
+
+	if limit.Quota > 0 && b.used >= limit.Quota {
+		return false, 0
+	}
+
+	if limit.Rate > 0 {
+		b.refill(now)
+		if b.tokens < 1 {
+			return false, time.Duration((1 - b.tokens) * float64(b.perToken()))
+		}
+		b.tokens--
+	}
+
+	b.used++
+	return true, 0
+}
+
+// sweep drops the buckets that a new bucket would do as well as by now.
+func (l *limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.limit.Quota > 0 {
+			continue
+		}
+		if b.limit.Rate > 0 {
+			b.refill(now)
+			if b.tokens < float64(b.limit.Rate) {
+				continue
+			}
+		}
+		delete(l.buckets, key)
+		if key.host != "" {
+			if l.hosts[key.rule]--; l.hosts[key.rule] == 0 {
+				delete(l.hosts, key.rule)
+			}
+		}
+	}
+}
+
+func (b *bucket) perToken() time.Duration {
+	return b.limit.Interval / time.Duration(b.limit.Rate)
+}
+
+// refill adds the tokens that came back since the bucket was last refilled.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Rate), b.tokens+float64(elapsed)/float64(b.perToken()))
+		b.last = now
+	}
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientWriter sends the response to a request back to the client that sent it, over whichever protocol the
+// client speaks.
+type clientWriter interface {
+	// writeResponse sends resp, streaming its body as it is read: server-sent events and streamed completions
+	// aren't held back, and large downloads aren't held in memory. After a successful write, resp.Close tells
+	// whether the client connection has to be closed.
+	writeResponse(resp *http.Response) error
+}
+
+// hopByHopHeaders are the headers that only apply to a single connection, and so aren't passed on.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Trailer",
+}
+
+// http1Writer writes responses to an HTTP/1.x client connection.
+type http1Writer struct {
+	conn net.Conn
+	// reader is what the request was read from; after an upgrade it has whatever the client sent next.
+	reader *bufio.Reader
+	req    *http.Request
+}
+
+func (w http1Writer) writeResponse(resp *http.Response) error {
+	// The downstream client always communicates over HTTP/1.1 here.
+	// However, Go's default HTTP client may negotiate an HTTP/2 connection
+	// with the upstream server via ALPN during TLS handshake.
+	// This can cause the response's Proto field to be set to "HTTP/2.0",
+	// which would produce an invalid response for an HTTP/1.1 client.
+	// To prevent this mismatch, we explicitly normalize the response
+	// to HTTP/1.1 before writing it back to the client.
+	resp.Proto = "HTTP/1.1"
+	resp.ProtoMajor = 1
+	resp.ProtoMinor = 1
+
+	// Whether the connection to the client stays open is up to the client, not the upstream server. The upstream
+	// server's connection headers only apply to its connection to the proxy, so they are dropped, and resp.Write
+	// sends "Connection: close" if resp.Close is set. Upgrades don't get here, see http1Writer.upgrade.
+	resp.Close = w.req.Close
+	for _, name := range hopByHopHeaders {
+		resp.Header.Del(name)
+	}
+
+	// Responses to HEAD requests have no body to encode, whatever the upstream server says.
+	if w.req.Method == http.MethodHead {
+		resp.TransferEncoding = nil
+	}
+
+	// A body of unknown length, as HTTP/2 responses and decompressed ones have, is sent with chunked transfer
+	// encoding. HTTP/1.0 clients don't know that, so for them the end of the body is the end of the connection.
+	if resp.ContentLength < 0 && bodyAllowedForStatus(resp.StatusCode) {
+		switch {
+		case w.req.Method == http.MethodHead:
+			return w.writeHead(resp)
+		case w.req.ProtoAtLeast(1, 1):
+			resp.TransferEncoding = []string{"chunked"}
+		default:
+			resp.Close = true
+		}
+	}
+
+	bw := bufio.NewWriter(w.conn)
+	if resp.Body != nil {
+		resp.Body = flushingBody{ReadCloser: resp.Body, w: bw}
+	}
+
+	// bw is wrapped to hide its ReadFrom, which would read the body into the very buffer flushingBody flushes.
+	if err := resp.Write(writerOnly{bw}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeHead writes the status line and headers of resp, without a length. resp.Write would close the connection
+// to leave out the length, though a response to a HEAD request has no body to find the end of.
+func (w http1Writer) writeHead(resp *http.Response) error {
+	text := resp.Status
+	if text == "" {
+		text = http.StatusText(resp.StatusCode)
+	} else {
+		text = strings.TrimPrefix(text, strconv.Itoa(resp.StatusCode)+" ")
+	}
+
+	bw := bufio.NewWriter(w.conn)
+	if _, err := fmt.Fprintf(bw, "HTTP/1.1 %03d %s\r\n", resp.StatusCode, text); err != nil {
+		return err
+	}
+	if resp.Close {
+		if _, err := bw.WriteString("Connection: close\r\n"); err != nil {
+			return err
+		}
+	}
+	if err := resp.Header.Write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// http2Writer writes responses to a stream of an HTTP/2 client connection.
+type http2Writer struct {
+	w http.ResponseWriter
+}
+
+func (w http2Writer) writeResponse(resp *http.Response) error {
+	// HTTP/2 has no connection-specific headers; the client connection is kept open in any case.
+	resp.Close = false
+
+	header := w.w.Header()
+	for name, values := range resp.Header {
+		header[name] = values
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+	if resp.ContentLength >= 0 && bodyAllowedForStatus(resp.StatusCode) {
+		header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.w.WriteHeader(resp.StatusCode)
+
+	if resp.Body != nil {
+		rc := http.NewResponseController(w.w)
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if _, err := w.w.Write(buf[:n]); err != nil {
+					return err
+				}
+				if err := rc.Flush(); err != nil {
+					return err
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Trailers, such as gRPC's grpc-status, are only known once the body has been read, whether the upstream
+	// server declared them or not.
+	for name, values := range resp.Trailer {
+		for _, value := range values {
+			header.Add(http.TrailerPrefix+name, value)
+		}
+	}
+	return nil
+}
+
+// flushingBody flushes w before every read of the response body, so that everything read so far reaches the
+// client before the proxy waits for more from upstream.
+type flushingBody struct {
+	io.ReadCloser
+	w *bufio.Writer
+}
+
+func (b flushingBody) Read(p []byte) (int, error) {
+	if err := b.w.Flush(); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// writerOnly hides every method of a writer but Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// bodyAllowedForStatus reports whether a response with the status code may have a body.
+func bodyAllowedForStatus(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/boundary/audit"
+)
+
+// upgrader is implemented by client writers whose connection can switch protocols after a 101 Switching
+// Protocols response, as WebSocket connections do. HTTP/2 has no such upgrades.
+type upgrader interface {
+	// upgrade sends resp, the upstream server's 101 response, and then copies bytes both ways between the
+	// client connection and upstream until either side is done. It returns how many bytes went each way.
+	upgrade(resp *http.Response, upstream io.ReadWriteCloser) (sent, received int64, err error)
+}
+
+// isUpgradeRequest reports whether the client asks to switch the connection to another protocol, e.g. with
+// "Connection: Upgrade" and "Upgrade: websocket".
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w http1Writer) upgrade(resp *http.Response, upstream io.ReadWriteCloser) (int64, int64, error) {
+	// The connection belongs to the new protocol now, the response has no body of its own. resp.Body is
+	// upstream, and still has to be closed by the caller.
+	head := *resp
+	head.Proto = "HTTP/1.1"
+	head.ProtoMajor = 1
+	head.ProtoMinor = 1
+	head.Body = nil
+	if err := head.Write(w.conn); err != nil {
+		return 0, 0, err
+	}
+
+	// The client may have sent more than the request already, so what reaches upstream is read through the
+	// same reader the request was.
+	var client io.Reader = w.conn
+	if w.reader != nil {
+		client = w.reader
+	}
+
+	return splice(w.conn, client, upstream)
+}
+
+// splice copies bytes both ways between the client connection conn and upstream until either side is done. What
+// the client sends is read from client, which may have some of it buffered already. It returns how many bytes
+// went each way.
+func splice(conn net.Conn, client io.Reader, upstream io.ReadWriteCloser) (sent, received int64, err error) {
+	sentc := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(upstream, client)
+		// Let upstream know the client is done.
+		_ = upstream.Close()
+		sentc <- n
+	}()
+	received, err = io.Copy(conn, upstream)
+	// Upstream is done, so stop waiting on the client.
+	_ = conn.SetReadDeadline(time.Now())
+	return <-sentc, received, err
+}
+
+// forwardUpgrade hands the connection over to the protocol the upstream server switched to, and audits the
+// upgraded connection once it's closed. The client connection can't carry HTTP requests afterwards.
+func (p *Server) forwardUpgrade(u upgrader, req *http.Request, fullURL string, resp *http.Response, seqNum int32) {
+	// Copying closes upstream once the client is done; it is closed here in case copying never started.
+	upstream := resp.Body.(io.ReadWriteCloser)
+	defer func() {
+		_ = upstream.Close()
+	}()
+	protocol := resp.Header.Get("Upgrade")
+	p.logger.Debug("Switching protocols", "host", req.Host, "protocol", protocol)
+
+	start := time.Now()
+	sent, received, err := u.upgrade(resp, upstream)
+	duration := time.Since(start)
+	if err != nil {
+		p.logger.Debug("Upgraded connection ended", "host", req.Host, "error", err)
+	}
+
+	if ua, ok := p.auditor.(audit.UpgradeAuditor); ok {
+		ua.AuditUpgrade(audit.Upgrade{
+			Method:         req.Method,
+			URL:            fullURL,
+			Host:           req.Host,
+			Protocol:       protocol,
+			Duration:       duration,
+			BytesSent:      sent,
+			BytesReceived:  received,
+			SequenceNumber: seqNum,
+		})
+	}
+}
@@ -1,52 +1,395 @@
 package rulesengine
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
 	neturl "net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
 // Engine evaluates HTTP requests against a set of rules.
 type Engine struct {
-	rules  []Rule
+	// all are the rules the engine was created with, see Rules.
+	all       []Rule
+	rules     []Rule
+	denyRules []Rule
+	// passthroughRules are the allow rules with inspect=false, which only apply to connections.
+	passthroughRules []Rule
+	// Indexes over rules, denyRules and passthroughRules, see ruleIndex.
+	allowIndex       *ruleIndex
+	denyIndex        *ruleIndex
+	passthroughIndex *ruleIndex
+	// limitKeys are the Result.LimitKey of each of rules.
+	limitKeys []string
+	// hasUntil is set when an allow rule can expire, see firstExpired.
+	hasUntil bool
+	// hash identifies the policy, see Hash.
+	hash   string
 	logger *slog.Logger
 }
 
-// NewRuleEngine creates a new rule engine
+// NewRuleEngine creates a new rule engine. Allow and deny rules can be passed
+// in the same slice; deny rules are always evaluated first.
 func NewRuleEngine(rules []Rule, logger *slog.Logger) Engine {
+	var allowRules, denyRules, passthroughRules []Rule
+	for _, rule := range rules {
+		switch {
+		case rule.Deny:
+			denyRules = append(denyRules, rule)
+		case rule.Passthrough:
+			passthroughRules = append(passthroughRules, rule)
+		default:
+			allowRules = append(allowRules, rule)
+		}
+	}
+
+	logger.Debug("compiled rule engine", "allow_rules", len(allowRules), "deny_rules", len(denyRules), "passthrough_rules", len(passthroughRules))
+
 	return Engine{
-		rules:  rules,
-		logger: logger,
+		all:              slices.Clip(rules),
+		rules:            allowRules,
+		denyRules:        denyRules,
+		passthroughRules: passthroughRules,
+		limitKeys:        limitKeys(allowRules),
+		allowIndex:       newRuleIndex(allowRules),
+		denyIndex:        newRuleIndex(denyRules),
+		passthroughIndex: newRuleIndex(passthroughRules),
+		hasUntil:         slices.ContainsFunc(allowRules, func(r Rule) bool { return !r.Until.IsZero() }),
+		hash:             policyHash(rules),
+		logger:           logger,
+	}
+}
+
+// limitKeys returns the Result.LimitKey of each rule: its spec, numbered by how many rules before it have the same
+// spec. Rules that merely look the same keep separate counts, and a rule keeps its key in an engine for a policy
+// it carried over to, unless a rule with the same spec was added or removed before it.
+func limitKeys(rules []Rule) []string {
+	keys := make([]string, len(rules))
+	seen := make(map[string]int)
+	for i, rule := range rules {
+		keys[i] = strconv.Itoa(seen[rule.Raw]) + " " + rule.Raw
+		seen[rule.Raw]++
 	}
+	return keys
+}
+
+// Hash returns a hex encoded SHA-256 hash of the rules the engine was created with. Engines created with the same
+// rules in the same order have the same hash, so it tells apart the policies a proxy enforced over time.
+func (re *Engine) Hash() string {
+	return re.hash
+}
+
+// Rules returns the rules the engine was created with, in the same order.
+func (re *Engine) Rules() []Rule {
+	return slices.Clone(re.all)
+}
+
+// policyHash hashes everything about the rules that decides a request or shows up in its audit event: the spec,
+// whether it denies, the metadata from the config file and the time bounds. Descriptions are left out.
+func policyHash(rules []Rule) string {
+	h := sha256.New()
+	for _, r := range rules {
+		// Every field is followed by a NUL, and the tags by their count, so that no two rule lists write the same bytes.
+		fmt.Fprintf(h, "%t\x00%s\x00%s\x00%s\x00%s\x00%d\x00", r.Deny, r.Raw, r.ID, formatBound(r.From), formatBound(r.Until), len(r.Tags))
+		for _, tag := range r.Tags {
+			fmt.Fprintf(h, "%s\x00", tag)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
 // Result contains the result of rule evaluation
 type Result struct {
 	Allowed bool
-	Rule    string // The rule that matched (if any)
+	// The rule that decided the request: the allow rule that matched, or the
+	// deny rule that blocked it. Empty when no rule matched (default deny).
+	Rule string
+	// RuleID and RuleTags are the metadata of the deciding rule, see Rule.ID.
+	RuleID   string
+	RuleTags []string
+	// ExpiredRule is set when no rule matched, but an allow rule would have matched if it hadn't expired. It is
+	// the first such rule, so a denial caused by an expired grant can be told apart from one never granted.
+	ExpiredRule string
+	// Limit is the limit of the allow rule that matched, for the caller to enforce. See Rule.Limit.
+	Limit Limit
+	// LimitKey identifies the allow rule that matched, for the caller to keep the counts of Limit by. Unlike
+	// Rule, it tells apart rules with the same spec, and it stays the same across policy reloads that keep the
+	// rule.
+	LimitKey string
+	// Destination is set when the request was allowed by an ip pattern matched
+	// against Request.Destination rather than an IP-literal host. The request
+	// must then be forwarded to this address, not to wherever its host name
+	// resolves, or the address check would be meaningless.
+	Destination netip.Addr
+}
+
+// Request describes the parts of an HTTP request that rules are evaluated against.
+type Request struct {
+	Method string
+	// URL is the fully qualified request URL. The scheme and port it carries are matched against scheme and port
+	// patterns. A URL without a scheme never matches rules that constrain the scheme.
+	URL string
+	// Header holds the request headers. A nil Header is treated as a request without headers.
+	Header http.Header
+	// Destination is the address the client originally connected to, when known. It is only set for
+	// transparently redirected connections and is matched against ip patterns when the URL host is a name.
+	Destination netip.Addr
+	// Time is when the request is made, which decides whether rules have expired. The zero value means now.
+	Time time.Time
 }
 
-// Evaluate evaluates a request and returns both result and matching rule
+// Evaluate evaluates a request given only its method and URL. It is a shorthand for EvaluateRequest for callers
+// that have no headers to match against.
 func (re *Engine) Evaluate(method, url string) Result {
+	return re.EvaluateRequest(Request{Method: method, URL: url})
+}
+
+// EvaluateRequest evaluates a request and returns both result and matching rule
+func (re *Engine) EvaluateRequest(req Request) Result {
+	// The URL is parsed once per request, not once per rule. A URL that doesn't parse can't match any rule.
+	pr, err := parseRequest(req)
+	if err != nil {
+		return Result{
+			Allowed: false,
+			Rule:    "",
+		}
+	}
+
+	// Deny rules win over allow rules, so check them first.
+	if i, ok := re.firstMatch(re.denyIndex, re.denyRules, pr); ok {
+		rule := re.denyRules[i]
+		return Result{
+			Allowed:  false,
+			Rule:     rule.Raw,
+			RuleID:   rule.ID,
+			RuleTags: rule.Tags,
+		}
+	}
+
 	// Check if any allow rule matches
-	for _, rule := range re.rules {
-		if re.matches(rule, method, url) {
-			return Result{
-				Allowed: true,
-				Rule:    rule.Raw,
+	if i, ok := re.firstMatch(re.allowIndex, re.rules, pr); ok {
+		rule := re.rules[i]
+		result := Result{
+			Allowed:  true,
+			Rule:     rule.Raw,
+			RuleID:   rule.ID,
+			RuleTags: rule.Tags,
+			Limit:    rule.Limit,
+			LimitKey: re.limitKeys[i],
+		}
+		if rule.IPPatterns != nil {
+			if _, isLiteral := hostAddr(pr.host); !isLiteral {
+				result.Destination = req.Destination
 			}
 		}
+		return result
 	}
 
 	// Default deny if no allow rules match
 	return Result{
-		Allowed: false,
-		Rule:    "",
+		Allowed:     false,
+		Rule:        "",
+		ExpiredRule: re.firstExpired(pr),
+	}
+}
+
+// Connection describes a TLS connection before it is decrypted, which is all passthrough rules are evaluated
+// against.
+type Connection struct {
+	// ServerName is the server name the client sent in its ClientHello (SNI).
+	ServerName string
+	// Port is the port the client connected to. Zero means the default HTTPS port.
+	Port int
+	// Destination is the address the client originally connected to, when known. See Request.Destination.
+	Destination netip.Addr
+	// Time is when the connection is made, which decides whether rules have expired. The zero value means now.
+	Time time.Time
+}
+
+// HasPassthrough reports whether any rule allows TLS connections to be passed through, so that connections don't
+// need to be looked at before they are decrypted otherwise.
+func (re *Engine) HasPassthrough() bool {
+	return len(re.passthroughRules) > 0
+}
+
+// EvaluateConnection decides whether a TLS connection is passed through without being decrypted, which only
+// allow rules with inspect=false can allow. The requests of such a connection are never seen, so deny rules are
+// checked on the host, ip and port alone: a deny rule that could block any request to the server keeps the
+// connection from being passed through. A connection that isn't passed through isn't denied, it is decrypted and
+// its requests are evaluated as usual.
+func (re *Engine) EvaluateConnection(conn Connection) Result {
+	if len(re.passthroughRules) == 0 || conn.ServerName == "" {
+		return Result{Allowed: false}
+	}
+
+	// A server name is never an IP address (RFC 6066), so ip patterns are matched against the destination.
+	host := conn.ServerName
+	if conn.Port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(conn.Port))
+	}
+	pr, err := parseRequest(Request{URL: "https://" + host + "/", Destination: conn.Destination, Time: conn.Time})
+	if err != nil {
+		return Result{Allowed: false}
+	}
+
+	// Deny rules are few, and the index can't be used with the parts of them that are left out.
+	for _, rule := range re.denyRules {
+		if re.matchesParsed(connectionRule(rule), pr) {
+			return Result{
+				Allowed:  false,
+				Rule:     rule.Raw,
+				RuleID:   rule.ID,
+				RuleTags: rule.Tags,
+			}
+		}
+	}
+
+	if i, ok := re.firstMatch(re.passthroughIndex, re.passthroughRules, pr); ok {
+		rule := re.passthroughRules[i]
+		result := Result{
+			Allowed:  true,
+			Rule:     rule.Raw,
+			RuleID:   rule.ID,
+			RuleTags: rule.Tags,
+		}
+		if rule.IPPatterns != nil {
+			result.Destination = conn.Destination
+		}
+		return result
+	}
+
+	return Result{Allowed: false}
+}
+
+// connectionRule returns the part of a rule that can be checked without seeing requests: its host, ip and port
+// patterns and its time bounds.
+func connectionRule(r Rule) Rule {
+	r.MethodPatterns = nil
+	r.SchemePatterns = nil
+	r.PathPattern = nil
+	r.QueryPatterns = nil
+	r.HeaderPatterns = nil
+	return r
+}
+
+// firstMatch returns the index of the first rule that matches the request. Only the rules the index can't rule
+// out are checked, in order, so the result is the same as checking every rule in order.
+func (re *Engine) firstMatch(idx *ruleIndex, rules []Rule, pr *parsedRequest) (int, bool) {
+	for _, i := range idx.candidates(pr.labels, pr.segments) {
+		if re.matchesParsed(rules[i], pr) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// firstExpired returns the first allow rule that only failed to match the request because it expired. It only
+// runs for requests that are denied by default, so it doesn't slow down allowed requests.
+func (re *Engine) firstExpired(pr *parsedRequest) string {
+	if !re.hasUntil {
+		return ""
+	}
+	for _, i := range re.allowIndex.candidates(pr.labels, pr.segments) {
+		if m, _ := re.checkRule(re.rules[i], pr); m.Key == "until" {
+			return re.rules[i].Raw
+		}
+	}
+	return ""
+}
+
+// parsedRequest is a Request with its URL parsed and split up the way rules match against it.
+type parsedRequest struct {
+	Request
+	url       *neturl.URL
+	hasScheme bool
+	// The host in canonical form, see CanonicalHost.
+	host string
+	// The labels of the canonical host, i.e. ["api", "github", "com"].
+	labels []string
+	// The canonical path, escaped, and its segments without the leading empty segment.
+	path     string
+	segments []string
+	// The query parameters and the error parsing them, parsed on first use by query().
+	queryValues neturl.Values
+	queryErr    error
+}
+
+// query returns the parsed query parameters of the request. A malformed query string still yields the pairs
+// that could be parsed, along with the error; the pairs that couldn't, like `;`-separated ones, are left out.
+func (pr *parsedRequest) query() (neturl.Values, error) {
+	if pr.queryValues == nil {
+		pr.queryValues, pr.queryErr = neturl.ParseQuery(pr.url.RawQuery)
 	}
+	return pr.queryValues, pr.queryErr
 }
 
-// Matches checks if the rule matches the given method and URL using wildcard patterns
-func (re *Engine) matches(r Rule, method, url string) bool {
+func parseRequest(req Request) (*parsedRequest, error) {
+	parsedUrl, hasScheme, err := parseRequestURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rules match the canonical path, which is also what the proxy forwards. See CanonicalizePath.
+	path, err := CanonicalizePath(parsedUrl.EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+
+	host := CanonicalHost(parsedUrl.Hostname())
+
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+
+	return &parsedRequest{
+		Request:   req,
+		url:       parsedUrl,
+		hasScheme: hasScheme,
+		host:      host,
+		labels:    strings.Split(host, "."),
+		path:      path.Escaped,
+		segments:  path.Segments,
+	}, nil
+}
+
+// matches checks if the rule matches the given request using wildcard patterns. It checks the rule on its own,
+// without the index.
+func (re *Engine) matches(r Rule, req Request) bool {
+	pr, err := parseRequest(req)
+	if err != nil {
+		return false
+	}
+	return re.matchesParsed(r, pr)
+}
+
+func (re *Engine) matchesParsed(r Rule, pr *parsedRequest) bool {
+	_, matched := re.checkRule(r, pr)
+	return matched
+}
+
+// checkRule checks the rule against the request and, if it doesn't match, reports the first pattern that failed.
+// It runs for every candidate rule of every request, so building the Mismatch must not allocate.
+func (re *Engine) checkRule(r Rule, pr *parsedRequest) (Mismatch, bool) {
+	method := pr.Method
+	parsedUrl, hasScheme := pr.url, pr.hasScheme
 
 	// Check method patterns if they exist
 	if r.MethodPatterns != nil {
@@ -58,21 +401,33 @@ func (re *Engine) matches(r Rule, method, url string) bool {
 			}
 		}
 		if !methodMatches {
-			re.logger.Debug("rule does not match", "reason", "method pattern mismatch", "rule", r.Raw, "method", method, "url", url)
-			return false
+			return mismatch("method", "method pattern mismatch", method), false
 		}
 	}
 
-	// If the provided url doesn't have a scheme parsing will fail. This can happen when you do something like `curl google.com`
+	if r.SchemePatterns != nil {
+		if _, ok := r.SchemePatterns[strings.ToLower(parsedUrl.Scheme)]; !ok || !hasScheme {
+			return mismatch("scheme", "scheme pattern mismatch", parsedUrl.Scheme), false
+		}
+	}
 
-	if !strings.Contains(url, "://") {
-		// This is just for parsing, we won't use the scheme.
-		url = "https://" + url
+	if r.PortPatterns != nil {
+		port, ok := requestPort(parsedUrl, hasScheme)
+		if _, allowed := r.PortPatterns[port]; !ok || !allowed {
+			return mismatch("port", "port pattern mismatch", parsedUrl.Host), false
+		}
 	}
-	parsedUrl, err := neturl.Parse(url)
-	if err != nil {
-		re.logger.Debug("rule does not match", "reason", "invalid URL", "rule", r.Raw, "method", method, "url", url, "error", err)
-		return false
+
+	if r.IPPatterns != nil {
+		// An IP-literal host is where the request is forwarded, so that's the address to check. Otherwise fall
+		// back to the address the client originally connected to, if we know it.
+		addr, ok := hostAddr(pr.host)
+		if !ok {
+			addr = pr.Destination
+		}
+		if !ipPatternMatches(r.IPPatterns, addr) {
+			return mismatch("ip", "ip pattern mismatch", pr.host), false
+		}
 	}
 
 	if r.HostPattern != nil {
@@ -82,75 +437,272 @@ func (re *Engine) matches(r Rule, method, url string) bool {
 		// - "*.github.com" matches ONLY subdomains like "api.github.com" (not the base domain)
 		// - To allow both, specify: "github.com, *.github.com"
 
-		labels := strings.Split(parsedUrl.Hostname(), ".")
+		labels := pr.labels
 
 		// If the host pattern is longer than the actual host, it's definitely not a match
 		if len(r.HostPattern) > len(labels) {
-			re.logger.Debug("rule does not match", "reason", "host pattern too long", "rule", r.Raw, "method", method, "url", url, "pattern_length", len(r.HostPattern), "hostname_labels", len(labels))
-			return false
+			return mismatch("domain", "host pattern too long", pr.host), false
 		}
 
 		// Since host patterns cannot end with asterisk, we only need to handle:
 		// "example.com" or "*.example.com" - match from the end (allowing subdomains)
+		// Labels like "api-*" glob within their own label; a plain "*" matches any whole label.
 		for i, lp := range r.HostPattern {
 			labelIndex := len(labels) - len(r.HostPattern) + i
-			if string(lp) != labels[labelIndex] && lp != "*" {
-				re.logger.Debug("rule does not match", "reason", "host pattern label mismatch", "rule", r.Raw, "method", method, "url", url, "expected", string(lp), "actual", labels[labelIndex])
-				return false
+			if !matchWildcard(lp, labels[labelIndex]) {
+				m := mismatch("domain", "host pattern label mismatch", labels[labelIndex])
+				m.LabelIndex = i
+				m.Pattern = lp
+				return m, false
 			}
 		}
 
 		if len(labels) > len(r.HostPattern) && r.HostPattern[0] != "*" {
-			return false
+			return mismatch("domain", "subdomain of an exact host pattern", pr.host), false
 		}
 	}
 
 	if r.PathPattern != nil {
-		segments := strings.Split(parsedUrl.Path, "/")
-
-		// Skip the first empty segment if the path starts with "/"
-		if len(segments) > 0 && segments[0] == "" {
-			segments = segments[1:]
-		}
-
 		// Check if any of the path patterns match
 		pathMatches := false
 		for _, pattern := range r.PathPattern {
-			// If the path pattern is longer than the actual path, definitely not a match
-			if len(pattern) > len(segments) {
-				continue
+			if matchPathPattern(pattern, pr.segments) {
+				pathMatches = true
+				break
 			}
+		}
 
-			// Each segment in the pattern must be either as asterisk or match the actual path segment
-			patternMatches := true
-			for i, sp := range pattern {
-				if sp != segments[i] && sp != "*" {
-					patternMatches = false
-					break
-				}
-			}
+		if !pathMatches {
+			return mismatch("path", "no path pattern matches", pr.path), false
+		}
+	}
 
-			if !patternMatches {
+	if r.QueryPatterns != nil {
+		// Pairs of a malformed query can't be checked, so such a query matches deny rules and no allow rule.
+		query, err := pr.query()
+		for _, qp := range r.QueryPatterns {
+			if err != nil && r.Deny {
 				continue
 			}
-
-			// If the path is longer than the path pattern, it should only match if:
-			// 1. The pattern is empty (root path matches any path), OR
-			// 2. The final segment of the pattern is an asterisk
-			if len(segments) > len(pattern) && len(pattern) > 0 && pattern[len(pattern)-1] != "*" {
-				continue
+			if err != nil || !queryPatternMatches(qp, query[qp.Name], r.Deny) {
+				m := mismatch("query", "query pattern mismatch", parsedUrl.RawQuery)
+				m.Name = qp.Name
+				m.Pattern = qp.Value
+				return m, false
 			}
+		}
+	}
 
-			pathMatches = true
-			break
+	for _, hp := range r.HeaderPatterns {
+		if !headerPatternMatches(hp, pr.Header.Values(hp.Name), r.Deny) {
+			m := mismatch("header", "header pattern mismatch", pr.Header.Get(hp.Name))
+			m.Name = hp.Name
+			m.Pattern = hp.Value
+			return m, false
 		}
+	}
 
-		if !pathMatches {
-			re.logger.Debug("rule does not match", "reason", "no path pattern matches", "rule", r.Raw, "method", method, "url", url)
+	// Time bounds are checked last, so a mismatch on them means the rest of the rule matched. See ExpiredRule.
+	if !r.From.IsZero() && pr.Time.Before(r.From) {
+		return mismatch("from", "rule not active yet", ""), false
+	}
+	if !r.Until.IsZero() && !pr.Time.Before(r.Until) {
+		return mismatch("until", "rule expired", ""), false
+	}
+
+	return Mismatch{}, true
+}
+
+// parseRequestURL parses the request URL and reports whether it carried a scheme.
+func parseRequestURL(url string) (*neturl.URL, bool, error) {
+	// If the provided url doesn't have a scheme parsing will fail. This can happen when you do something like `curl google.com`
+	hasScheme := strings.Contains(url, "://")
+	if !hasScheme {
+		// This is just for parsing. The scheme stays unknown, so rules that constrain the scheme won't match.
+		url = "https://" + url
+	}
+	parsedUrl, err := neturl.Parse(url)
+	return parsedUrl, hasScheme, err
+}
+
+// CanonicalHost returns a request host in the form host patterns are stored in and matched against: lowercase, without the trailing
+// dot of a fully qualified name, and with internationalized labels in punycode. `GitHub.com.` and `github.com`
+// are the same host, and so are `bücher.de` and `xn--bcher-kva.de`. A host that isn't a valid IDN is only
+// lowercased, so its non-ASCII labels only match a `*` label.
+func CanonicalHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+
+	// Nearly every host is plain ASCII, which only needs lowercasing. ToLower doesn't allocate when there is
+	// nothing to change.
+	if isASCII(host) {
+		return strings.ToLower(host)
+	}
+
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+	return strings.ToLower(host)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
 			return false
 		}
 	}
-
-	re.logger.Debug("rule matches", "reason", "all patterns matched", "rule", r.Raw, "method", method, "url", url)
 	return true
 }
+
+// hostAddr returns the address of an IP-literal host. Hosts with an IPv6 zone are not treated as literals since
+// they can't be matched against a prefix.
+func hostAddr(host string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+// matchPathPattern reports whether the path segments match a single path pattern.
+// - An empty pattern (root path) matches any path.
+// - A `**` segment matches zero or more segments.
+// - A final `*` segment matches one or more remaining segments.
+// - Every other segment matches exactly one path segment, globbing on `*`.
+func matchPathPattern(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+
+	// `**` makes this a backtracking search. Memoizing on (pattern index, segment index) keeps it at
+	// O(len(pattern) * len(segments)) however many `**` the pattern has.
+	memo := make(map[[2]int]bool)
+	var match func(pi, si int) bool
+	match = func(pi, si int) bool {
+		if pi == len(pattern) {
+			return si == len(segments)
+		}
+
+		key := [2]int{pi, si}
+		if result, ok := memo[key]; ok {
+			return result
+		}
+
+		var result bool
+		switch sp := pattern[pi]; {
+		case sp == "**":
+			// Either `**` matches nothing more, or it swallows one more segment.
+			result = match(pi+1, si) || (si < len(segments) && match(pi, si+1))
+		case sp == "*" && pi == len(pattern)-1:
+			// A trailing `*` matches all remaining segments, but at least one.
+			result = si < len(segments)
+		default:
+			result = si < len(segments) && matchWildcard(sp, segments[si]) && match(pi+1, si+1)
+		}
+
+		memo[key] = result
+		return result
+	}
+
+	return match(0, 0)
+}
+
+// requestPort returns the destination port of the URL, falling back to the scheme's default port. It reports false
+// when the port can't be determined, e.g. for a URL without a port or a known scheme.
+func requestPort(u *neturl.URL, hasScheme bool) (int, bool) {
+	if p := u.Port(); p != "" {
+		port, err := strconv.Atoi(p)
+		return port, err == nil
+	}
+	if !hasScheme {
+		return 0, false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return 80, true
+	case "https":
+		return 443, true
+	default:
+		return 0, false
+	}
+}
+
+// ipPatternMatches reports whether the address falls within any of the prefixes. An invalid address (unknown
+// destination) never matches. IPv4-mapped IPv6 addresses are compared as IPv4.
+func ipPatternMatches(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPatternMatches reports whether the values of a query parameter satisfy the pattern. The parameter must be
+// present. For allow rules every value must match, and for deny rules any value matching is enough: otherwise a
+// request could smuggle a second value past the rule next to the one the rule is about.
+func queryPatternMatches(qp QueryPattern, values []string, deny bool) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, v := range values {
+		if matchWildcard(qp.Value, v) == deny {
+			return deny
+		}
+	}
+	return !deny
+}
+
+// headerPatternMatches reports whether the values of a header satisfy the pattern. As with query parameters, every
+// value of a repeated header must match for allow rules, and any value is enough for deny rules.
+func headerPatternMatches(hp HeaderPattern, values []string, deny bool) bool {
+	if hp.Absent {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if !hp.HasValue {
+		return true
+	}
+	for _, v := range values {
+		if matchWildcard(hp.Value, v) == deny {
+			return deny
+		}
+	}
+	return !deny
+}
+
+// matchWildcard reports whether s matches pattern, where each `*` in the pattern matches any run of characters
+// (including none) and every other byte must match exactly.
+func matchWildcard(pattern, s string) bool {
+	// Greedy matching with a single backtrack point: on mismatch, retry from the last `*` consuming one more
+	// byte of s. This is linear in practice and never exponential.
+	var p, i int
+	starP, starI := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starI = p, i
+			p++
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case starP >= 0:
+			starI++
+			p, i = starP+1, starI
+		default:
+			return false
+		}
+	}
+
+	// Only trailing stars can match the empty remainder.
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
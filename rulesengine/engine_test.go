@@ -307,10 +307,150 @@ func TestEngineMatches(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := engine.matches(tt.rule, tt.method, tt.url)
+			result := engine.matches(tt.rule, Request{Method: tt.method, URL: tt.url})
 			if result != tt.expected {
 				t.Errorf("expected %v, got %v", tt.expected, result)
 			}
 		})
 	}
 }
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"ours/*", "ours/boundary", true},
+		{"ours/*", "ours/", true},
+		{"ours/*", "theirs/boundary", false},
+		{"*.tar.gz", "archive.tar.gz", true},
+		{"*.tar.gz", "archive.tar.gz.sig", false},
+		{"v*-rc*", "v1.2-rc3", true},
+		{"v*-rc*", "v1.2", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"**", "x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"_"+tt.s, func(t *testing.T) {
+			if got := matchWildcard(tt.pattern, tt.s); got != tt.expected {
+				t.Errorf("matchWildcard(%q, %q): expected %v, got %v", tt.pattern, tt.s, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  []string
+		path     []string
+		expected bool
+	}{
+		{"empty pattern matches everything", []string{}, []string{"a", "b"}, true},
+		{"exact", []string{"api", "v1"}, []string{"api", "v1"}, true},
+		{"too short", []string{"api", "v1"}, []string{"api"}, false},
+		{"too long", []string{"api"}, []string{"api", "v1"}, false},
+		{"trailing star matches rest", []string{"api", "*"}, []string{"api", "v1", "users"}, true},
+		{"trailing star needs a segment", []string{"api", "*"}, []string{"api"}, false},
+		{"middle star matches one", []string{"api", "*", "users"}, []string{"api", "v1", "v2", "users"}, false},
+		{"double star matches zero", []string{"api", "**", "comments"}, []string{"api", "comments"}, true},
+		{"double star matches one", []string{"api", "**", "comments"}, []string{"api", "posts", "comments"}, true},
+		{"double star matches many", []string{"api", "**", "comments"}, []string{"api", "a", "b", "c", "comments"}, true},
+		{"double star needs suffix", []string{"api", "**", "comments"}, []string{"api", "a", "b", "c"}, false},
+		{"double star suffix must be last", []string{"api", "**", "comments"}, []string{"api", "comments", "1"}, false},
+		{"leading double star", []string{"**", "archive.tar.gz"}, []string{"org", "repo", "archive.tar.gz"}, true},
+		{"trailing double star matches nothing", []string{"api", "**"}, []string{"api"}, true},
+		{"trailing double star matches rest", []string{"api", "**"}, []string{"api", "x", "y"}, true},
+		{"double star then glob", []string{"org", "**", "*.tar.gz"}, []string{"org", "a", "b.tar.gz"}, true},
+		{"two double stars", []string{"**", "x", "**", "y"}, []string{"a", "x", "b", "x", "c", "y"}, true},
+		{"two double stars mismatch", []string{"**", "x", "**", "y"}, []string{"a", "x", "b", "y", "c"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchPathPattern(tt.pattern, tt.path); got != tt.expected {
+				t.Errorf("matchPathPattern(%q, %q): expected %v, got %v", tt.pattern, tt.path, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMatchPathPatternBacktracking(t *testing.T) {
+	// A pattern like /**/a/**/a/**/a/**/b against a long path of `a` segments is exponential with naive
+	// backtracking. It must finish quickly.
+	pattern := []string{}
+	for i := 0; i < 20; i++ {
+		pattern = append(pattern, "**", "a")
+	}
+	pattern = append(pattern, "b")
+
+	path := make([]string, 200)
+	for i := range path {
+		path[i] = "a"
+	}
+
+	if matchPathPattern(pattern, path) {
+		t.Errorf("expected no match")
+	}
+}
+
+func TestEngineHash(t *testing.T) {
+	parse := func(specs ...string) []Rule {
+		rules, err := ParseAllowSpecs(specs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return rules
+	}
+	hash := func(rules []Rule) string {
+		engine := NewRuleEngine(rules, slog.Default())
+		return engine.Hash()
+	}
+
+	base := hash(parse("domain=github.com", "method=GET domain=npmjs.org"))
+	if len(base) != 64 {
+		t.Fatalf("expected a hex encoded SHA-256 hash, got %q", base)
+	}
+	if got := hash(parse("domain=github.com", "method=GET domain=npmjs.org")); got != base {
+		t.Errorf("expected the same rules to hash the same, got %q and %q", base, got)
+	}
+
+	withID := parse("domain=github.com", "method=GET domain=npmjs.org")
+	withID[1].ID = "npm"
+	described := parse("domain=github.com", "method=GET domain=npmjs.org")
+	described[1].Description = "Package installs."
+	denied := parse("domain=github.com", "method=GET domain=npmjs.org")
+	denied[1].Deny = true
+	tagged := parse("domain=github.com", "method=GET domain=npmjs.org")
+	tagged[0].Tags = []string{"a", "b"}
+	oneTag := parse("domain=github.com", "method=GET domain=npmjs.org")
+	oneTag[0].Tags = []string{"a\x00b"}
+
+	for name, tt := range map[string]struct {
+		rules []Rule
+		same  bool
+	}{
+		"reordered":   {rules: parse("method=GET domain=npmjs.org", "domain=github.com")},
+		"rule added":  {rules: parse("domain=github.com", "method=GET domain=npmjs.org", "domain=pypi.org")},
+		"id":          {rules: withID},
+		"deny":        {rules: denied},
+		"tags":        {rules: tagged},
+		"description": {rules: described, same: true},
+	} {
+		if got := hash(tt.rules); (got == base) != tt.same {
+			t.Errorf("%s: expected same hash %v, got %q and %q", name, tt.same, base, got)
+		}
+	}
+	if hash(tagged) == hash(oneTag) {
+		t.Errorf("expected different tags to hash differently")
+	}
+}
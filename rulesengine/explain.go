@@ -0,0 +1,103 @@
+package rulesengine
+
+import (
+	"fmt"
+)
+
+// Mismatch describes why a rule did not match a request: the first pattern of the rule that failed.
+type Mismatch struct {
+	// Key is the rule key whose pattern failed, i.e. "method", "domain" or "path". It is "url" when the
+	// request URL could not be parsed, which no rule can match.
+	Key string
+	// Reason is a short description of the failure.
+	Reason string
+	// Actual is the part of the request that was checked: the method, the host label, the path, ...
+	Actual string
+	// LabelIndex is the index into the rule's host pattern of the label that failed, or -1 when the failure
+	// isn't about a single label.
+	LabelIndex int
+	// Name is the query parameter or header the failed pattern constrains.
+	Name string
+	// Pattern is the failed pattern, for keys where a single one can be singled out: a host label, a query
+	// or a header value.
+	Pattern string
+}
+
+func mismatch(key, reason, actual string) Mismatch {
+	return Mismatch{
+		Key:        key,
+		Reason:     reason,
+		Actual:     actual,
+		LabelIndex: -1,
+	}
+}
+
+func (m Mismatch) String() string {
+	switch {
+	case m.LabelIndex >= 0:
+		return fmt.Sprintf("%s: %s: label %d pattern %q does not match %q", m.Key, m.Reason, m.LabelIndex, m.Pattern, m.Actual)
+	case m.Name != "" && m.Pattern != "":
+		return fmt.Sprintf("%s: %s: %s pattern %q does not match %q", m.Key, m.Reason, m.Name, m.Pattern, m.Actual)
+	case m.Name != "":
+		return fmt.Sprintf("%s: %s: %s, got %q", m.Key, m.Reason, m.Name, m.Actual)
+	case m.Key == "from" || m.Key == "until":
+		return fmt.Sprintf("%s: %s", m.Key, m.Reason)
+	default:
+		return fmt.Sprintf("%s: %s, got %q", m.Key, m.Reason, m.Actual)
+	}
+}
+
+// RuleTrace is the outcome of checking a single rule against a request.
+type RuleTrace struct {
+	Rule string
+	// RuleID is the id of a structured rule, see Rule.ID.
+	RuleID  string
+	Deny    bool
+	Matched bool
+	// Mismatch is why the rule didn't match. It is the zero value when Matched is true.
+	Mismatch Mismatch
+}
+
+// Explanation describes how a request is evaluated.
+type Explanation struct {
+	// Result is the same result EvaluateRequest returns for the request.
+	Result Result
+	// Rules holds a trace for every rule in evaluation order: deny rules first, then allow rules. Unlike
+	// evaluation, checking doesn't stop at the first match. Passthrough rules never match requests and aren't
+	// listed.
+	Rules []RuleTrace
+}
+
+// Explain explains how a request given only its method and URL is evaluated. It is a shorthand for
+// ExplainRequest.
+func (re *Engine) Explain(method, url string) Explanation {
+	return re.ExplainRequest(Request{Method: method, URL: url})
+}
+
+// ExplainRequest checks the request against every rule and reports, for each of them, whether it matched
+// and, if not, why. It is meant for troubleshooting policies, not for the request path.
+func (re *Engine) ExplainRequest(req Request) Explanation {
+	explanation := Explanation{
+		Result: re.EvaluateRequest(req),
+	}
+
+	pr, err := parseRequest(req)
+	check := func(r Rule) RuleTrace {
+		trace := RuleTrace{Rule: r.Raw, RuleID: r.ID, Deny: r.Deny}
+		if err != nil {
+			trace.Mismatch = mismatch("url", "invalid URL", req.URL)
+			return trace
+		}
+		trace.Mismatch, trace.Matched = re.checkRule(r, pr)
+		return trace
+	}
+
+	for _, r := range re.denyRules {
+		explanation.Rules = append(explanation.Rules, check(r))
+	}
+	for _, r := range re.rules {
+		explanation.Rules = append(explanation.Rules, check(r))
+	}
+
+	return explanation
+}
@@ -0,0 +1,111 @@
+package rulesengine
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	allowRules, err := ParseAllowSpecs([]string{
+		"method=POST domain=api.github.com",
+		"domain=api-*.github.com",
+		"domain=github.com",
+		"domain=api.github.com path=/repos/*/issues,/user",
+		"domain=api.github.com query=per_page=100",
+		"domain=api.github.com header=Authorization",
+		"domain=api.github.com path=/repos/*",
+	})
+	require.NoError(t, err)
+	denyRules, err := ParseDenySpecs([]string{"domain=gist.github.com"})
+	require.NoError(t, err)
+
+	engine := NewRuleEngine(append(allowRules, denyRules...), slog.Default())
+	explanation := engine.Explain("GET", "https://api.github.com/repos/ours/pulls?per_page=10")
+
+	require.Equal(t, engine.Evaluate("GET", "https://api.github.com/repos/ours/pulls?per_page=10"), explanation.Result)
+	require.True(t, explanation.Result.Allowed)
+
+	expected := []RuleTrace{
+		{
+			Rule:     "domain=gist.github.com",
+			Deny:     true,
+			Mismatch: Mismatch{Key: "domain", Reason: "host pattern label mismatch", Actual: "api", LabelIndex: 0, Pattern: "gist"},
+		},
+		{
+			Rule:     "method=POST domain=api.github.com",
+			Mismatch: Mismatch{Key: "method", Reason: "method pattern mismatch", Actual: "GET", LabelIndex: -1},
+		},
+		{
+			Rule:     "domain=api-*.github.com",
+			Mismatch: Mismatch{Key: "domain", Reason: "host pattern label mismatch", Actual: "api", LabelIndex: 0, Pattern: "api-*"},
+		},
+		{
+			Rule:     "domain=github.com",
+			Mismatch: Mismatch{Key: "domain", Reason: "subdomain of an exact host pattern", Actual: "api.github.com", LabelIndex: -1},
+		},
+		{
+			Rule:     "domain=api.github.com path=/repos/*/issues,/user",
+			Mismatch: Mismatch{Key: "path", Reason: "no path pattern matches", Actual: "/repos/ours/pulls", LabelIndex: -1},
+		},
+		{
+			Rule:     "domain=api.github.com query=per_page=100",
+			Mismatch: Mismatch{Key: "query", Reason: "query pattern mismatch", Actual: "per_page=10", LabelIndex: -1, Name: "per_page", Pattern: "100"},
+		},
+		{
+			Rule:     "domain=api.github.com header=Authorization",
+			Mismatch: Mismatch{Key: "header", Reason: "header pattern mismatch", LabelIndex: -1, Name: "Authorization"},
+		},
+		{
+			Rule:    "domain=api.github.com path=/repos/*",
+			Matched: true,
+		},
+	}
+	require.Equal(t, expected, explanation.Rules)
+}
+
+func TestExplainInvalidURL(t *testing.T) {
+	rules, err := ParseAllowSpecs([]string{"domain=github.com", "method=GET"})
+	require.NoError(t, err)
+
+	engine := NewRuleEngine(rules, slog.Default())
+	explanation := engine.Explain("GET", "https://%zz")
+
+	require.False(t, explanation.Result.Allowed)
+	require.Len(t, explanation.Rules, 2)
+	for _, trace := range explanation.Rules {
+		require.False(t, trace.Matched)
+		require.Equal(t, "url", trace.Mismatch.Key)
+	}
+}
+
+func TestMismatchString(t *testing.T) {
+	tests := []struct {
+		mismatch Mismatch
+		expected string
+	}{
+		{
+			mismatch: Mismatch{Key: "method", Reason: "method pattern mismatch", Actual: "GET", LabelIndex: -1},
+			expected: `method: method pattern mismatch, got "GET"`,
+		},
+		{
+			mismatch: Mismatch{Key: "domain", Reason: "host pattern label mismatch", Actual: "api", LabelIndex: 0, Pattern: "gist"},
+			expected: `domain: host pattern label mismatch: label 0 pattern "gist" does not match "api"`,
+		},
+		{
+			mismatch: Mismatch{Key: "query", Reason: "query pattern mismatch", Actual: "per_page=10", LabelIndex: -1, Name: "per_page", Pattern: "100"},
+			expected: `query: query pattern mismatch: per_page pattern "100" does not match "per_page=10"`,
+		},
+		{
+			mismatch: Mismatch{Key: "header", Reason: "header pattern mismatch", LabelIndex: -1, Name: "Authorization"},
+			expected: `header: header pattern mismatch: Authorization, got ""`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.mismatch.String())
+		})
+	}
+}
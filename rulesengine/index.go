@@ -0,0 +1,273 @@
+package rulesengine
+
+import (
+	"slices"
+	"strings"
+)
+
+// ruleIndex narrows a request down to the rules that could match it, so that evaluation doesn't have to check
+// every rule of a large policy. Hosts are indexed in a trie over their labels in reverse order (`com`, `github`,
+// `api`), and every host node holds a trie over the path segments of the rules ending there.
+//
+// The index only ever over-approximates: every rule it returns is still checked in full with matchesParsed, so
+// method, scheme, port, ip, query and header patterns don't need to be indexed, and globs only have to be
+// handled conservatively.
+type ruleIndex struct {
+	root *hostNode
+	// Rules without a host pattern.
+	anyHost *pathIndex
+}
+
+// hostNode is a node of the reversed-label host trie. Reaching a node means the labels consumed so far match.
+type hostNode struct {
+	children map[string]*hostNode
+	// Labels containing an `*`, which can't be looked up in children.
+	globs []hostEdge
+	// Rules whose host pattern ends at this node. They match when every label of the host has been consumed.
+	exact *pathIndex
+	// Rules whose host pattern ends at this node with a leading `*` label, which matches one or more labels.
+	// They match whatever labels are left.
+	open *pathIndex
+}
+
+type hostEdge struct {
+	pattern string
+	node    *hostNode
+}
+
+// pathIndex holds the rules of a single host node.
+type pathIndex struct {
+	root *pathNode
+	// Rules without a path pattern, or with an empty (root) pattern, which matches every path.
+	anyPath []int
+	// Whether any pattern contains `**`.
+	recursive bool
+}
+
+// pathNode is a node of a path segment trie. Reaching a node means the segments consumed so far match.
+type pathNode struct {
+	children map[string]*pathNode
+	// Segments containing an `*`, which can't be looked up in children.
+	globs []pathEdge
+	// The node after a `**` segment.
+	recursive *pathNode
+	// Rules whose path pattern ends at this node. They match when every segment has been consumed.
+	terminal []int
+	// Rules whose path pattern ends at this node with a trailing `*`, which matches one or more segments.
+	trailingStar []int
+}
+
+type pathEdge struct {
+	pattern string
+	node    *pathNode
+}
+
+func newRuleIndex(rules []Rule) *ruleIndex {
+	idx := &ruleIndex{
+		root:    &hostNode{},
+		anyHost: &pathIndex{root: &pathNode{}},
+	}
+
+	for i, r := range rules {
+		pi := idx.anyHost
+		if r.HostPattern != nil {
+			pi = idx.root.insert(r.HostPattern)
+		}
+		pi.insert(i, r.PathPattern)
+	}
+
+	return idx
+}
+
+// insert adds the nodes for a host pattern and returns the path index of the node it ends at.
+func (n *hostNode) insert(pattern []string) *pathIndex {
+	node := n
+	for i := len(pattern) - 1; i >= 1; i-- {
+		node = node.child(pattern[i])
+	}
+
+	if len(pattern) > 0 && pattern[0] == "*" {
+		// A leading `*` isn't a node of its own: it has to match at least one label, and then any number more.
+		node = node.child("*")
+		if node.open == nil {
+			node.open = &pathIndex{root: &pathNode{}}
+		}
+		return node.open
+	}
+
+	if len(pattern) > 0 {
+		node = node.child(pattern[0])
+	}
+	if node.exact == nil {
+		node.exact = &pathIndex{root: &pathNode{}}
+	}
+	return node.exact
+}
+
+func (n *hostNode) child(label string) *hostNode {
+	if strings.Contains(label, "*") {
+		for _, edge := range n.globs {
+			if edge.pattern == label {
+				return edge.node
+			}
+		}
+		child := &hostNode{}
+		n.globs = append(n.globs, hostEdge{pattern: label, node: child})
+		return child
+	}
+
+	if n.children == nil {
+		n.children = make(map[string]*hostNode)
+	}
+	child, ok := n.children[label]
+	if !ok {
+		child = &hostNode{}
+		n.children[label] = child
+	}
+	return child
+}
+
+func (pi *pathIndex) insert(rule int, patterns [][]string) {
+	if patterns == nil {
+		pi.anyPath = append(pi.anyPath, rule)
+		return
+	}
+
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			pi.anyPath = append(pi.anyPath, rule)
+			continue
+		}
+
+		node := pi.root
+		last := len(pattern) - 1
+		for _, segment := range pattern[:last] {
+			node = node.next(segment)
+		}
+		pi.recursive = pi.recursive || slices.Contains(pattern, "**")
+
+		if pattern[last] == "*" {
+			node.trailingStar = append(node.trailingStar, rule)
+			continue
+		}
+		node = node.next(pattern[last])
+		node.terminal = append(node.terminal, rule)
+	}
+}
+
+func (n *pathNode) next(segment string) *pathNode {
+	if segment == "**" {
+		if n.recursive == nil {
+			n.recursive = &pathNode{}
+		}
+		return n.recursive
+	}
+	return n.child(segment)
+}
+
+func (n *pathNode) child(segment string) *pathNode {
+	if strings.Contains(segment, "*") {
+		for _, edge := range n.globs {
+			if edge.pattern == segment {
+				return edge.node
+			}
+		}
+		child := &pathNode{}
+		n.globs = append(n.globs, pathEdge{pattern: segment, node: child})
+		return child
+	}
+
+	if n.children == nil {
+		n.children = make(map[string]*pathNode)
+	}
+	child, ok := n.children[segment]
+	if !ok {
+		child = &pathNode{}
+		n.children[segment] = child
+	}
+	return child
+}
+
+// candidates returns the indices of the rules whose host and path patterns may match, in rule order and
+// without duplicates.
+func (idx *ruleIndex) candidates(labels, segments []string) []int {
+	var out []int
+	idx.anyHost.collect(segments, &out)
+	idx.root.collect(labels, len(labels), segments, &out)
+
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// collect walks the host trie. remaining is the number of labels not consumed yet; they are consumed from the end.
+func (n *hostNode) collect(labels []string, remaining int, segments []string, out *[]int) {
+	if n.open != nil {
+		n.open.collect(segments, out)
+	}
+	if remaining == 0 {
+		if n.exact != nil {
+			n.exact.collect(segments, out)
+		}
+		return
+	}
+
+	label := labels[remaining-1]
+	if child, ok := n.children[label]; ok {
+		child.collect(labels, remaining-1, segments, out)
+	}
+	for _, edge := range n.globs {
+		if matchWildcard(edge.pattern, label) {
+			edge.node.collect(labels, remaining-1, segments, out)
+		}
+	}
+}
+
+func (pi *pathIndex) collect(segments []string, out *[]int) {
+	*out = append(*out, pi.anyPath...)
+
+	// `**` lets the walk reach the same node at the same segment along several routes. Remembering where we've
+	// been keeps it polynomial. Without `**` every node is reached at most once.
+	type state struct {
+		node *pathNode
+		si   int
+	}
+	var seen map[state]struct{}
+	if pi.recursive {
+		seen = make(map[state]struct{})
+	}
+
+	var walk func(n *pathNode, si int)
+	walk = func(n *pathNode, si int) {
+		if seen != nil {
+			if _, ok := seen[state{n, si}]; ok {
+				return
+			}
+			seen[state{n, si}] = struct{}{}
+		}
+
+		if si == len(segments) {
+			*out = append(*out, n.terminal...)
+		} else {
+			*out = append(*out, n.trailingStar...)
+		}
+
+		if n.recursive != nil {
+			for k := si; k <= len(segments); k++ {
+				walk(n.recursive, k)
+			}
+		}
+
+		if si == len(segments) {
+			return
+		}
+		if child, ok := n.children[segments[si]]; ok {
+			walk(child, si+1)
+		}
+		for _, edge := range n.globs {
+			if matchWildcard(edge.pattern, segments[si]) {
+				walk(edge.node, si+1)
+			}
+		}
+	}
+	walk(pi.root, 0)
+}
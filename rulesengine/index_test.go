@@ -0,0 +1,184 @@
+package rulesengine
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// evaluateLinear is the reference implementation the index has to agree with: every rule, checked in order.
+func evaluateLinear(re *Engine, req Request) Result {
+	for _, rule := range re.denyRules {
+		if re.matches(rule, req) {
+			return Result{Allowed: false, Rule: rule.Raw}
+		}
+	}
+	for _, rule := range re.rules {
+		if re.matches(rule, req) {
+			return Result{Allowed: true, Rule: rule.Raw}
+		}
+	}
+	return Result{Allowed: false}
+}
+
+func TestIndexMatchesLinearEvaluation(t *testing.T) {
+	specs := []string{
+		"domain=github.com",
+		"domain=*.github.com",
+		"domain=github.com path=/api/*",
+		"domain=api.github.com path=/repos/*/issues",
+		"domain=api.github.com path=/repos/ours/*",
+		"domain=api-*.example.com",
+		"domain=*.api-*.example.com path=/v*",
+		"domain=*.com",
+		"domain=*.*.com path=/x/*",
+		"domain=example.com path=/",
+		"domain=example.com path=/a/**/z,/b",
+		"domain=example.com path=/**",
+		"domain=example.com path=/**/*.tar.gz",
+		"method=POST domain=example.com path=/upload",
+		"path=/health",
+		"path=/api/v1/*,/api/v2/*",
+		"path=/**/comments",
+		"method=GET",
+		"domain=10.20.0.5",
+		"ip=10.0.0.0/8",
+		"domain=example.com query=q=*",
+		"domain=localhost",
+	}
+	denySpecs := []string{
+		"domain=gist.github.com",
+		"path=/admin/**",
+		"method=DELETE domain=*.example.com",
+	}
+
+	urls := []string{
+		"https://github.com",
+		"https://github.com/",
+		"https://github.com/api",
+		"https://github.com/api/x/y",
+		"https://api.github.com/repos/ours/issues",
+		"https://api.github.com/repos/theirs/issues",
+		"https://api.github.com/repos/ours/x/y",
+		"https://gist.github.com/x",
+		"https://v1.api.github.com/",
+		"https://api-eu.example.com/",
+		"https://x.api-eu.example.com/v2",
+		"https://x.api-eu.example.com/w2",
+		"https://a.b.com/x/y",
+		"https://b.com/x/y",
+		"https://example.com/",
+		"https://example.com/a/z",
+		"https://example.com/a/b/c/z",
+		"https://example.com/a/b/c",
+		"https://example.com/b",
+		"https://example.com/b/c",
+		"https://example.com/x/y/pkg.tar.gz",
+		"https://example.com/upload",
+		"https://example.com/admin/users",
+		"https://example.com/search?q=boundary",
+		"https://example.com/search?other=1",
+		"https://other.org/health",
+		"https://other.org/api/v1/users",
+		"https://other.org/api/v3/users",
+		"https://other.org/x/y/comments",
+		"http://10.20.0.5:5000/v2/",
+		"http://10.99.0.1/",
+		"http://localhost:8080/",
+		"com",
+		"example.com/upload",
+		"https://%zz",
+		"https://",
+	}
+	methods := []string{"GET", "POST", "DELETE"}
+
+	allowRules, err := ParseAllowSpecs(specs)
+	require.NoError(t, err)
+	denyRules, err := ParseDenySpecs(denySpecs)
+	require.NoError(t, err)
+
+	// Check the full policy, and random subsets in random order so first-match semantics get exercised.
+	rng := rand.New(rand.NewSource(1))
+	policies := [][]Rule{append(append([]Rule{}, allowRules...), denyRules...)}
+	for i := 0; i < 50; i++ {
+		all := append(append([]Rule{}, allowRules...), denyRules...)
+		rng.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		policies = append(policies, all[:rng.Intn(len(all)+1)])
+	}
+
+	for _, rules := range policies {
+		engine := NewRuleEngine(rules, slog.Default())
+		for _, url := range urls {
+			for _, method := range methods {
+				req := Request{Method: method, URL: url}
+				want := evaluateLinear(&engine, req)
+				got := engine.EvaluateRequest(req)
+				require.Equal(t, want.Allowed, got.Allowed, "%s %s", method, url)
+				require.Equal(t, want.Rule, got.Rule, "%s %s", method, url)
+			}
+		}
+	}
+}
+
+// largePolicy generates a policy the size of the ones produced from package mirrors and per-repo rules.
+func largePolicy(n int) []string {
+	specs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			specs = append(specs, fmt.Sprintf("domain=mirror-%d.packages.example.com", i))
+		case 1:
+			specs = append(specs, fmt.Sprintf("method=GET,HEAD domain=api.github.com path=/repos/org-%d/*", i))
+		case 2:
+			specs = append(specs, fmt.Sprintf("domain=*.cdn-%d.example.net path=/assets/**/*.js", i))
+		case 3:
+			specs = append(specs, fmt.Sprintf("domain=registry-%d.example.org path=/v2/**", i))
+		}
+	}
+	return specs
+}
+
+func benchmarkEngine(b *testing.B, n int) Engine {
+	rules, err := ParseAllowSpecs(largePolicy(n))
+	require.NoError(b, err)
+	return NewRuleEngine(rules, slog.New(slog.DiscardHandler))
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	for _, n := range []int{10, 1000, 5000} {
+		engine := benchmarkEngine(b, n)
+		last := fmt.Sprintf("https://api.github.com/repos/org-%d/boundary/issues", (n/4-1)*4+1)
+		miss := "https://unknown.example.com/" + strings.Repeat("a/", 8)
+
+		b.Run(fmt.Sprintf("rules=%d/match", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				engine.Evaluate("GET", last)
+			}
+		})
+		b.Run(fmt.Sprintf("rules=%d/miss", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				engine.Evaluate("GET", miss)
+			}
+		})
+	}
+}
+
+func BenchmarkEvaluateLinear(b *testing.B) {
+	for _, n := range []int{10, 1000, 5000} {
+		engine := benchmarkEngine(b, n)
+		last := fmt.Sprintf("https://api.github.com/repos/org-%d/boundary/issues", (n/4-1)*4+1)
+
+		b.Run(fmt.Sprintf("rules=%d/match", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				evaluateLinear(&engine, Request{Method: "GET", URL: last})
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package rulesengine
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Severity ranks lint findings.
+type Severity int
+
+const (
+	// SeverityInfo marks rules that are fine but might not do what was intended.
+	SeverityInfo Severity = iota
+	// SeverityWarning marks rules that are useless or broader than they look.
+	SeverityWarning
+	// SeverityError marks rules that defeat the purpose of running boundary at all.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Finding is a single problem Lint found in a policy.
+type Finding struct {
+	Severity Severity
+	// Check is the name of the pass that produced the finding, i.e. "shadowed".
+	Check string
+	// Rule is the rule the finding is about.
+	Rule string
+	// Message explains the problem.
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s", f.Severity, f.Check, f.Rule, f.Message)
+}
+
+// HasProblems reports whether any finding is a warning or an error.
+func HasProblems(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity >= SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// lintPass inspects a whole policy. Passes get the rules in the order they were configured, allow and deny rules
+// mixed, since some checks compare rules with each other.
+type lintPass func(rules []Rule) []Finding
+
+// Lint runs every analysis pass over a policy and returns the findings, most severe first. Rules are expected in
+// the order they are configured, as returned by ParseAllowSpecs and ParseDenySpecs.
+func Lint(rules []Rule) []Finding {
+	passes := []lintPass{
+		lintMatchesEverything,
+		lintAnyHost,
+		lintBroadHost,
+		lintCovered,
+		lintApexOnly,
+		lintTimeBounds,
+	}
+
+	var findings []Finding
+	for _, pass := range passes {
+		findings = append(findings, pass(rules)...)
+	}
+
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return int(b.Severity) - int(a.Severity)
+	})
+	return findings
+}
+
+// lintMatchesEverything flags rules without any constraint, like `domain=*`. An allow rule like that turns
+// boundary off; a deny rule like that makes every allow rule pointless.
+func lintMatchesEverything(rules []Rule) []Finding {
+	var findings []Finding
+	for _, r := range rules {
+		if !matchesEverything(r) {
+			continue
+		}
+		if r.Deny {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "matches-everything",
+				Rule:     r.Raw,
+				Message:  "denies every request, so no allow rule can take effect",
+			})
+		} else {
+			findings = append(findings, Finding{
+				Severity: SeverityError,
+				Check:    "matches-everything",
+				Rule:     r.Raw,
+				Message:  "allows every request to every host",
+			})
+		}
+	}
+	return findings
+}
+
+// lintAnyHost flags allow rules that constrain the request but not where it goes, like `method=GET`.
+func lintAnyHost(rules []Rule) []Finding {
+	var findings []Finding
+	for _, r := range rules {
+		if r.Deny || matchesEverything(r) || !anyHost(r) {
+			continue
+		}
+		findings = append(findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "any-host",
+			Rule:     r.Raw,
+			Message:  "has no domain or ip constraint, so it allows matching requests to every host",
+		})
+	}
+	return findings
+}
+
+// lintBroadHost flags host patterns where nothing but the top level domain is literal, like `*.com`.
+func lintBroadHost(rules []Rule) []Finding {
+	var findings []Finding
+	for _, r := range rules {
+		if r.Deny || len(r.HostPattern) < 2 {
+			continue
+		}
+		broad := true
+		for _, label := range r.HostPattern[:len(r.HostPattern)-1] {
+			if !strings.Contains(label, "*") {
+				broad = false
+				break
+			}
+		}
+		if broad {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "broad-domain",
+				Rule:     r.Raw,
+				Message:  fmt.Sprintf("allows every domain under the top level domain %q", r.HostPattern[len(r.HostPattern)-1]),
+			})
+		}
+	}
+	return findings
+}
+
+// lintCovered flags rules that can never decide a request: allow rules whose requests are all denied by a deny
+// rule, and rules whose requests all match an earlier rule of the same kind.
+func lintCovered(rules []Rule) []Finding {
+	idx := newCoverIndex(rules)
+
+	var findings []Finding
+	for j, r := range rules {
+		candidates := idx.candidates(r)
+
+		if !r.Deny {
+			if i := slices.IndexFunc(candidates, func(i int) bool { return rules[i].Deny && covers(rules[i], r) }); i >= 0 {
+				message := fmt.Sprintf("never allows anything: every request it matches is denied by %q", rules[candidates[i]].Raw)
+				if r.Passthrough {
+					message = fmt.Sprintf("never passes anything through: requests to every server it matches can be denied by %q", rules[candidates[i]].Raw)
+				}
+				findings = append(findings, Finding{
+					Severity: SeverityWarning,
+					Check:    "shadowed",
+					Rule:     r.Raw,
+					Message:  message,
+				})
+				continue
+			}
+		}
+
+		for _, i := range candidates {
+			if i >= j {
+				break
+			}
+			if rules[i].Deny == r.Deny && covers(rules[i], r) {
+				findings = append(findings, Finding{
+					Severity: SeverityWarning,
+					Check:    "redundant",
+					Rule:     r.Raw,
+					Message:  fmt.Sprintf("every request it matches already matches the earlier rule %q", rules[i].Raw),
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// coverIndex groups rules by the literal labels their host pattern ends with. A rule can only cover another if
+// those labels are also literal at the end of the other's host pattern, so lintCovered only has to compare rules
+// sharing a suffix instead of every pair, which is too slow for policies with thousands of rules.
+type coverIndex map[string][]int
+
+func newCoverIndex(rules []Rule) coverIndex {
+	idx := make(coverIndex)
+	for i, r := range rules {
+		key := strings.Join(literalSuffix(r.HostPattern), ".")
+		idx[key] = append(idx[key], i)
+	}
+	return idx
+}
+
+// candidates returns the indices of the rules that may cover r, in rule order.
+func (idx coverIndex) candidates(r Rule) []int {
+	suffix := literalSuffix(r.HostPattern)
+
+	var out []int
+	for k := 0; k <= len(suffix); k++ {
+		out = append(out, idx[strings.Join(suffix[len(suffix)-k:], ".")]...)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// literalSuffix returns the labels at the end of a host pattern that contain no `*`.
+func literalSuffix(pattern []string) []string {
+	i := len(pattern)
+	for i > 0 && !strings.Contains(pattern[i-1], "*") {
+		i--
+	}
+	return pattern[i:]
+}
+
+// lintApexOnly flags allow rules for a bare domain like `domain=github.com` when no rule allows its subdomains.
+// These are often meant to cover `api.github.com` as well, which they don't.
+func lintApexOnly(rules []Rule) []Finding {
+	var findings []Finding
+	for _, r := range rules {
+		// Presets are curated, so an apex-only rule in one is deliberate, and so is a passthrough rule for the one
+		// server a client pins.
+		if r.Deny || r.Preset != "" || r.Passthrough || len(r.HostPattern) != 2 || strings.Contains(r.HostPattern[0], "*") {
+			continue
+		}
+
+		subdomains := []string{"*", r.HostPattern[0], r.HostPattern[1]}
+		if slices.ContainsFunc(rules, func(o Rule) bool { return !o.Deny && !o.Passthrough && slices.Equal(o.HostPattern, subdomains) }) {
+			continue
+		}
+
+		domain := strings.Join(r.HostPattern, ".")
+		findings = append(findings, Finding{
+			Severity: SeverityInfo,
+			Check:    "apex-only",
+			Rule:     r.Raw,
+			Message:  fmt.Sprintf("matches only %s itself, not subdomains; add domain=*.%s if they are intended", domain, domain),
+		})
+	}
+	return findings
+}
+
+// lintTimeBounds flags rules that have expired, which are dead policy, and rules that aren't active yet.
+func lintTimeBounds(rules []Rule) []Finding {
+	now := time.Now()
+
+	var findings []Finding
+	for _, r := range rules {
+		switch {
+		case !r.Until.IsZero() && !now.Before(r.Until):
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "expired",
+				Rule:     r.Raw,
+				Message:  fmt.Sprintf("expired at %s and no longer matches any request", r.Until.Format(time.RFC3339)),
+			})
+		case !r.From.IsZero() && now.Before(r.From):
+			findings = append(findings, Finding{
+				Severity: SeverityInfo,
+				Check:    "not-active-yet",
+				Rule:     r.Raw,
+				Message:  fmt.Sprintf("matches no request until %s", r.From.Format(time.RFC3339)),
+			})
+		}
+	}
+	return findings
+}
+
+func anyHost(r Rule) bool {
+	return r.IPPatterns == nil && (r.HostPattern == nil || slices.Equal(r.HostPattern, []string{"*"}))
+}
+
+func matchesEverything(r Rule) bool {
+	return anyHost(r) &&
+		anyMethod(r.MethodPatterns) &&
+		r.SchemePatterns == nil &&
+		r.PortPatterns == nil &&
+		anyPath(r.PathPattern) &&
+		r.QueryPatterns == nil &&
+		r.HeaderPatterns == nil
+}
+
+func anyMethod(methods map[string]struct{}) bool {
+	if methods == nil {
+		return true
+	}
+	_, ok := methods["*"]
+	return ok
+}
+
+func anyPath(patterns [][]string) bool {
+	if patterns == nil {
+		return true
+	}
+	for _, pattern := range patterns {
+		if len(pattern) == 0 || slices.Equal(pattern, []string{"**"}) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether every request matching b also matches a. It is conservative: when it can't tell, it
+// reports false, so lint never claims a rule is useless when it isn't.
+func covers(a, b Rule) bool {
+	// Passthrough rules match connections, not requests. A deny rule keeps a connection from being passed through
+	// if any part of it but the requests matches, see Engine.EvaluateConnection.
+	if a.Passthrough != b.Passthrough {
+		if !a.Deny || !b.Passthrough {
+			return false
+		}
+		a = connectionRule(a)
+	}
+
+	// a must be in effect whenever b is.
+	if !a.From.IsZero() && (b.From.IsZero() || b.From.Before(a.From)) {
+		return false
+	}
+	if !a.Until.IsZero() && (b.Until.IsZero() || b.Until.After(a.Until)) {
+		return false
+	}
+
+	if !anyMethod(a.MethodPatterns) {
+		if anyMethod(b.MethodPatterns) {
+			return false
+		}
+		for m := range b.MethodPatterns {
+			if _, ok := a.MethodPatterns[m]; !ok {
+				return false
+			}
+		}
+	}
+
+	if !setCovers(a.SchemePatterns, b.SchemePatterns) || !setCovers(a.PortPatterns, b.PortPatterns) {
+		return false
+	}
+
+	if a.IPPatterns != nil {
+		if b.IPPatterns == nil {
+			return false
+		}
+		for _, pb := range b.IPPatterns {
+			if !slices.ContainsFunc(a.IPPatterns, func(pa netip.Prefix) bool {
+				return pa.Bits() <= pb.Bits() && pa.Contains(pb.Addr())
+			}) {
+				return false
+			}
+		}
+	}
+
+	if a.HostPattern != nil && !slices.Equal(a.HostPattern, []string{"*"}) {
+		if b.HostPattern == nil || !hostCovers(a.HostPattern, b.HostPattern) {
+			return false
+		}
+	}
+
+	if !anyPath(a.PathPattern) {
+		if b.PathPattern == nil {
+			return false
+		}
+		for _, pb := range b.PathPattern {
+			if !slices.ContainsFunc(a.PathPattern, func(pa []string) bool { return pathCovers(pa, pb) }) {
+				return false
+			}
+		}
+	}
+
+	for _, qa := range a.QueryPatterns {
+		if !slices.ContainsFunc(b.QueryPatterns, func(qb QueryPattern) bool {
+			return qa.Name == qb.Name && globCovers(qa.Value, qb.Value)
+		}) {
+			return false
+		}
+	}
+
+	for _, ha := range a.HeaderPatterns {
+		if !slices.ContainsFunc(b.HeaderPatterns, func(hb HeaderPattern) bool {
+			if ha.Name != hb.Name || ha.Absent != hb.Absent {
+				return false
+			}
+			return !ha.HasValue || (hb.HasValue && globCovers(ha.Value, hb.Value))
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func setCovers[T comparable](a, b map[T]struct{}) bool {
+	if a == nil {
+		return true
+	}
+	if b == nil {
+		return false
+	}
+	for v := range b {
+		if _, ok := a[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hostCovers reports whether every host matching pattern b matches pattern a. A leading `*` label matches one or
+// more labels, so only then can a pattern match hosts longer than itself.
+func hostCovers(a, b []string) bool {
+	aOpen, bOpen := a[0] == "*", b[0] == "*"
+	if bOpen && !aOpen {
+		return false
+	}
+	if len(a) > len(b) || (!aOpen && len(a) != len(b)) {
+		return false
+	}
+
+	// Compare the labels aligned from the end.
+	for i := 1; i <= len(a); i++ {
+		if !globCovers(a[len(a)-i], b[len(b)-i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathCovers reports whether every path matching pattern b matches pattern a.
+func pathCovers(a, b []string) bool {
+	if slices.Equal(a, b) {
+		return true
+	}
+
+	// A pattern without globs matches exactly one path.
+	if !slices.ContainsFunc(b, func(s string) bool { return strings.Contains(s, "*") }) {
+		return matchPathPattern(a, b)
+	}
+
+	last := len(a) - 1
+	if last < 0 || (a[last] != "*" && a[last] != "**") || len(b) < last {
+		return false
+	}
+	for i := 0; i < last; i++ {
+		if a[i] == "**" || b[i] == "**" || !globCovers(a[i], b[i]) {
+			return false
+		}
+	}
+	if a[last] == "**" {
+		return true
+	}
+	// A trailing `*` needs at least one more segment, which b has to guarantee.
+	return len(b) > last && b[last] != "**"
+}
+
+// globCovers reports whether every string matching glob b matches glob a.
+func globCovers(a, b string) bool {
+	if a == "*" || a == b {
+		return true
+	}
+	return !strings.Contains(b, "*") && matchWildcard(a, b)
+}
@@ -0,0 +1,277 @@
+package rulesengine
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lintSpecs(t *testing.T, allowSpecs, denySpecs []string) []Finding {
+	t.Helper()
+	allowRules, err := ParseAllowSpecs(allowSpecs)
+	require.NoError(t, err)
+	denyRules, err := ParseDenySpecs(denySpecs)
+	require.NoError(t, err)
+	return Lint(append(allowRules, denyRules...))
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    []string
+		deny     []string
+		expected []Finding
+	}{
+		{
+			name:  "clean policy",
+			allow: []string{"domain=github.com", "domain=*.github.com", "method=GET domain=*.pypi.org path=/simple/**"},
+			deny:  []string{"domain=gist.github.com"},
+		},
+		{
+			name:  "allow everything",
+			allow: []string{"domain=*"},
+			expected: []Finding{
+				{Severity: SeverityError, Check: "matches-everything", Rule: "domain=*", Message: "allows every request to every host"},
+			},
+		},
+		{
+			name:  "allow everything with a method wildcard and root path",
+			allow: []string{"method=* path=/"},
+			expected: []Finding{
+				{Severity: SeverityError, Check: "matches-everything", Rule: "method=* path=/", Message: "allows every request to every host"},
+			},
+		},
+		{
+			name:  "deny everything",
+			allow: []string{"domain=example.com", "domain=*.example.com"},
+			deny:  []string{"path=/**"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "matches-everything", Rule: "path=/**", Message: "denies every request, so no allow rule can take effect"},
+				{Severity: SeverityWarning, Check: "shadowed", Rule: "domain=example.com", Message: `never allows anything: every request it matches is denied by "path=/**"`},
+				{Severity: SeverityWarning, Check: "shadowed", Rule: "domain=*.example.com", Message: `never allows anything: every request it matches is denied by "path=/**"`},
+			},
+		},
+		{
+			name:  "any host",
+			allow: []string{"method=GET", "path=/health"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "any-host", Rule: "method=GET", Message: "has no domain or ip constraint, so it allows matching requests to every host"},
+				{Severity: SeverityWarning, Check: "any-host", Rule: "path=/health", Message: "has no domain or ip constraint, so it allows matching requests to every host"},
+			},
+		},
+		{
+			name:  "ip rules constrain the host",
+			allow: []string{"ip=10.0.0.0/8 port=5000"},
+		},
+		{
+			name:  "broad domain",
+			allow: []string{"domain=*.com", "domain=*.*-io.net", "domain=*.example.org"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "broad-domain", Rule: "domain=*.com", Message: `allows every domain under the top level domain "com"`},
+				{Severity: SeverityWarning, Check: "broad-domain", Rule: "domain=*.*-io.net", Message: `allows every domain under the top level domain "net"`},
+			},
+		},
+		{
+			name: "shadowed by a deny rule",
+			allow: []string{
+				"domain=*.github.com",
+				"method=GET domain=gist.github.com path=/x",
+				"domain=api.github.com",
+			},
+			deny: []string{"domain=gist.github.com"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "shadowed", Rule: "method=GET domain=gist.github.com path=/x", Message: `never allows anything: every request it matches is denied by "domain=gist.github.com"`},
+				{Severity: SeverityWarning, Check: "redundant", Rule: "domain=api.github.com", Message: `every request it matches already matches the earlier rule "domain=*.github.com"`},
+			},
+		},
+		{
+			name: "redundant allow rules",
+			allow: []string{
+				"domain=api.github.com path=/repos/*",
+				"method=GET domain=api.github.com path=/repos/ours/issues",
+				"domain=api.github.com path=/repos/ours/*",
+				"method=GET domain=api.github.com path=/users/*",
+			},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "redundant", Rule: "method=GET domain=api.github.com path=/repos/ours/issues", Message: `every request it matches already matches the earlier rule "domain=api.github.com path=/repos/*"`},
+				{Severity: SeverityWarning, Check: "redundant", Rule: "domain=api.github.com path=/repos/ours/*", Message: `every request it matches already matches the earlier rule "domain=api.github.com path=/repos/*"`},
+			},
+		},
+		{
+			name: "more specific rules first are not redundant",
+			allow: []string{
+				"method=GET domain=api.github.com path=/repos/ours/issues",
+				"domain=api.github.com path=/repos/*",
+			},
+		},
+		{
+			name:  "redundant deny rules",
+			allow: []string{"domain=example.com", "domain=*.example.com"},
+			deny:  []string{"path=/admin/**", "domain=example.com path=/admin/users"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "redundant", Rule: "domain=example.com path=/admin/users", Message: `every request it matches already matches the earlier rule "path=/admin/**"`},
+			},
+		},
+		{
+			name:  "passthrough rules",
+			allow: []string{"domain=*.github.com", "sni=github.com inspect=false", "sni=api.github.com inspect=false"},
+			deny:  []string{"domain=api.github.com path=/admin/**"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "shadowed", Rule: "sni=api.github.com inspect=false", Message: `never passes anything through: requests to every server it matches can be denied by "domain=api.github.com path=/admin/**"`},
+			},
+		},
+		{
+			name:  "apex only",
+			allow: []string{"domain=github.com", "domain=pypi.org", "domain=*.pypi.org", "domain=api.example.com"},
+			expected: []Finding{
+				{Severity: SeverityInfo, Check: "apex-only", Rule: "domain=github.com", Message: "matches only github.com itself, not subdomains; add domain=*.github.com if they are intended"},
+			},
+		},
+		{
+			name:  "time bounds",
+			allow: []string{"domain=*.example.com until=2020-01-01T00:00:00Z", "domain=*.example.com from=2999-01-01T00:00:00Z", "domain=*.example.com until=2999-01-01T00:00:00Z"},
+			expected: []Finding{
+				{Severity: SeverityWarning, Check: "expired", Rule: "domain=*.example.com until=2020-01-01T00:00:00Z", Message: "expired at 2020-01-01T00:00:00Z and no longer matches any request"},
+				{Severity: SeverityInfo, Check: "not-active-yet", Rule: "domain=*.example.com from=2999-01-01T00:00:00Z", Message: "matches no request until 2999-01-01T00:00:00Z"},
+			},
+		},
+		{
+			name:  "errors sort first",
+			allow: []string{"domain=github.com", "method=GET", "domain=*"},
+			expected: []Finding{
+				{Severity: SeverityError, Check: "matches-everything", Rule: "domain=*", Message: "allows every request to every host"},
+				{Severity: SeverityWarning, Check: "any-host", Rule: "method=GET", Message: "has no domain or ip constraint, so it allows matching requests to every host"},
+				{Severity: SeverityInfo, Check: "apex-only", Rule: "domain=github.com", Message: "matches only github.com itself, not subdomains; add domain=*.github.com if they are intended"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, lintSpecs(t, tt.allow, tt.deny))
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"domain=github.com", "domain=github.com", true},
+		{"domain=github.com", "method=GET domain=github.com path=/x", true},
+		{"domain=github.com", "domain=api.github.com", false},
+		{"domain=*.github.com", "domain=api.github.com", true},
+		{"domain=*.github.com", "domain=a.b.github.com", true},
+		{"domain=*.github.com", "domain=*.api.github.com", true},
+		{"domain=*.github.com", "domain=github.com", false},
+		{"domain=api.github.com", "domain=*.github.com", false},
+		{"domain=api-*.github.com", "domain=api-eu.github.com", true},
+		{"domain=api-eu.github.com", "domain=api-*.github.com", false},
+		{"domain=github.com", "method=GET", false},
+		{"method=GET", "method=GET,HEAD domain=github.com", false},
+		{"method=GET,HEAD", "method=GET domain=github.com", true},
+		{"scheme=https", "domain=github.com", false},
+		{"scheme=https", "scheme=https domain=github.com", true},
+		{"port=443,8443", "port=443 domain=github.com", true},
+		{"ip=10.0.0.0/8", "ip=10.1.0.0/16", true},
+		{"ip=10.1.0.0/16", "ip=10.0.0.0/8", false},
+		{"ip=10.0.0.0/8", "domain=10.1.2.3", false},
+		{"domain=example.com path=/a/*", "domain=example.com path=/a/b", true},
+		{"domain=example.com path=/a/*", "domain=example.com path=/a/b/*", true},
+		{"domain=example.com path=/a/*", "domain=example.com path=/a/**", false},
+		{"domain=example.com path=/a/*", "domain=example.com path=/a", false},
+		{"domain=example.com path=/a/**", "domain=example.com path=/a/**", true},
+		{"domain=example.com path=/a/**", "domain=example.com path=/a", true},
+		{"domain=example.com path=/a/**", "domain=example.com path=/a/*/c/**", true},
+		{"domain=example.com path=/a/b-*", "domain=example.com path=/a/*", false},
+		{"domain=example.com path=/*/b", "domain=example.com path=/**/b", false},
+		{"domain=example.com path=/a,/b", "domain=example.com path=/b", true},
+		{"domain=example.com path=/a", "domain=example.com path=/a,/b", false},
+		{"domain=example.com", "domain=example.com until=2030-01-01T00:00:00Z", true},
+		{"domain=example.com until=2030-01-01T00:00:00Z", "domain=example.com", false},
+		{"domain=example.com until=2030-01-01T00:00:00Z", "domain=example.com until=2029-01-01T00:00:00Z", true},
+		{"domain=example.com until=2029-01-01T00:00:00Z", "domain=example.com until=2030-01-01T00:00:00Z", false},
+		{"domain=example.com from=2030-01-01T00:00:00Z", "domain=example.com", false},
+		{"domain=example.com from=2030-01-01T00:00:00Z", "domain=example.com from=2031-01-01T00:00:00Z", true},
+		{"domain=example.com path=/a", "domain=example.com", false},
+		{"domain=example.com query=page=*", "domain=example.com query=page=2", true},
+		{"domain=example.com query=page=2", "domain=example.com query=page=*", false},
+		{"domain=example.com query=page=2", "domain=example.com", false},
+		{"domain=example.com header=Authorization", "domain=example.com header=Authorization:Bearer*", true},
+		{"domain=example.com header=Authorization:Bearer*", "domain=example.com header=Authorization", false},
+		{"domain=example.com header=!Authorization", "domain=example.com header=Authorization", false},
+		{"domain=github.com", "sni=github.com inspect=false", false},
+		{"sni=github.com inspect=false", "domain=github.com", false},
+		{"sni=*.github.com inspect=false", "sni=api.github.com inspect=false port=443", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" covers "+tt.b, func(t *testing.T) {
+			rules, err := ParseAllowSpecs([]string{tt.a, tt.b})
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, covers(rules[0], rules[1]))
+		})
+	}
+}
+
+// TestCoversIsSound checks covers against the engine: whenever a rule is said to cover another, every request
+// matching the other has to match it too.
+func TestCoversIsSound(t *testing.T) {
+	specs := []string{
+		"domain=github.com",
+		"domain=*.github.com",
+		"domain=api.github.com path=/repos/*",
+		"domain=api.github.com path=/repos/ours/*",
+		"domain=api.github.com path=/repos/**",
+		"method=GET domain=api.github.com path=/repos/ours/issues",
+		"domain=api-*.github.com",
+		"domain=*.com",
+		"method=GET",
+		"path=/**/issues",
+		"scheme=https domain=github.com",
+		"domain=github.com query=page=*",
+		"ip=10.0.0.0/8",
+		"domain=10.1.2.3",
+	}
+	urls := []string{
+		"https://github.com/",
+		"http://github.com/x?page=2",
+		"https://api.github.com/repos",
+		"https://api.github.com/repos/ours",
+		"https://api.github.com/repos/ours/issues",
+		"https://api.github.com/repos/ours/a/b",
+		"https://api-eu.github.com/",
+		"https://a.api.github.com/x/issues",
+		"https://example.com/",
+		"http://10.1.2.3/",
+	}
+
+	rules, err := ParseAllowSpecs(specs)
+	require.NoError(t, err)
+
+	for _, a := range rules {
+		engineA := NewRuleEngine([]Rule{a}, slog.New(slog.DiscardHandler))
+		for _, b := range rules {
+			if !covers(a, b) {
+				continue
+			}
+			engineB := NewRuleEngine([]Rule{b}, slog.New(slog.DiscardHandler))
+			for _, url := range urls {
+				for _, method := range []string{"GET", "POST"} {
+					if engineB.Evaluate(method, url).Allowed {
+						require.True(t, engineA.Evaluate(method, url).Allowed, "%q covers %q but not %s %s", a.Raw, b.Raw, method, url)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestHasProblems(t *testing.T) {
+	require.False(t, HasProblems(nil))
+	require.False(t, HasProblems([]Finding{{Severity: SeverityInfo}}))
+	require.True(t, HasProblems([]Finding{{Severity: SeverityInfo}, {Severity: SeverityWarning}}))
+	require.True(t, HasProblems([]Finding{{Severity: SeverityError}}))
+}
@@ -2,8 +2,11 @@ package rulesengine
 
 import (
 	"log/slog"
+	"net/http"
+	"net/netip"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -344,6 +347,238 @@ func TestRoundTripExtraRules(t *testing.T) {
 			expectParse: true,
 			expectMatch: false,
 		},
+		{
+			name:        "partial label glob matches",
+			rules:       []string{"domain=api-*.example.com"},
+			url:         "https://api-eu.example.com/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "partial label glob stays within its label",
+			rules:       []string{"domain=api-*.example.com"},
+			url:         "https://api-eu.v2.example.com/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "partial label glob requires the literal part",
+			rules:       []string{"domain=api-*.example.com"},
+			url:         "https://web-eu.example.com/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "leading partial label glob does not match deeper subdomains",
+			rules:       []string{"domain=*-staging.example.com"},
+			url:         "https://a.web-staging.example.com/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "partial segment glob matches",
+			rules:       []string{"path=/v1*"},
+			url:         "https://example.com/v1beta",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "partial segment glob does not span segments",
+			rules:       []string{"path=/v1*"},
+			url:         "https://example.com/v1beta/users",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "release path globs",
+			rules:       []string{"domain=api.github.com path=/repos/ourorg/*/releases/v*"},
+			url:         "https://api.github.com/repos/ourorg/boundary/releases/v1.2.3",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "release path globs reject non-version tags",
+			rules:       []string{"domain=api.github.com path=/repos/ourorg/*/releases/v*"},
+			url:         "https://api.github.com/repos/ourorg/boundary/releases/latest",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "file extension glob",
+			rules:       []string{"path=/downloads/*.tar.gz"},
+			url:         "https://example.com/downloads/boundary-linux-amd64.tar.gz",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "recursive wildcard matches nested comments",
+			rules:       []string{"path=/api/**/comments"},
+			url:         "https://example.com/api/repos/ours/issues/1/comments",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "recursive wildcard matches zero segments",
+			rules:       []string{"path=/api/**/comments"},
+			url:         "https://example.com/api/comments",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "recursive wildcard requires the suffix",
+			rules:       []string{"path=/api/**/comments"},
+			url:         "https://example.com/api/repos/ours/issues",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "recursive wildcard with file name",
+			rules:       []string{"path=/org/**/archive.tar.gz"},
+			url:         "https://example.com/org/a/b/c/archive.tar.gz",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "recursive wildcard inside a segment is rejected",
+			rules:       []string{"path=/org/a**/archive.tar.gz"},
+			url:         "https://example.com/org/a/archive.tar.gz",
+			method:      "GET",
+			expectParse: false,
+			expectMatch: false,
+		},
+		{
+			name:        "scheme=https rejects plain http",
+			rules:       []string{"scheme=https domain=internal.corp"},
+			url:         "http://internal.corp/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "scheme=https matches https",
+			rules:       []string{"scheme=https domain=internal.corp"},
+			url:         "https://internal.corp/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "scheme rule does not match URL without scheme",
+			rules:       []string{"scheme=https domain=internal.corp"},
+			url:         "internal.corp",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "port matches default https port",
+			rules:       []string{"domain=internal.corp port=443,8443"},
+			url:         "https://internal.corp/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "port matches explicit port",
+			rules:       []string{"domain=internal.corp port=443,8443"},
+			url:         "https://internal.corp:8443/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "port rejects other port",
+			rules:       []string{"domain=internal.corp port=443,8443"},
+			url:         "https://internal.corp:9000/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "port rejects default http port",
+			rules:       []string{"domain=internal.corp port=443"},
+			url:         "http://internal.corp/",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "query wildcard matches",
+			rules:       []string{"domain=api.github.com path=/search query=repo=ours/*"},
+			url:         "https://api.github.com/search?repo=ours/boundary&page=2",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "query value mismatch rejects",
+			rules:       []string{"domain=api.github.com path=/search query=repo=ours/*"},
+			url:         "https://api.github.com/search?repo=theirs/secret",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "missing query parameter rejects",
+			rules:       []string{"domain=api.github.com query=repo=ours/*"},
+			url:         "https://api.github.com/search",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "every value of a repeated parameter must match",
+			rules:       []string{"domain=api.github.com query=repo=ours/*"},
+			url:         "https://api.github.com/search?repo=ours/a&repo=theirs/b",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "repeated query keys must all match",
+			rules:       []string{"query=repo=ours/* query=type=issue"},
+			url:         "https://api.github.com/search?type=pr&repo=ours/a",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "repeated query keys all matching",
+			rules:       []string{"query=repo=ours/* query=type=issue"},
+			url:         "https://api.github.com/search?type=issue&repo=ours/a",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
+		{
+			name:        "malformed query never matches allow rules",
+			rules:       []string{"domain=api.github.com query=repo=ours/*"},
+			url:         "https://api.github.com/search?repo=ours/a;repo=theirs/b",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: false,
+		},
+		{
+			name:        "query value is compared decoded",
+			rules:       []string{"query=q=hello%20*"},
+			url:         "https://example.com/?q=hello+world",
+			method:      "GET",
+			expectParse: true,
+			expectMatch: true,
+		},
 	}
 
 	logHandler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
@@ -365,3 +600,580 @@ func TestRoundTripExtraRules(t *testing.T) {
 		})
 	}
 }
+
+func TestDenyRules(t *testing.T) {
+	tcs := []struct {
+		name          string
+		allow         []string
+		deny          []string
+		url           string
+		method        string
+		expectAllowed bool
+		expectRule    string
+	}{
+		{
+			name:          "deny overrides matching wildcard allow",
+			allow:         []string{"domain=*.github.com"},
+			deny:          []string{"domain=gist.github.com"},
+			url:           "https://gist.github.com/foo",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "domain=gist.github.com",
+		},
+		{
+			name:          "allow still applies where deny does not match",
+			allow:         []string{"domain=*.github.com"},
+			deny:          []string{"domain=gist.github.com"},
+			url:           "https://api.github.com/repos",
+			method:        "GET",
+			expectAllowed: true,
+			expectRule:    "domain=*.github.com",
+		},
+		{
+			name:          "deny by method on an allowed domain",
+			allow:         []string{"domain=api.example.com"},
+			deny:          []string{"method=DELETE domain=api.example.com"},
+			url:           "https://api.example.com/users/1",
+			method:        "DELETE",
+			expectAllowed: false,
+			expectRule:    "method=DELETE domain=api.example.com",
+		},
+		{
+			name:          "deny by method lets other methods through",
+			allow:         []string{"domain=api.example.com"},
+			deny:          []string{"method=DELETE domain=api.example.com"},
+			url:           "https://api.example.com/users/1",
+			method:        "GET",
+			expectAllowed: true,
+			expectRule:    "domain=api.example.com",
+		},
+		{
+			name:          "deny without any allow rule reports the deny rule",
+			deny:          []string{"domain=example.com"},
+			url:           "https://example.com/",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "domain=example.com",
+		},
+		{
+			name:          "deny matches a repeated query parameter when any value matches",
+			allow:         []string{"domain=x"},
+			deny:          []string{"domain=x query=force=true"},
+			url:           "https://x/?force=true&force=false",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "domain=x query=force=true",
+		},
+		{
+			name:          "deny matches a repeated query parameter whatever the order of its values",
+			allow:         []string{"domain=x"},
+			deny:          []string{"domain=x query=force=true"},
+			url:           "https://x/?force=false&force=true",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "domain=x query=force=true",
+		},
+		{
+			name:          "deny ignores a query parameter without a matching value",
+			allow:         []string{"domain=x"},
+			deny:          []string{"domain=x query=force=true"},
+			url:           "https://x/?force=false&force=no",
+			method:        "GET",
+			expectAllowed: true,
+			expectRule:    "domain=x",
+		},
+		{
+			name:          "deny matches a malformed query",
+			allow:         []string{"domain=x"},
+			deny:          []string{"domain=x query=force=true"},
+			url:           "https://x/?a=1;force=true",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "domain=x query=force=true",
+		},
+		{
+			name:          "default deny reports no rule",
+			allow:         []string{"domain=github.com"},
+			deny:          []string{"path=/admin/*"},
+			url:           "https://example.com/",
+			method:        "GET",
+			expectAllowed: false,
+			expectRule:    "",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			allowRules, err := ParseAllowSpecs(tc.allow)
+			require.NoError(t, err)
+			denyRules, err := ParseDenySpecs(tc.deny)
+			require.NoError(t, err)
+			for _, r := range denyRules {
+				require.True(t, r.Deny)
+			}
+
+			engine := NewRuleEngine(append(allowRules, denyRules...), logger)
+			result := engine.Evaluate(tc.method, tc.url)
+			require.Equal(t, tc.expectAllowed, result.Allowed)
+			require.Equal(t, tc.expectRule, result.Rule)
+		})
+	}
+}
+
+func TestParseDenySpecsInvalid(t *testing.T) {
+	_, err := ParseDenySpecs([]string{"domain=github.com", "notakey=1"})
+	require.ErrorContains(t, err, "failed to parse deny 'notakey=1'")
+
+	_, err = ParseDenySpecs([]string{"domain=github.com rate=1/s"})
+	require.ErrorContains(t, err, "rate and quota only apply to allow rules")
+
+	_, err = ParseDenySpecs([]string{"sni=github.com inspect=false"})
+	require.ErrorContains(t, err, "inspect=false only applies to allow rules")
+}
+
+func TestHeaderRules(t *testing.T) {
+	tcs := []struct {
+		name          string
+		allow         []string
+		deny          []string
+		header        http.Header
+		expectAllowed bool
+	}{
+		{
+			name:          "required header present with matching value",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization:org-123"},
+			header:        http.Header{"Openai-Organization": {"org-123"}},
+			expectAllowed: true,
+		},
+		{
+			name:          "required header present with wrong value",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization:org-123"},
+			header:        http.Header{"Openai-Organization": {"org-456"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "required header missing",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization:org-123"},
+			header:        http.Header{},
+			expectAllowed: false,
+		},
+		{
+			name:          "nil header behaves like no headers",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization"},
+			header:        nil,
+			expectAllowed: false,
+		},
+		{
+			name:          "every value of a repeated header must match",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization:org-123"},
+			header:        http.Header{"Openai-Organization": {"org-123", "org-456"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "repeated header with every value matching",
+			allow:         []string{"domain=api.openai.com header=OpenAI-Organization:org-*"},
+			header:        http.Header{"Openai-Organization": {"org-123", "org-456"}},
+			expectAllowed: true,
+		},
+		{
+			name:          "deny matches a repeated header when any value matches",
+			allow:         []string{"domain=api.openai.com"},
+			deny:          []string{"header=X-Evil:yes"},
+			header:        http.Header{"X-Evil": {"yes", "no"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "deny matches a repeated header whatever the order of its values",
+			allow:         []string{"domain=api.openai.com"},
+			deny:          []string{"header=X-Evil:yes"},
+			header:        http.Header{"X-Evil": {"no", "yes"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "deny ignores a repeated header without a matching value",
+			allow:         []string{"domain=api.openai.com"},
+			deny:          []string{"header=X-Evil:yes"},
+			header:        http.Header{"X-Evil": {"no", "maybe"}},
+			expectAllowed: true,
+		},
+		{
+			name:          "deny any request carrying a cookie",
+			allow:         []string{"domain=api.openai.com"},
+			deny:          []string{"header=Cookie"},
+			header:        http.Header{"Cookie": {"session=abc"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "cookie deny does not affect requests without cookies",
+			allow:         []string{"domain=api.openai.com"},
+			deny:          []string{"header=Cookie"},
+			header:        http.Header{"Authorization": {"Bearer x"}},
+			expectAllowed: true,
+		},
+		{
+			name:          "absent header required",
+			allow:         []string{"domain=api.openai.com header=!Cookie"},
+			header:        http.Header{"Cookie": {"session=abc"}},
+			expectAllowed: false,
+		},
+		{
+			name:          "absent header satisfied",
+			allow:         []string{"domain=api.openai.com header=!Cookie"},
+			header:        http.Header{},
+			expectAllowed: true,
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			allowRules, err := ParseAllowSpecs(tc.allow)
+			require.NoError(t, err)
+			denyRules, err := ParseDenySpecs(tc.deny)
+			require.NoError(t, err)
+
+			engine := NewRuleEngine(append(allowRules, denyRules...), logger)
+			result := engine.EvaluateRequest(Request{
+				Method: "POST",
+				URL:    "https://api.openai.com/v1/chat/completions",
+				Header: tc.header,
+			})
+			require.Equal(t, tc.expectAllowed, result.Allowed)
+		})
+	}
+}
+
+func TestIPRules(t *testing.T) {
+	tcs := []struct {
+		name              string
+		allow             []string
+		deny              []string
+		url               string
+		destination       string
+		expectAllowed     bool
+		expectDestination string
+	}{
+		{
+			name:          "IPv4 literal inside prefix",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://10.20.0.5:5000/v2/",
+			expectAllowed: true,
+		},
+		{
+			name:          "IPv4 literal outside prefix",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://192.168.1.5:5000/v2/",
+			expectAllowed: false,
+		},
+		{
+			name:          "single address",
+			allow:         []string{"ip=10.20.0.5 port=5000"},
+			url:           "http://10.20.0.5:5000/v2/",
+			expectAllowed: true,
+		},
+		{
+			name:          "IPv6 literal inside prefix",
+			allow:         []string{"ip=fd00::/8"},
+			url:           "http://[fd12:3456::1]:8080/",
+			expectAllowed: true,
+		},
+		{
+			name:          "IPv6 prefix does not match IPv4",
+			allow:         []string{"ip=fd00::/8"},
+			url:           "http://10.20.0.5/",
+			expectAllowed: false,
+		},
+		{
+			name:          "IPv4-mapped IPv6 literal matches IPv4 prefix",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://[::ffff:10.20.0.5]/",
+			expectAllowed: true,
+		},
+		{
+			name:          "host name without destination never matches",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://registry.internal/v2/",
+			expectAllowed: false,
+		},
+		{
+			name:              "host name matches original destination",
+			allow:             []string{"ip=10.0.0.0/8"},
+			url:               "http://registry.internal/v2/",
+			destination:       "10.20.0.5",
+			expectAllowed:     true,
+			expectDestination: "10.20.0.5",
+		},
+		{
+			name:          "IP-literal host wins over original destination",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://192.168.1.5/v2/",
+			destination:   "10.20.0.5",
+			expectAllowed: false,
+		},
+		{
+			name:          "IP-literal match does not pin the destination",
+			allow:         []string{"ip=10.0.0.0/8"},
+			url:           "http://10.20.0.5/v2/",
+			destination:   "10.20.0.5",
+			expectAllowed: true,
+		},
+		{
+			name:          "domain rule does not pin the destination",
+			allow:         []string{"domain=registry.internal"},
+			url:           "http://registry.internal/v2/",
+			destination:   "10.20.0.5",
+			expectAllowed: true,
+		},
+		{
+			name:          "deny a link-local range",
+			allow:         []string{"domain=*"},
+			deny:          []string{"ip=169.254.0.0/16"},
+			url:           "http://169.254.169.254/latest/meta-data/",
+			expectAllowed: false,
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			allowRules, err := ParseAllowSpecs(tc.allow)
+			require.NoError(t, err)
+			denyRules, err := ParseDenySpecs(tc.deny)
+			require.NoError(t, err)
+
+			var destination netip.Addr
+			if tc.destination != "" {
+				destination = netip.MustParseAddr(tc.destination)
+			}
+
+			engine := NewRuleEngine(append(allowRules, denyRules...), logger)
+			result := engine.EvaluateRequest(Request{
+				Method:      "GET",
+				URL:         tc.url,
+				Destination: destination,
+			})
+			require.Equal(t, tc.expectAllowed, result.Allowed)
+
+			var expectDestination netip.Addr
+			if tc.expectDestination != "" {
+				expectDestination = netip.MustParseAddr(tc.expectDestination)
+			}
+			require.Equal(t, expectDestination, result.Destination)
+		})
+	}
+}
+
+func TestHostCanonicalization(t *testing.T) {
+	tcs := []struct {
+		name          string
+		allow         string
+		url           string
+		expectAllowed bool
+	}{
+		// Case
+		{"uppercase request host", "domain=github.com", "https://GitHub.COM/", true},
+		{"uppercase subdomain", "domain=*.github.com", "https://API.github.com/", true},
+		{"uppercase rule", "domain=GitHub.com", "https://github.com/", true},
+		{"uppercase label glob", "domain=API-*.github.com", "https://api-eu.GITHUB.com/", true},
+
+		// Trailing dot
+		{"fully qualified request host", "domain=github.com", "https://github.com./", true},
+		{"fully qualified request host with port", "domain=github.com port=443", "https://github.com.:443/", true},
+		{"fully qualified subdomain", "domain=*.github.com", "https://api.github.com./", true},
+		{"fully qualified rule", "domain=github.com.", "https://github.com/", true},
+		{"fully qualified rule and host", "domain=github.com.", "https://github.com./", true},
+		{"two trailing dots", "domain=github.com", "https://github.com../", false},
+		{"fully qualified host without scheme", "domain=github.com", "github.com./x", true},
+
+		// IDNA
+		{"punycode request host", "domain=bücher.de", "https://xn--bcher-kva.de/", true},
+		{"unicode request host", "domain=bücher.de", "https://bücher.de/", true},
+		{"uppercase unicode request host", "domain=bücher.de", "https://BÜCHER.de/", true},
+		{"percent-encoded unicode request host", "domain=bücher.de", "https://b%C3%BCcher.de/", true},
+		{"punycode rule", "domain=xn--bcher-kva.de", "https://bücher.de/", true},
+		{"unicode subdomain", "domain=*.bücher.de", "https://shop.bücher.de./", true},
+		{"different unicode host", "domain=bücher.de", "https://bucher.de/", false},
+		{"invalid unicode host", "domain=ab.de", "https://a\u200db.de/", false},
+		{"invalid unicode host still matches a wildcard label", "domain=*.de", "https://a\u200db.de/", true},
+
+		// IP literals are unaffected
+		{"IPv6 literal in uppercase", "ip=fd00::/8", "http://[FD12:3456::1]/", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := ParseAllowSpecs([]string{tc.allow})
+			require.NoError(t, err)
+
+			engine := NewRuleEngine(rules, slog.Default())
+			require.Equal(t, tc.expectAllowed, engine.Evaluate("GET", tc.url).Allowed)
+		})
+	}
+}
+
+func TestPathCanonicalizationRules(t *testing.T) {
+	allowRules, err := ParseAllowSpecs([]string{"domain=example.com path=/api/**", "domain=registry.example.com path=/@scope/*"})
+	require.NoError(t, err)
+	denyRules, err := ParseDenySpecs([]string{"path=/admin/**"})
+	require.NoError(t, err)
+	engine := NewRuleEngine(append(allowRules, denyRules...), slog.Default())
+
+	tcs := []struct {
+		url           string
+		expectAllowed bool
+	}{
+		{"https://example.com/api/v1", true},
+		{"https://example.com/api/v1/../../admin", false},
+		{"https://example.com/api/%2e%2e/admin", false},
+		{"https://example.com//admin", false},
+		{"https://example.com/admin/../api/v1", true},
+		{"https://example.com//api//v1", true},
+		{"https://example.com/api/..%2Fadmin", false},
+		{"https://registry.example.com/@scope%2Fpkg", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.url, func(t *testing.T) {
+			require.Equal(t, tc.expectAllowed, engine.Evaluate("GET", tc.url).Allowed)
+		})
+	}
+}
+
+func TestTimeBoundRules(t *testing.T) {
+	allowRules, err := ParseAllowSpecs([]string{
+		"domain=pastebin.com from=2026-10-16T09:00:00Z until=2026-10-16T17:00:00Z",
+		"domain=example.com path=/old/** until=2026-10-16T12:00:00Z",
+		"domain=example.com path=/old/report",
+	})
+	require.NoError(t, err)
+	denyRules, err := ParseDenySpecs([]string{"domain=example.com method=DELETE from=2026-10-16T12:00:00Z"})
+	require.NoError(t, err)
+	engine := NewRuleEngine(append(allowRules, denyRules...), slog.Default())
+
+	at := func(clock string) time.Time {
+		ts, err := time.Parse(time.RFC3339, "2026-10-16T"+clock+"Z")
+		require.NoError(t, err)
+		return ts
+	}
+
+	tcs := []struct {
+		name                string
+		method              string
+		url                 string
+		time                string
+		expectAllowed       bool
+		expectedExpiredRule string
+	}{
+		{name: "before from", url: "https://pastebin.com/x", time: "08:59:59"},
+		{name: "at from", url: "https://pastebin.com/x", time: "09:00:00", expectAllowed: true},
+		{name: "before until", url: "https://pastebin.com/x", time: "16:59:59", expectAllowed: true},
+		{name: "at until", url: "https://pastebin.com/x", time: "17:00:00", expectedExpiredRule: "domain=pastebin.com from=2026-10-16T09:00:00Z until=2026-10-16T17:00:00Z"},
+		{name: "expired rule that wouldn't match", url: "https://pastebin.org/x", time: "18:00:00"},
+		{name: "another rule still matches", url: "https://example.com/old/report", time: "13:00:00", expectAllowed: true},
+		{name: "expired rule only", url: "https://example.com/old/data", time: "13:00:00", expectedExpiredRule: "domain=example.com path=/old/** until=2026-10-16T12:00:00Z"},
+		{name: "deny rule not active yet", method: "DELETE", url: "https://example.com/old/report", time: "11:00:00", expectAllowed: true},
+		{name: "deny rule active", method: "DELETE", url: "https://example.com/old/report", time: "12:00:00"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			result := engine.EvaluateRequest(Request{Method: method, URL: tc.url, Time: at(tc.time)})
+			require.Equal(t, tc.expectAllowed, result.Allowed)
+			require.Equal(t, tc.expectedExpiredRule, result.ExpiredRule)
+		})
+	}
+}
+
+func TestLimitInResult(t *testing.T) {
+	rules, err := ParseAllowSpecs([]string{"domain=api.example.com rate=30/m per=host", "domain=example.com"})
+	require.NoError(t, err)
+	engine := NewRuleEngine(rules, slog.Default())
+
+	result := engine.Evaluate("GET", "https://api.example.com/v1")
+	require.True(t, result.Allowed)
+	require.Equal(t, Limit{Rate: 30, Interval: time.Minute, PerHost: true}, result.Limit)
+
+	result = engine.Evaluate("GET", "https://example.com/")
+	require.True(t, result.Allowed)
+	require.True(t, result.Limit.IsZero())
+}
+
+func TestLimitKeys(t *testing.T) {
+	rules, err := ParseAllowSpecs([]string{"domain=a.com quota=1", "domain=b.com quota=1", "domain=a.com quota=1"})
+	require.NoError(t, err)
+	engine := NewRuleEngine(rules, slog.Default())
+	require.Equal(t, "0 domain=a.com quota=1", engine.Evaluate("GET", "https://a.com/").LimitKey)
+
+	// Rules with the same spec are told apart, and keep their keys when other rules are added.
+	keys := limitKeys(rules)
+	require.Equal(t, []string{"0 domain=a.com quota=1", "0 domain=b.com quota=1", "1 domain=a.com quota=1"}, keys)
+	more, err := ParseAllowSpecs([]string{"domain=c.com"})
+	require.NoError(t, err)
+	require.Equal(t, keys, limitKeys(append(rules, more...))[:3])
+}
+
+func TestPassthroughRules(t *testing.T) {
+	for spec, want := range map[string]string{
+		"sni=github.com":                                 "sni and inspect=false must be used together",
+		"domain=github.com inspect=false":                "sni and inspect=false must be used together",
+		"sni=github.com domain=github.com inspect=false": "sni and domain can't be used together",
+		"sni=github.com inspect=false path=/x":           "inspect=false rules can only have sni, ip, port, from and until keys",
+		"sni=github.com inspect=false rate=1/s":          "inspect=false rules can only have sni, ip, port, from and until keys",
+		"sni=github.com inspect=no":                      "inspect must be true or false, got: no",
+	} {
+		_, err := ParseAllowSpecs([]string{spec})
+		require.ErrorContains(t, err, want, spec)
+	}
+
+	allow, err := ParseAllowSpecs([]string{
+		"domain=*.googleapis.com",
+		"sni=*.googleapis.com inspect=false",
+		"sni=registry.internal inspect=false port=5000 ip=10.0.0.0/8",
+	})
+	require.NoError(t, err)
+	deny, err := ParseDenySpecs([]string{"domain=storage.googleapis.com path=/private/*"})
+	require.NoError(t, err)
+	engine := NewRuleEngine(append(allow, deny...), slog.Default())
+	require.True(t, engine.HasPassthrough())
+
+	// Passthrough rules never allow requests.
+	require.False(t, engine.Evaluate("GET", "https://registry.internal/v2/").Allowed)
+	require.Equal(t, "domain=*.googleapis.com", engine.Evaluate("GET", "https://iam.googleapis.com/").Rule)
+
+	result := engine.EvaluateConnection(Connection{ServerName: "iam.googleapis.com", Port: 443})
+	require.True(t, result.Allowed)
+	require.Equal(t, "sni=*.googleapis.com inspect=false", result.Rule)
+
+	// The deny rule could block requests to the server, so its connections are decrypted to check them.
+	result = engine.EvaluateConnection(Connection{ServerName: "storage.googleapis.com", Port: 443})
+	require.False(t, result.Allowed)
+	require.Equal(t, "domain=storage.googleapis.com path=/private/*", result.Rule)
+
+	require.False(t, engine.EvaluateConnection(Connection{ServerName: "github.com"}).Allowed)
+	require.False(t, engine.EvaluateConnection(Connection{}).Allowed)
+
+	// ip patterns are matched against the original destination, where the connection then has to go.
+	dst := netip.MustParseAddr("10.1.2.3")
+	result = engine.EvaluateConnection(Connection{ServerName: "registry.internal", Port: 5000, Destination: dst})
+	require.True(t, result.Allowed)
+	require.Equal(t, dst, result.Destination)
+	require.False(t, engine.EvaluateConnection(Connection{ServerName: "registry.internal", Port: 443, Destination: dst}).Allowed)
+	require.False(t, engine.EvaluateConnection(Connection{ServerName: "registry.internal", Port: 5000}).Allowed)
+
+	inspected := NewRuleEngine(allow[:1], slog.Default())
+	require.False(t, inspected.HasPassthrough())
+}
@@ -0,0 +1,117 @@
+package rulesengine
+
+import (
+	"errors"
+	neturl "net/url"
+	"strings"
+)
+
+// ErrAmbiguousPath is returned for paths whose meaning depends on the server: an encoded slash or a backslash
+// that would make a dot segment or an empty segment if the server treated it as a separator, like
+// `/files/..%2Fadmin`. No canonical form means the same thing to every server, so these are always refused.
+var ErrAmbiguousPath = errors.New("path has a dot or empty segment hidden behind an encoded slash or backslash")
+
+// CanonicalPath is a URL path in the form boundary evaluates and forwards.
+type CanonicalPath struct {
+	// Escaped is the canonical path, percent-encoded the way the client sent it. This is what gets forwarded.
+	Escaped string
+	// Segments are the decoded segments rules match against, without the leading empty segment. An encoded
+	// slash separates segments here too, so `/@scope%2Fpkg` matches `path=/@scope/pkg`.
+	Segments []string
+	// Changed reports whether canonicalizing had to remove dot segments (`.`, `..`, also percent-encoded) or
+	// empty segments (`//`).
+	Changed bool
+}
+
+// CanonicalizePath resolves dot segments and collapses empty segments of an escaped URL path, following RFC 3986
+// section 5.2.4 on the segments as they are sent, percent-encoding included. The proxy evaluates and forwards
+// the result, so the rules see exactly the path the upstream server gets.
+func CanonicalizePath(escaped string) (CanonicalPath, error) {
+	if escaped == "" {
+		return CanonicalPath{Escaped: escaped}, nil
+	}
+	if !strings.HasPrefix(escaped, "/") {
+		// Not an absolute path, i.e. `*` for `OPTIONS *`. There is nothing to resolve it against.
+		return CanonicalPath{Escaped: escaped, Segments: []string{escaped}}, nil
+	}
+
+	// Most paths are already canonical and need no decoding, so they can be split as they are.
+	if isSimplePath(escaped) {
+		return CanonicalPath{Escaped: escaped, Segments: strings.Split(escaped[1:], "/")}, nil
+	}
+
+	raw := strings.Split(escaped[1:], "/")
+	out := make([]string, 0, len(raw))
+	changed := false
+	for i, segment := range raw {
+		last := i == len(raw)-1
+
+		decoded, err := neturl.PathUnescape(segment)
+		if err != nil {
+			return CanonicalPath{}, err
+		}
+
+		switch {
+		case decoded == "." || decoded == "..":
+			changed = true
+			if decoded == ".." && len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			// A path ending in a dot segment names a directory: `/a/b/..` is `/a/`.
+			if last {
+				out = append(out, "")
+			}
+
+		case segment == "" && !last:
+			// `//` collapses into a single `/`. A trailing empty segment is a trailing slash, which is kept.
+			changed = true
+
+		case strings.ContainsAny(decoded, "/\\") && hasHiddenDotSegment(decoded):
+			return CanonicalPath{}, ErrAmbiguousPath
+
+		default:
+			out = append(out, segment)
+		}
+	}
+
+	canonical := CanonicalPath{
+		Escaped: "/" + strings.Join(out, "/"),
+		Changed: changed,
+	}
+	for _, segment := range out {
+		decoded, _ := neturl.PathUnescape(segment)
+		canonical.Segments = append(canonical.Segments, strings.Split(decoded, "/")...)
+	}
+	return canonical, nil
+}
+
+// isSimplePath reports whether an escaped path starting with `/` is canonical and has nothing to decode.
+func isSimplePath(escaped string) bool {
+	if strings.ContainsAny(escaped, "%\\") || strings.Contains(escaped, "//") {
+		return false
+	}
+	for segment := range strings.SplitSeq(escaped[1:], "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// hasHiddenDotSegment reports whether splitting a decoded segment on slashes and backslashes yields a dot
+// segment or an empty segment.
+func hasHiddenDotSegment(decoded string) bool {
+	for {
+		part, rest, found := decoded, "", false
+		if i := strings.IndexAny(decoded, "/\\"); i >= 0 {
+			part, rest, found = decoded[:i], decoded[i+1:], true
+		}
+		if part == "" || part == "." || part == ".." {
+			return true
+		}
+		if !found {
+			return false
+		}
+		decoded = rest
+	}
+}
@@ -0,0 +1,73 @@
+package rulesengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizePath(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		expectedEscaped  string
+		expectedSegments []string
+		expectedChanged  bool
+		expectedError    error
+	}{
+		{name: "empty path", input: "", expectedEscaped: ""},
+		{name: "root", input: "/", expectedEscaped: "/", expectedSegments: []string{""}},
+		{name: "simple path", input: "/api/v1", expectedEscaped: "/api/v1", expectedSegments: []string{"api", "v1"}},
+		{name: "trailing slash", input: "/api/", expectedEscaped: "/api/", expectedSegments: []string{"api", ""}},
+		{name: "dots inside segments", input: "/a.b/..c/.d.", expectedEscaped: "/a.b/..c/.d.", expectedSegments: []string{"a.b", "..c", ".d."}},
+		{name: "not an absolute path", input: "*", expectedEscaped: "*", expectedSegments: []string{"*"}},
+
+		// Dot segments
+		{name: "dot segment", input: "/api/./v1", expectedEscaped: "/api/v1", expectedSegments: []string{"api", "v1"}, expectedChanged: true},
+		{name: "parent segment", input: "/api/v1/../v2", expectedEscaped: "/api/v2", expectedSegments: []string{"api", "v2"}, expectedChanged: true},
+		{name: "traversal", input: "/api/v1/../../admin", expectedEscaped: "/admin", expectedSegments: []string{"admin"}, expectedChanged: true},
+		{name: "traversal above root", input: "/../../admin", expectedEscaped: "/admin", expectedSegments: []string{"admin"}, expectedChanged: true},
+		{name: "trailing parent segment", input: "/a/b/..", expectedEscaped: "/a/", expectedSegments: []string{"a", ""}, expectedChanged: true},
+		{name: "trailing dot segment", input: "/a/.", expectedEscaped: "/a/", expectedSegments: []string{"a", ""}, expectedChanged: true},
+		{name: "only a parent segment", input: "/..", expectedEscaped: "/", expectedSegments: []string{""}, expectedChanged: true},
+		{name: "encoded parent segment", input: "/api/%2e%2E/admin", expectedEscaped: "/admin", expectedSegments: []string{"admin"}, expectedChanged: true},
+		{name: "half encoded parent segment", input: "/api/.%2e/admin", expectedEscaped: "/admin", expectedSegments: []string{"admin"}, expectedChanged: true},
+
+		// Empty segments
+		{name: "leading double slash", input: "//api", expectedEscaped: "/api", expectedSegments: []string{"api"}, expectedChanged: true},
+		{name: "inner double slash", input: "/api//v1///x", expectedEscaped: "/api/v1/x", expectedSegments: []string{"api", "v1", "x"}, expectedChanged: true},
+		{name: "double trailing slash", input: "/api//", expectedEscaped: "/api/", expectedSegments: []string{"api", ""}, expectedChanged: true},
+
+		// Percent-encoding
+		{name: "encoding is kept", input: "/files/my%20file", expectedEscaped: "/files/my%20file", expectedSegments: []string{"files", "my file"}},
+		{name: "encoded slash separates segments", input: "/@scope%2Fpkg", expectedEscaped: "/@scope%2Fpkg", expectedSegments: []string{"@scope", "pkg"}},
+		{name: "encoded slash is kept while normalizing", input: "/x/../@scope%2Fpkg", expectedEscaped: "/@scope%2Fpkg", expectedSegments: []string{"@scope", "pkg"}, expectedChanged: true},
+		{name: "backslash without dot segments", input: "/a%5Cb", expectedEscaped: "/a%5Cb", expectedSegments: []string{`a\b`}},
+
+		// Ambiguous
+		{name: "traversal behind encoded slash", input: "/files/..%2F..%2Fadmin", expectedError: ErrAmbiguousPath},
+		{name: "traversal behind encoded backslash", input: "/files/..%5Cadmin", expectedError: ErrAmbiguousPath},
+		{name: "dot segment behind encoded slash", input: "/files/a%2F.", expectedError: ErrAmbiguousPath},
+		{name: "empty segment behind encoded slash", input: "/files/a%2F%2Fb", expectedError: ErrAmbiguousPath},
+		{name: "leading encoded slash", input: "/%2Fadmin", expectedError: ErrAmbiguousPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := CanonicalizePath(tt.input)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedEscaped, path.Escaped)
+			require.Equal(t, tt.expectedSegments, path.Segments)
+			require.Equal(t, tt.expectedChanged, path.Changed)
+		})
+	}
+}
+
+func TestCanonicalizePathInvalidEncoding(t *testing.T) {
+	_, err := CanonicalizePath("/a/%zz")
+	require.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package rulesengine
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Preset is a curated, named set of rules for a common ecosystem. A rule of just `preset=npm` expands to the
+// rules of the npm preset, and `preset=npm@1` additionally checks that the preset is still at version 1.
+type Preset struct {
+	Name        string
+	Description string
+	// Version is bumped whenever Rules change, so policies can pin the rules they were reviewed against.
+	Version int
+	Rules   []string
+}
+
+// presets are compiled into the binary, so a given boundary version always expands a preset the same way. Keep
+// them sorted by name, keep the rules as narrow as the tools allow (registries the tools only read from are
+// limited to GET and HEAD), and bump Version on every change to Rules.
+var presets = []Preset{
+	{
+		Name:        "anthropic",
+		Description: "Anthropic API",
+		Version:     1,
+		Rules: []string{
+			"domain=api.anthropic.com",
+		},
+	},
+	{
+		Name:        "crates",
+		Description: "crates.io registry, sparse index and crate downloads for cargo",
+		Version:     1,
+		Rules: []string{
+			"method=GET,HEAD domain=crates.io",
+			"method=GET,HEAD domain=index.crates.io",
+			"method=GET,HEAD domain=static.crates.io",
+		},
+	},
+	{
+		Name:        "docker",
+		Description: "Docker Hub image pulls",
+		Version:     1,
+		Rules: []string{
+			"method=GET,HEAD domain=registry-1.docker.io",
+			"method=GET,HEAD domain=index.docker.io",
+			"domain=auth.docker.io",
+			"method=GET,HEAD domain=production.cloudflare.docker.com",
+		},
+	},
+	{
+		Name:        "gemini",
+		Description: "Google Gemini API",
+		Version:     1,
+		Rules: []string{
+			"domain=generativelanguage.googleapis.com",
+		},
+	},
+	{
+		Name:        "github",
+		Description: "GitHub web, API, git over HTTPS, raw content and release downloads",
+		Version:     1,
+		Rules: []string{
+			"domain=github.com",
+			"domain=*.github.com",
+			"domain=*.githubusercontent.com",
+		},
+	},
+	{
+		Name:        "go",
+		Description: "Go module proxy and checksum database",
+		Version:     1,
+		Rules: []string{
+			"method=GET,HEAD domain=proxy.golang.org",
+			"method=GET,HEAD domain=sum.golang.org",
+		},
+	},
+	{
+		Name:        "npm",
+		Description: "npm registry, as used by npm, yarn and pnpm",
+		Version:     1,
+		Rules: []string{
+			"domain=registry.npmjs.org",
+			"domain=registry.yarnpkg.com",
+		},
+	},
+	{
+		Name:        "openai",
+		Description: "OpenAI API",
+		Version:     1,
+		Rules: []string{
+			"domain=api.openai.com",
+		},
+	},
+	{
+		Name:        "pypi",
+		Description: "Python Package Index and package downloads for pip, uv and poetry",
+		Version:     1,
+		Rules: []string{
+			"method=GET,HEAD domain=pypi.org",
+			"method=GET,HEAD domain=files.pythonhosted.org",
+		},
+	},
+}
+
+// Presets returns every built-in preset, sorted by name.
+func Presets() []Preset {
+	return slices.Clone(presets)
+}
+
+// LookupPreset returns the built-in preset with the given name.
+func LookupPreset(name string) (Preset, bool) {
+	i := slices.IndexFunc(presets, func(p Preset) bool { return p.Name == name })
+	if i < 0 {
+		return Preset{}, false
+	}
+	return presets[i], true
+}
+
+// Expand parses the rules of the preset. Their Raw form names the preset, i.e. `preset=npm: domain=...`, so logs,
+// audit events and explanations show where a rule came from.
+func (p Preset) Expand() ([]Rule, error) {
+	rules := make([]Rule, 0, len(p.Rules))
+	for _, spec := range p.Rules {
+		r, err := parseAllowRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %v", p.Name, err)
+		}
+		r.Raw = "preset=" + p.Name + ": " + spec
+		r.Preset = p.Name
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// parsePresetReference parses the value of a `preset=` key: a preset name, optionally followed by `@version`.
+func parsePresetReference(input string) (string, string, error) {
+	var i int
+	for i = 0; i < len(input) && isPresetReferenceChar(input[i]); i++ {
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("expected preset name, got: %s", input)
+	}
+	return input[:i], input[i:], nil
+}
+
+func isPresetReferenceChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '@'
+}
+
+// expandPresetReference returns the rules of the preset a `preset=` key refers to.
+func expandPresetReference(reference string) ([]Rule, error) {
+	name, version, pinned := strings.Cut(reference, "@")
+
+	preset, ok := LookupPreset(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown preset: %s (see `boundary presets list`)", name)
+	}
+
+	if pinned {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset version: %s", version)
+		}
+		if v != preset.Version {
+			return nil, fmt.Errorf("preset %s is at version %d, not %d; review `boundary presets show %s` and update the pin", name, preset.Version, v, name)
+		}
+	}
+
+	return preset.Expand()
+}
@@ -0,0 +1,96 @@
+package rulesengine
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresetsAreValid(t *testing.T) {
+	all := Presets()
+	require.NotEmpty(t, all)
+	require.True(t, slices.IsSortedFunc(all, func(a, b Preset) int { return strings.Compare(a.Name, b.Name) }), "presets must be sorted by name")
+
+	for i, p := range all {
+		t.Run(p.Name, func(t *testing.T) {
+			if i > 0 {
+				require.NotEqual(t, all[i-1].Name, p.Name, "duplicate preset")
+			}
+			_, rest, err := parsePresetReference(p.Name)
+			require.NoError(t, err)
+			require.Empty(t, rest, "preset names must be valid references")
+			require.NotContains(t, p.Name, "@")
+			require.NotEmpty(t, p.Description)
+			require.GreaterOrEqual(t, p.Version, 1)
+			require.NotEmpty(t, p.Rules)
+
+			rules, err := p.Expand()
+			require.NoError(t, err)
+			require.Len(t, rules, len(p.Rules))
+
+			// Presets are curated: they shouldn't trip the linter on their own.
+			for _, f := range Lint(rules) {
+				require.Less(t, f.Severity, SeverityWarning, "%s", f)
+			}
+		})
+	}
+}
+
+func TestPresetSpecs(t *testing.T) {
+	npm, ok := LookupPreset("npm")
+	require.True(t, ok)
+
+	rules, err := ParseAllowSpecs([]string{"domain=example.com", "preset=npm"})
+	require.NoError(t, err)
+	require.Len(t, rules, 1+len(npm.Rules))
+	require.Equal(t, "domain=example.com", rules[0].Raw)
+	require.Empty(t, rules[0].Preset)
+	for i, spec := range npm.Rules {
+		require.Equal(t, "preset=npm: "+spec, rules[1+i].Raw)
+		require.Equal(t, "npm", rules[1+i].Preset)
+		require.False(t, rules[1+i].Deny)
+	}
+
+	rules, err = ParseAllowSpecs([]string{"preset=npm@1 "})
+	require.NoError(t, err)
+	require.Len(t, rules, len(npm.Rules))
+
+	rules, err = ParseDenySpecs([]string{"preset=openai"})
+	require.NoError(t, err)
+	require.NotEmpty(t, rules)
+	for _, r := range rules {
+		require.True(t, r.Deny)
+		require.Equal(t, "openai", r.Preset)
+	}
+
+	invalid := map[string]string{
+		"preset=nope":              "unknown preset: nope",
+		"preset=npm@2":             "preset npm is at version 1, not 2",
+		"preset=npm@x":             "invalid preset version: x",
+		"preset=":                  "expected preset name",
+		"preset=npm domain=x.com":  "preset must be the only key of a rule",
+		"domain=x.com preset=npm":  "preset must be the only key of a rule",
+		"preset=NPM":               "expected preset name",
+		"preset=npm,github":        "preset must be the only key of a rule",
+		"method=GET preset=github": "preset must be the only key of a rule",
+	}
+	for spec, want := range invalid {
+		t.Run(spec, func(t *testing.T) {
+			_, err := ParseAllowSpecs([]string{spec})
+			require.ErrorContains(t, err, want)
+		})
+	}
+}
+
+func TestPresetResultNamesPreset(t *testing.T) {
+	rules, err := ParseAllowSpecs([]string{"preset=github"})
+	require.NoError(t, err)
+
+	engine := NewRuleEngine(rules, slog.Default())
+	result := engine.Evaluate("GET", "https://raw.githubusercontent.com/coder/boundary/main/README.md")
+	require.True(t, result.Allowed)
+	require.Equal(t, "preset=github: domain=*.githubusercontent.com", result.Rule)
+}
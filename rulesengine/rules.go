@@ -3,23 +3,39 @@ package rulesengine
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/netip"
+	neturl "net/url"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
-// Rule represents an allow rule passed to the cli with --allow or read from the config file.
+// Rule represents an allow or deny rule passed to the cli with --allow/--deny or read from the config file.
 // Rules have a specific grammar that we need to parse carefully.
 // Example: --allow="method=GET,PATCH domain=wibble.wobble.com, path=/posts/*"
 type Rule struct {
 
+	// Deny marks this rule as a deny rule. A request matching any deny rule is
+	// blocked, even if an allow rule matches it as well.
+	Deny bool
+
 	// The path patterns that can match for this rule.
 	// - nil means all paths allowed
 	// - Each []string represents a path pattern (list of segments)
 	// - a path segment of `*` acts as a wild card.
+	// - an `*` inside a segment (e.g. `v*`) matches any run of characters within that one segment.
+	// - a path segment of `**` matches zero or more segments, anywhere in the pattern.
 	PathPattern [][]string
 
 	// The labels of the host, i.e. ["google", "com"].
 	// - nil means all hosts allowed
 	// - A label of `*` acts as a wild card.
+	// - An `*` inside a label (e.g. `api-*`) matches any run of characters within that one label.
+	// - The final label never contains an `*`.
 	// - Exact domain patterns (e.g., "github.com") match ONLY the exact domain (no subdomains)
 	// - Wildcard patterns starting with "*" (e.g., "*.github.com") match ONLY subdomains (not the base domain)
 	HostPattern []string
@@ -28,28 +44,168 @@ type Rule struct {
 	// - nil means all methods allowed
 	MethodPatterns map[string]struct{}
 
+	// The allowed URL schemes, lowercased, i.e. {"https"}.
+	// - nil means all schemes allowed
+	SchemePatterns map[string]struct{}
+
+	// The allowed destination addresses, from `ip=` keys. A bare address is stored as a single-address prefix.
+	// - nil means all destinations allowed
+	// - The destination is the IP-literal host of the URL or, when the host is a name, the address the
+	//   client originally connected to (transparent mode only). Requests with neither never match.
+	IPPatterns []netip.Prefix
+
+	// The allowed destination ports. A request without an explicit port is on its scheme's default port.
+	// - nil means all ports allowed
+	PortPatterns map[int]struct{}
+
+	// The query parameter patterns, from repeated `query=name=pattern` keys.
+	// - nil means any query string is allowed
+	// - Every pattern must match for the rule to match.
+	QueryPatterns []QueryPattern
+
+	// The header patterns, from repeated `header=...` keys.
+	// - nil means any headers are allowed
+	// - Every pattern must match for the rule to match.
+	HeaderPatterns []HeaderPattern
+
 	// Raw rule string for logging
 	Raw string
+
+	// Preset is the name of the preset the rule was expanded from, empty for rules written out in full.
+	Preset string
+
+	// ID, Description and Tags are metadata of rules compiled from a StructuredRule. ID and Tags are reported
+	// with every decision the rule makes.
+	ID          string
+	Description string
+	Tags        []string
+
+	// From and Until bound when the rule matches, from `from=` and `until=` keys. From is inclusive, Until
+	// exclusive.
+	// - The zero value means no bound.
+	// - Durations like `until=2h` are resolved when the rule is parsed.
+	From  time.Time
+	Until time.Time
+
+	// Limit caps how many requests the rule allows, from `rate=`, `quota=` and `per=` keys. The engine only
+	// reports it with the result; the proxy keeps the counts and enforces it.
+	// - The zero value means no limit.
+	Limit Limit
+
+	// Passthrough marks a rule with `inspect=false`, which allows TLS connections to be passed through without
+	// being decrypted, for clients that pin certificates or use mTLS. See Engine.EvaluateConnection.
+	// - HostPattern comes from an `sni=` key and is matched against the server name of the TLS ClientHello.
+	// - Only sni, ip, port, from and until keys can be used, since the requests aren't seen.
+	// - Passthrough rules never match requests.
+	Passthrough bool
 }
 
-// ParseAllowSpecs parses a slice of --allow specs into allow Rules.
+// Limit caps the requests an allow rule lets through.
+// - `rate=30/m` allows 30 requests per minute, in bursts of up to 30. The units are s, m and h.
+// - `quota=500` allows 500 requests in total, for as long as boundary runs.
+// - `per=host` counts separately for every host the rule matches. The default, `per=rule`, counts them together.
+type Limit struct {
+	Rate     int
+	Interval time.Duration
+	Quota    int
+	PerHost  bool
+}
+
+// IsZero reports whether the limit doesn't limit anything.
+func (l Limit) IsZero() bool {
+	return l.Rate == 0 && l.Quota == 0
+}
+
+// QueryPattern constrains a single query parameter.
+// The parameter must be present in the request and every one of its values must match Value.
+// - Name and Value are stored percent-decoded.
+// - A `*` in Value acts as a wild card matching any run of characters.
+type QueryPattern struct {
+	Name  string
+	Value string
+}
+
+// HeaderPattern constrains a single request header.
+// - `header=Name` requires the header to be present with any value.
+// - `header=Name:pattern` requires the header to be present and every one of its values to match Value.
+// - `header=!Name` requires the header to be absent.
+// - A `*` in Value acts as a wild card matching any run of characters.
+type HeaderPattern struct {
+	// Name is stored in canonical form (see http.CanonicalHeaderKey).
+	Name string
+	// Value is empty when any value is accepted (HasValue is false).
+	Value    string
+	HasValue bool
+	Absent   bool
+}
+
+// ParseAllowSpecs parses a slice of --allow specs into allow Rules. A `preset=` spec expands to the rules of
+// the preset.
 func ParseAllowSpecs(allowStrings []string) ([]Rule, error) {
 	var out []Rule
 	for _, s := range allowStrings {
-		r, err := parseAllowRule(s)
+		rules, err := parseSpec(s)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse allow '%s': %v", s, err)
 		}
-		out = append(out, r)
+		out = append(out, rules...)
 	}
 	return out, nil
 }
 
-// parseAllowRule takes an allow rule string and tries to parse it as a rule.
+// ParseDenySpecs parses a slice of --deny specs into deny Rules. Deny specs use
+// the same grammar as allow specs.
+func ParseDenySpecs(denyStrings []string) ([]Rule, error) {
+	var out []Rule
+	for _, s := range denyStrings {
+		rules, err := parseSpec(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse deny '%s': %v", s, err)
+		}
+		for _, r := range rules {
+			if !r.Limit.IsZero() {
+				return nil, fmt.Errorf("failed to parse deny '%s': rate and quota only apply to allow rules", s)
+			}
+			if r.Passthrough {
+				return nil, fmt.Errorf("failed to parse deny '%s': inspect=false only applies to allow rules", s)
+			}
+			r.Deny = true
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// parseSpec parses a single spec, which is either a rule or a reference to a preset.
+func parseSpec(spec string) ([]Rule, error) {
+	rest, found := strings.CutPrefix(spec, "preset=")
+	if !found {
+		r, err := parseAllowRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []Rule{r}, nil
+	}
+
+	reference, rest, err := parsePresetReference(rest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse preset: %v", err)
+	}
+	// A preset stands for several rules, so other keys couldn't be added to all of them in a meaningful way.
+	if strings.TrimRight(rest, " \t\n") != "" {
+		return nil, errors.New("preset must be the only key of a rule")
+	}
+	return expandPresetReference(reference)
+}
+
+// parseAllowRule takes a rule string and tries to parse it as a rule. The returned
+// rule is an allow rule; callers parsing deny specs flip Rule.Deny afterwards.
 func parseAllowRule(ruleStr string) (Rule, error) {
 	rule := Rule{
 		Raw: ruleStr,
 	}
+	// Relative times like `until=2h` count from now.
+	now := time.Now()
 
 	// Functions called by this function used a really common pattern: recursive descent parsing.
 	// All the helper functions for parsing an allow rule will be called like `thing, rest, err := parseThing(rest)`.
@@ -58,6 +214,8 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 	rest := ruleStr
 	var key string
 	var err error
+	// Which of the keys only passthrough rules can or can't have were given, to check them once they're all parsed.
+	var hasDomain, hasSNI, inspectsRequests bool
 
 	// Ann allow rule can have as many key=value pairs as needed, we go until there's no more text in the rule.
 	for rest != "" {
@@ -68,6 +226,11 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 		}
 
 		// Parse the value based on the key type
+		switch key {
+		case "method", "scheme", "path", "query", "header", "rate", "quota":
+			inspectsRequests = true
+		}
+
 		switch key {
 		case "method":
 			// Initialize Methods map if needed
@@ -93,6 +256,71 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 				break
 			}
 
+		case "scheme":
+			if rule.SchemePatterns == nil {
+				rule.SchemePatterns = make(map[string]struct{})
+			}
+
+			var scheme string
+			for {
+				scheme, rest, err = parseSchemePattern(rest)
+				if err != nil {
+					return Rule{}, fmt.Errorf("failed to parse scheme: %v", err)
+				}
+
+				rule.SchemePatterns[scheme] = struct{}{}
+
+				// Check if there's a comma for more schemes
+				if rest != "" && rest[0] == ',' {
+					rest = rest[1:] // Skip the comma
+					continue
+				}
+
+				break
+			}
+
+		case "port":
+			if rule.PortPatterns == nil {
+				rule.PortPatterns = make(map[int]struct{})
+			}
+
+			var port int
+			for {
+				port, rest, err = parsePortPattern(rest)
+				if err != nil {
+					return Rule{}, fmt.Errorf("failed to parse port: %v", err)
+				}
+
+				rule.PortPatterns[port] = struct{}{}
+
+				// Check if there's a comma for more ports
+				if rest != "" && rest[0] == ',' {
+					rest = rest[1:] // Skip the comma
+					continue
+				}
+
+				break
+			}
+
+		case "ip":
+			var prefix netip.Prefix
+			for {
+				prefix, rest, err = parseIPPattern(rest)
+				if err != nil {
+					return Rule{}, fmt.Errorf("failed to parse ip: %v", err)
+				}
+
+				rule.IPPatterns = append(rule.IPPatterns, prefix)
+
+				// Check if there's a comma for more addresses
+				if rest != "" && rest[0] == ',' {
+					rest = rest[1:] // Skip the comma
+					continue
+				}
+
+				break
+			}
+
 		case "domain":
 			var host []string
 			host, rest, err = parseHostPattern(rest)
@@ -102,6 +330,30 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 
 			// Convert labels to strings
 			rule.HostPattern = append(rule.HostPattern, host...)
+			hasDomain = true
+
+		case "sni":
+			var host []string
+			host, rest, err = parseHostPattern(rest)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse sni: %v", err)
+			}
+
+			// The server name is matched like a request host, so it shares the host pattern.
+			rule.HostPattern = append(rule.HostPattern, host...)
+			hasSNI = true
+
+		case "inspect":
+			var inspect string
+			inspect, rest = parseWord(rest)
+			switch inspect {
+			case "true":
+				rule.Passthrough = false
+			case "false":
+				rule.Passthrough = true
+			default:
+				return Rule{}, fmt.Errorf("inspect must be true or false, got: %s", inspect)
+			}
 
 		case "path":
 			for {
@@ -123,6 +375,71 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 				break
 			}
 
+		case "query":
+			var query QueryPattern
+			query, rest, err = parseQueryPattern(rest)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse query: %v", err)
+			}
+
+			rule.QueryPatterns = append(rule.QueryPatterns, query)
+
+		case "header":
+			var header HeaderPattern
+			header, rest, err = parseHeaderPattern(rest)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse header: %v", err)
+			}
+
+			rule.HeaderPatterns = append(rule.HeaderPatterns, header)
+
+		case "from", "until":
+			bound := &rule.From
+			if key == "until" {
+				bound = &rule.Until
+			}
+			if !bound.IsZero() {
+				return Rule{}, fmt.Errorf("%s can only be given once", key)
+			}
+			*bound, rest, err = parseTimePattern(rest, now)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse %s: %v", key, err)
+			}
+
+		case "rate":
+			if rule.Limit.Rate != 0 {
+				return Rule{}, errors.New("rate can only be given once")
+			}
+			rule.Limit.Rate, rule.Limit.Interval, rest, err = parseRatePattern(rest)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse rate: %v", err)
+			}
+
+		case "quota":
+			if rule.Limit.Quota != 0 {
+				return Rule{}, errors.New("quota can only be given once")
+			}
+			rule.Limit.Quota, rest, err = parseCount(rest)
+			if err != nil {
+				return Rule{}, fmt.Errorf("failed to parse quota: %v", err)
+			}
+
+		case "per":
+			var per string
+			per, rest = parseWord(rest)
+			switch per {
+			case "host":
+				rule.Limit.PerHost = true
+			case "rule":
+				rule.Limit.PerHost = false
+			default:
+				return Rule{}, fmt.Errorf("per must be host or rule, got: %s", per)
+			}
+
+		case "preset":
+			// A rule that is just a preset reference is expanded by parseSpec before getting here.
+			return Rule{}, errors.New("preset must be the only key of a rule")
+
 		default:
 			return Rule{}, fmt.Errorf("unknown key: %s", key)
 		}
@@ -133,6 +450,22 @@ func parseAllowRule(ruleStr string) (Rule, error) {
 		}
 	}
 
+	if !rule.From.IsZero() && !rule.Until.IsZero() && !rule.Until.After(rule.From) {
+		return Rule{}, errors.New("until must be after from")
+	}
+	if rule.Limit.PerHost && rule.Limit.IsZero() {
+		return Rule{}, errors.New("per needs a rate or quota")
+	}
+	if hasSNI && hasDomain {
+		return Rule{}, errors.New("sni and domain can't be used together")
+	}
+	if hasSNI != rule.Passthrough {
+		return Rule{}, errors.New("sni and inspect=false must be used together")
+	}
+	if rule.Passthrough && inspectsRequests {
+		return Rule{}, errors.New("inspect=false rules can only have sni, ip, port, from and until keys, since the requests aren't seen")
+	}
+
 	return rule, nil
 }
 
@@ -177,6 +510,146 @@ func isHTTPTokenChar(c byte) bool {
 	}
 }
 
+// Represents a URL scheme, returned lowercased since schemes are case-insensitive.
+// scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+// https://datatracker.ietf.org/doc/html/rfc3986#section-3.1
+func parseSchemePattern(input string) (string, string, error) {
+	if input == "" {
+		return "", "", errors.New("expected scheme, got empty string")
+	}
+
+	c := input[0]
+	if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')) {
+		return "", "", fmt.Errorf("scheme must start with a letter: %s", input)
+	}
+
+	var i int
+	for i = 1; i < len(input); i++ {
+		c = input[i]
+		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '+' || c == '-' || c == '.') {
+			break
+		}
+	}
+
+	return strings.ToLower(input[:i]), input[i:], nil
+}
+
+// Represents a TCP port number between 1 and 65535.
+func parsePortPattern(input string) (int, string, error) {
+	var i int
+	for i = 0; i < len(input) && input[i] >= '0' && input[i] <= '9'; i++ {
+	}
+	if i == 0 {
+		return 0, "", fmt.Errorf("expected port number, got: %s", input)
+	}
+
+	port, err := strconv.Atoi(input[:i])
+	if err != nil || port < 1 || port > 65535 {
+		return 0, "", fmt.Errorf("port must be between 1 and 65535, got: %s", input[:i])
+	}
+
+	return port, input[i:], nil
+}
+
+// Represents a point in time for `from=` and `until=`: an RFC 3339 timestamp like `2026-10-16T17:00:00Z`, or a
+// positive duration like `90m` or `2h30m`, counted from now.
+func parseTimePattern(input string, now time.Time) (time.Time, string, error) {
+	token, rest := parseWord(input)
+
+	if t, err := time.Parse(time.RFC3339, token); err == nil {
+		return t, rest, nil
+	}
+	d, err := time.ParseDuration(token)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("expected RFC 3339 time or duration, got: %s", token)
+	}
+	if d <= 0 {
+		return time.Time{}, "", fmt.Errorf("duration must be positive, got: %s", token)
+	}
+	return now.Add(d), rest, nil
+}
+
+// parseWord splits off everything up to the next whitespace, for values with a grammar of their own.
+func parseWord(input string) (string, string) {
+	i := strings.IndexAny(input, " \t\n")
+	if i < 0 {
+		return input, ""
+	}
+	return input[:i], input[i:]
+}
+
+// Represents a rate like `30/m`: a number of requests per second (s), minute (m) or hour (h).
+func parseRatePattern(input string) (int, time.Duration, string, error) {
+	count, rest, err := parseCount(input)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	rest, found := strings.CutPrefix(rest, "/")
+	if !found || rest == "" {
+		return 0, 0, "", fmt.Errorf("expected /s, /m or /h after %d", count)
+	}
+
+	var interval time.Duration
+	switch rest[0] {
+	case 's':
+		interval = time.Second
+	case 'm':
+		interval = time.Minute
+	case 'h':
+		interval = time.Hour
+	default:
+		return 0, 0, "", fmt.Errorf("expected /s, /m or /h after %d", count)
+	}
+	return count, interval, rest[1:], nil
+}
+
+// Represents a positive number of requests.
+func parseCount(input string) (int, string, error) {
+	var i int
+	for i = 0; i < len(input) && input[i] >= '0' && input[i] <= '9'; i++ {
+	}
+	if i == 0 {
+		return 0, "", fmt.Errorf("expected a number, got: %s", input)
+	}
+	count, err := strconv.Atoi(input[:i])
+	if err != nil || count < 1 {
+		return 0, "", fmt.Errorf("expected a positive number, got: %s", input[:i])
+	}
+	return count, input[i:], nil
+}
+
+// Represents an IPv4 or IPv6 address or CIDR prefix, i.e. `10.0.0.0/8`, `10.20.0.5` or `fd00::/8`.
+// A bare address becomes a single-address prefix. Host bits set in a prefix are masked off, so
+// `10.1.2.3/8` is the same as `10.0.0.0/8`.
+func parseIPPattern(input string) (netip.Prefix, string, error) {
+	var i int
+	for i = 0; i < len(input) && isIPPatternChar(input[i]); i++ {
+	}
+	if i == 0 {
+		return netip.Prefix{}, "", fmt.Errorf("expected IP address or CIDR prefix, got: %s", input)
+	}
+	token := input[:i]
+
+	if strings.Contains(token, "/") {
+		prefix, err := netip.ParsePrefix(token)
+		if err != nil {
+			return netip.Prefix{}, "", fmt.Errorf("invalid CIDR prefix %s: %v", token, err)
+		}
+		return prefix.Masked(), input[i:], nil
+	}
+
+	addr, err := netip.ParseAddr(token)
+	if err != nil {
+		return netip.Prefix{}, "", fmt.Errorf("invalid IP address %s: %v", token, err)
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), input[i:], nil
+}
+
+func isIPPatternChar(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == '.' || c == ':' || c == '/'
+}
+
 // Represents a valid host.
 // https://datatracker.ietf.org/doc/html/rfc952
 // https://datatracker.ietf.org/doc/html/rfc1123#page-13
@@ -205,6 +678,11 @@ func parseHostPattern(input string) ([]string, string, error) {
 			break
 		}
 
+		// A fully qualified name like `github.com.` ends with a dot. It names the same host, so the dot is dropped.
+		if rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' {
+			break
+		}
+
 		label, rest, err = parseLabelPattern(rest)
 		if err != nil {
 			return nil, "", err
@@ -212,13 +690,22 @@ func parseHostPattern(input string) ([]string, string, error) {
 		host = append(host, label)
 	}
 
+	// Patterns are stored in the same canonical form as request hosts, see CanonicalHost.
+	for i, label := range host {
+		host[i], err = canonicalLabelPattern(label)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
 	// If the host is a single standalone asterisk, that's the same as "matches anything"
 	if len(host) == 1 && host[0] == "*" {
 		return host, rest, nil
 	}
 
-	// Validate: host patterns other than a single `*` cannot end with asterisk
-	if len(host) > 0 && host[len(host)-1] == "*" {
+	// Validate: host patterns other than a single `*` cannot end with asterisk. This covers partial globs too,
+	// since a pattern like `example.c*` would match arbitrary top level domains.
+	if len(host) > 0 && strings.Contains(host[len(host)-1], "*") {
 		return nil, "", errors.New("host patterns cannot end with asterisk")
 	}
 
@@ -230,19 +717,15 @@ func parseLabelPattern(rest string) (string, string, error) {
 		return "", "", errors.New("expected label, got empty string")
 	}
 
-	// If the label is simply an asterisk, good to go.
-	if rest[0] == '*' {
-		return "*", rest[1:], nil
-	}
-
 	// First try to get a valid leading char. Leading char in a label cannot be a hyphen.
-	if !isValidLabelChar(rest[0]) || rest[0] == '-' {
+	if !isValidLabelPatternChar(rest[0]) || rest[0] == '-' {
 		return "", "", fmt.Errorf("could not pull label from front of string: %s", rest)
 	}
 
-	// Go until the next character is not a valid char
+	// Go until the next character is not a valid char. An `*` can appear anywhere in the label and acts as a
+	// glob within the label, e.g. `api-*` or `*-staging`.
 	var i int
-	for i = 1; i < len(rest) && isValidLabelChar(rest[i]); i += 1 {
+	for i = 1; i < len(rest) && isValidLabelPatternChar(rest[i]); i += 1 {
 	}
 
 	// Final char in a label cannot be a hyphen.
@@ -253,6 +736,30 @@ func parseLabelPattern(rest string) (string, string, error) {
 	return rest[:i], rest[i:], nil
 }
 
+// canonicalLabelPattern lowercases a label pattern and converts an internationalized label to punycode, so that
+// `GitHub` matches `github` and `bücher` matches `xn--bcher-kva`.
+func canonicalLabelPattern(label string) (string, error) {
+	if isASCII(label) {
+		return strings.ToLower(label), nil
+	}
+
+	// Punycode encodes a label as a whole, so a glob inside an internationalized label has no punycode form.
+	if strings.Contains(label, "*") {
+		return "", fmt.Errorf("internationalized labels cannot contain asterisk: %s", label)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(label)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized label %s: %v", label, err)
+	}
+	return ascii, nil
+}
+
+func isValidLabelPatternChar(c byte) bool {
+	// Bytes of non-ASCII characters are accepted for internationalized labels; canonicalLabelPattern validates them.
+	return isValidLabelChar(c) || c == '*' || c >= utf8.RuneSelf
+}
+
 func isValidLabelChar(c byte) bool {
 	switch {
 	// Alpha numeric is fine.
@@ -309,7 +816,15 @@ func parsePathPattern(input string) ([]string, string, error) {
 			break
 		}
 
-		segments = append(segments, segment)
+		// A `**` segment matches any number of segments, so `**` inside a segment would be ambiguous.
+		if segment != "**" && strings.Contains(segment, "**") {
+			return nil, "", fmt.Errorf("recursive wildcard '**' must be an entire path segment, got: %s", segment)
+		}
+
+		// Consecutive `**` segments match exactly what a single one does, so keep only the first.
+		if segment != "**" || len(segments) == 0 || segments[len(segments)-1] != "**" {
+			segments = append(segments, segment)
+		}
 
 		// If there's no slash after the segment, we're done parsing the path
 		if rest == "" || rest[0] != '/' {
@@ -325,14 +840,8 @@ func parsePathSegmentPattern(input string) (string, string, error) {
 		return "", "", nil
 	}
 
-	if len(input) > 0 && input[0] == '*' {
-		if len(input) > 1 && !isPathSegmentTerminator(input[1]) {
-			return "", "", fmt.Errorf("path segment wildcards must be for the entire segment, got: %s", input)
-		}
-
-		return "*", input[1:], nil
-	}
-
+	// An `*` is a valid pchar, so it is consumed by the loop below. A segment of just `*` is a segment wildcard,
+	// and an `*` inside a segment (`v*`, `*.tar.gz`) acts as a glob within that segment.
 	var i int
 	for i = 0; i < len(input); i++ {
 		c := input[i]
@@ -355,11 +864,112 @@ func parsePathSegmentPattern(input string) (string, string, error) {
 	return input[:i], input[i:], nil
 }
 
-// isPathSegmentTerminator returns true if the character terminates a path segment
-// in our rule grammar: '/' separates segments, ',' separates paths, and
-// whitespace separates key=value pairs.
-func isPathSegmentTerminator(c byte) bool {
-	return c == '/' || c == ',' || c == ' ' || c == '\t' || c == '\n'
+// parseQueryPattern parses a `name=pattern` query parameter constraint. The name runs until the `=`, the value
+// until the next whitespace separator. Both may be percent-encoded.
+func parseQueryPattern(input string) (QueryPattern, string, error) {
+	name, rest, err := parseQueryComponent(input, false)
+	if err != nil {
+		return QueryPattern{}, "", err
+	}
+	if name == "" {
+		return QueryPattern{}, "", fmt.Errorf("expected query parameter name, got: %s", input)
+	}
+
+	rest, found := strings.CutPrefix(rest, "=")
+	if !found {
+		return QueryPattern{}, "", fmt.Errorf("expected '=' after query parameter name %q", name)
+	}
+
+	var value string
+	value, rest, err = parseQueryComponent(rest, true)
+	if err != nil {
+		return QueryPattern{}, "", err
+	}
+
+	return QueryPattern{Name: name, Value: value}, rest, nil
+}
+
+// parseQueryComponent pulls a query parameter name or value off the front of the input and returns it decoded.
+func parseQueryComponent(input string, isValue bool) (string, string, error) {
+	var i int
+	for i = 0; i < len(input); i++ {
+		c := input[i]
+
+		// Check for percent-encoded characters (%XX)
+		if c == '%' {
+			if i+2 >= len(input) || !isHexDigit(input[i+1]) || !isHexDigit(input[i+2]) {
+				return "", "", fmt.Errorf("invalid percent-encoding in query: %s", input)
+			}
+			i += 2
+			continue
+		}
+
+		// `=` separates the name from the value, but is allowed inside values.
+		if c == '=' && !isValue {
+			break
+		}
+
+		if !isQueryChar(c) {
+			break
+		}
+	}
+
+	decoded, err := neturl.QueryUnescape(input[:i])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid query component %q: %v", input[:i], err)
+	}
+
+	return decoded, input[i:], nil
+}
+
+// isQueryChar returns true if the character can appear in a query name or value in our rule grammar.
+// query = *( pchar / "/" / "?" ), minus `&` which separates parameters.
+func isQueryChar(c byte) bool {
+	return (isPChar(c) || c == ',' || c == '/' || c == '?') && c != '&'
+}
+
+// parseHeaderPattern parses a `Name`, `Name:pattern` or `!Name` header constraint.
+// Header names are http tokens: https://datatracker.ietf.org/doc/html/rfc7230#section-3.2
+func parseHeaderPattern(input string) (HeaderPattern, string, error) {
+	rest := input
+	var header HeaderPattern
+
+	rest, header.Absent = strings.CutPrefix(rest, "!")
+
+	var name string
+	var err error
+	name, rest, err = parseMethodPattern(rest)
+	if err != nil {
+		return HeaderPattern{}, "", err
+	}
+	if name == "" {
+		return HeaderPattern{}, "", fmt.Errorf("expected header name, got: %s", input)
+	}
+	header.Name = http.CanonicalHeaderKey(name)
+
+	var found bool
+	rest, found = strings.CutPrefix(rest, ":")
+	if !found {
+		return header, rest, nil
+	}
+	if header.Absent {
+		return HeaderPattern{}, "", fmt.Errorf("absent header %q cannot have a value pattern", name)
+	}
+
+	// Header values run until the next whitespace separator.
+	var i int
+	for i = 0; i < len(rest) && isHeaderValueChar(rest[i]); i++ {
+	}
+	header.Value = rest[:i]
+	header.HasValue = true
+
+	return header, rest[i:], nil
+}
+
+// isHeaderValueChar returns true if the character can appear in a header value pattern. Header values may
+// contain spaces, but in our rule grammar whitespace separates key=value pairs, so only visible characters are allowed.
+func isHeaderValueChar(c byte) bool {
+	return c > ' ' && c < 0x7f
 }
 
 // isUnreserved returns true if the character is unreserved per RFC 3986
@@ -399,7 +1009,7 @@ func parseKey(rule string) (string, string, error) {
 	}
 
 	// These are the current keys we support.
-	keys := []string{"method", "domain", "path"}
+	keys := []string{"method", "scheme", "port", "ip", "domain", "path", "query", "header", "from", "until", "rate", "quota", "per", "sni", "inspect", "preset"}
 
 	for _, key := range keys {
 		if rest, found := strings.CutPrefix(rule, key+"="); found {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"testing"
+	"time"
 )
 
 func TestParseHTTPToken(t *testing.T) {
@@ -243,9 +244,9 @@ func TestParseHost(t *testing.T) {
 		{
 			name:         "trailing dot",
 			input:        "example.com.",
-			expectedHost: nil,
+			expectedHost: []string{"example", "com"},
 			expectedRest: "",
-			expectError:  true,
+			expectError:  false,
 		},
 		{
 			name:         "single character labels",
@@ -257,7 +258,7 @@ func TestParseHost(t *testing.T) {
 		{
 			name:         "mixed case",
 			input:        "Example.COM",
-			expectedHost: []string{"Example", "COM"},
+			expectedHost: []string{"example", "com"},
 			expectedRest: "",
 			expectError:  false,
 		},
@@ -296,6 +297,76 @@ func TestParseHost(t *testing.T) {
 			expectedRest: "",
 			expectError:  true,
 		},
+		{
+			name:         "partial wildcard label",
+			input:        "api-*.example.com",
+			expectedHost: []string{"api-*", "example", "com"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "partial wildcard in final label - rejected",
+			input:        "example.c*",
+			expectedHost: nil,
+			expectedRest: "",
+			expectError:  true,
+		},
+		{
+			name:         "trailing dot before the next key",
+			input:        "example.com. path=/x",
+			expectedHost: []string{"example", "com"},
+			expectedRest: " path=/x",
+			expectError:  false,
+		},
+		{
+			name:         "mixed case wildcard",
+			input:        "*.GitHub.com",
+			expectedHost: []string{"*", "github", "com"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "internationalized label",
+			input:        "bücher.de",
+			expectedHost: []string{"xn--bcher-kva", "de"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "uppercase internationalized label",
+			input:        "BÜCHER.de",
+			expectedHost: []string{"xn--bcher-kva", "de"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "punycode label",
+			input:        "XN--BCHER-KVA.de",
+			expectedHost: []string{"xn--bcher-kva", "de"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "internationalized subdomain wildcard",
+			input:        "*.bücher.de",
+			expectedHost: []string{"*", "xn--bcher-kva", "de"},
+			expectedRest: "",
+			expectError:  false,
+		},
+		{
+			name:         "glob inside internationalized label - rejected",
+			input:        "bü*.de",
+			expectedHost: nil,
+			expectedRest: "",
+			expectError:  true,
+		},
+		{
+			name:         "invalid internationalized label - rejected",
+			input:        "a\u200db.de",
+			expectedHost: nil,
+			expectedRest: "",
+			expectError:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -459,6 +530,34 @@ func TestParseLabel(t *testing.T) {
 			expectedRest:  "/path",
 			expectError:   false,
 		},
+		{
+			name:          "trailing partial wildcard",
+			input:         "api-*.example.com",
+			expectedLabel: "api-*",
+			expectedRest:  ".example.com",
+			expectError:   false,
+		},
+		{
+			name:          "leading partial wildcard",
+			input:         "*-staging.example.com",
+			expectedLabel: "*-staging",
+			expectedRest:  ".example.com",
+			expectError:   false,
+		},
+		{
+			name:          "wildcard in the middle of a label",
+			input:         "eu*west",
+			expectedLabel: "eu*west",
+			expectedRest:  "",
+			expectError:   false,
+		},
+		{
+			name:          "partial wildcard ending in hyphen",
+			input:         "api*-",
+			expectedLabel: "",
+			expectedRest:  "",
+			expectError:   true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -644,11 +743,25 @@ func TestParsePathSegment(t *testing.T) {
 			expectError:     false,
 		},
 		{
-			name:            "invalid partial wildcard",
+			name:            "leading partial wildcard",
 			input:           "*abc",
-			expectedSegment: "",
+			expectedSegment: "*abc",
+			expectedRest:    "",
+			expectError:     false,
+		},
+		{
+			name:            "trailing partial wildcard",
+			input:           "v*/releases",
+			expectedSegment: "v*",
+			expectedRest:    "/releases",
+			expectError:     false,
+		},
+		{
+			name:            "wildcard inside segment",
+			input:           "archive-*.tar.gz",
+			expectedSegment: "archive-*.tar.gz",
 			expectedRest:    "",
-			expectError:     true,
+			expectError:     false,
 		},
 	}
 
@@ -841,6 +954,27 @@ func TestParsePath(t *testing.T) {
 			expectedRest:     "?limit=10",
 			expectError:      false,
 		},
+		{
+			name:             "recursive wildcard in the middle",
+			input:            "/api/**/comments",
+			expectedSegments: []string{"api", "**", "comments"},
+			expectedRest:     "",
+			expectError:      false,
+		},
+		{
+			name:             "consecutive recursive wildcards collapse",
+			input:            "/org/**/**/archive.tar.gz",
+			expectedSegments: []string{"org", "**", "archive.tar.gz"},
+			expectedRest:     "",
+			expectError:      false,
+		},
+		{
+			name:             "recursive wildcard inside a segment",
+			input:            "/api/v**/comments",
+			expectedSegments: nil,
+			expectedRest:     "",
+			expectError:      true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -877,6 +1011,366 @@ func TestParsePath(t *testing.T) {
 	}
 }
 
+func TestParseQueryPattern(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedQuery  QueryPattern
+		expectedRemain string
+		expectError    bool
+	}{
+		{
+			name:          "simple name and value",
+			input:         "repo=ours",
+			expectedQuery: QueryPattern{Name: "repo", Value: "ours"},
+		},
+		{
+			name:          "wildcard value with slash",
+			input:         "repo=ours/*",
+			expectedQuery: QueryPattern{Name: "repo", Value: "ours/*"},
+		},
+		{
+			name:          "empty value",
+			input:         "debug=",
+			expectedQuery: QueryPattern{Name: "debug", Value: ""},
+		},
+		{
+			name:          "value containing equals and comma",
+			input:         "filter=a=b,c",
+			expectedQuery: QueryPattern{Name: "filter", Value: "a=b,c"},
+		},
+		{
+			name:          "percent-encoded value is decoded",
+			input:         "q=hello%20world",
+			expectedQuery: QueryPattern{Name: "q", Value: "hello world"},
+		},
+		{
+			name:           "stops at whitespace",
+			input:          "repo=ours/* domain=github.com",
+			expectedQuery:  QueryPattern{Name: "repo", Value: "ours/*"},
+			expectedRemain: " domain=github.com",
+		},
+		{
+			name:        "missing name",
+			input:       "=value",
+			expectError: true,
+		},
+		{
+			name:        "missing equals",
+			input:       "repo",
+			expectError: true,
+		},
+		{
+			name:           "ampersand is not allowed",
+			input:          "a=1&b=2",
+			expectedQuery:  QueryPattern{Name: "a", Value: "1"},
+			expectedRemain: "&b=2",
+		},
+		{
+			name:        "invalid percent-encoding",
+			input:       "q=%zz",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, remain, err := parseQueryPattern(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if query != tt.expectedQuery {
+				t.Errorf("expected query %+v, got %+v", tt.expectedQuery, query)
+			}
+
+			if remain != tt.expectedRemain {
+				t.Errorf("expected remaining %q, got %q", tt.expectedRemain, remain)
+			}
+		})
+	}
+}
+
+func TestParseHeaderPattern(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedHeader HeaderPattern
+		expectedRemain string
+		expectError    bool
+	}{
+		{
+			name:           "present with any value",
+			input:          "Cookie",
+			expectedHeader: HeaderPattern{Name: "Cookie"},
+		},
+		{
+			name:           "name is canonicalized",
+			input:          "openai-organization:org-123",
+			expectedHeader: HeaderPattern{Name: "Openai-Organization", Value: "org-123", HasValue: true},
+		},
+		{
+			name:           "wildcard value",
+			input:          "Authorization:Bearer*",
+			expectedHeader: HeaderPattern{Name: "Authorization", Value: "Bearer*", HasValue: true},
+		},
+		{
+			name:           "empty value",
+			input:          "X-Debug:",
+			expectedHeader: HeaderPattern{Name: "X-Debug", Value: "", HasValue: true},
+		},
+		{
+			name:           "absent header",
+			input:          "!Cookie",
+			expectedHeader: HeaderPattern{Name: "Cookie", Absent: true},
+		},
+		{
+			name:           "stops at whitespace",
+			input:          "X-Org:acme domain=example.com",
+			expectedHeader: HeaderPattern{Name: "X-Org", Value: "acme", HasValue: true},
+			expectedRemain: " domain=example.com",
+		},
+		{
+			name:        "missing name",
+			input:       ":value",
+			expectError: true,
+		},
+		{
+			name:        "absent header with value",
+			input:       "!Cookie:session=*",
+			expectError: true,
+		},
+		{
+			name:        "empty string",
+			input:       "",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, remain, err := parseHeaderPattern(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if header != tt.expectedHeader {
+				t.Errorf("expected header %+v, got %+v", tt.expectedHeader, header)
+			}
+
+			if remain != tt.expectedRemain {
+				t.Errorf("expected remaining %q, got %q", tt.expectedRemain, remain)
+			}
+		})
+	}
+}
+
+func TestParseSchemeAndPort(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedScheme map[string]struct{}
+		expectedPort   map[int]struct{}
+		expectError    bool
+	}{
+		{
+			name:           "single scheme",
+			input:          "scheme=https",
+			expectedScheme: map[string]struct{}{"https": {}},
+		},
+		{
+			name:           "schemes are lowercased",
+			input:          "scheme=HTTP,https",
+			expectedScheme: map[string]struct{}{"http": {}, "https": {}},
+		},
+		{
+			name:         "single port",
+			input:        "port=443",
+			expectedPort: map[int]struct{}{443: {}},
+		},
+		{
+			name:         "multiple ports",
+			input:        "port=443,8443",
+			expectedPort: map[int]struct{}{443: {}, 8443: {}},
+		},
+		{
+			name:           "scheme and port with domain",
+			input:          "scheme=https port=8443 domain=internal.corp",
+			expectedScheme: map[string]struct{}{"https": {}},
+			expectedPort:   map[int]struct{}{8443: {}},
+		},
+		{
+			name:        "scheme must start with a letter",
+			input:       "scheme=1http",
+			expectError: true,
+		},
+		{
+			name:        "empty scheme",
+			input:       "scheme=",
+			expectError: true,
+		},
+		{
+			name:        "port zero",
+			input:       "port=0",
+			expectError: true,
+		},
+		{
+			name:        "port out of range",
+			input:       "port=65536",
+			expectError: true,
+		},
+		{
+			name:        "port is not a number",
+			input:       "port=https",
+			expectError: true,
+		},
+		{
+			name:        "trailing comma in port list",
+			input:       "port=443,",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseAllowRule(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(rule.SchemePatterns) != len(tt.expectedScheme) {
+				t.Errorf("expected SchemePatterns %v, got %v", tt.expectedScheme, rule.SchemePatterns)
+			}
+			for scheme := range tt.expectedScheme {
+				if _, ok := rule.SchemePatterns[scheme]; !ok {
+					t.Errorf("expected scheme %q in %v", scheme, rule.SchemePatterns)
+				}
+			}
+
+			if len(rule.PortPatterns) != len(tt.expectedPort) {
+				t.Errorf("expected PortPatterns %v, got %v", tt.expectedPort, rule.PortPatterns)
+			}
+			for port := range tt.expectedPort {
+				if _, ok := rule.PortPatterns[port]; !ok {
+					t.Errorf("expected port %d in %v", port, rule.PortPatterns)
+				}
+			}
+		})
+	}
+}
+
+func TestParseIPPattern(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "IPv4 prefix",
+			input:    "ip=10.0.0.0/8",
+			expected: []string{"10.0.0.0/8"},
+		},
+		{
+			name:     "bare IPv4 address",
+			input:    "ip=10.20.0.5",
+			expected: []string{"10.20.0.5/32"},
+		},
+		{
+			name:     "IPv6 prefix",
+			input:    "ip=fd00::/8",
+			expected: []string{"fd00::/8"},
+		},
+		{
+			name:     "bare IPv6 address",
+			input:    "ip=2001:db8::1",
+			expected: []string{"2001:db8::1/128"},
+		},
+		{
+			name:     "host bits are masked",
+			input:    "ip=10.1.2.3/8",
+			expected: []string{"10.0.0.0/8"},
+		},
+		{
+			name:     "multiple prefixes",
+			input:    "ip=10.0.0.0/8,192.168.0.0/16 port=5000",
+			expected: []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		{
+			name:        "prefix length out of range",
+			input:       "ip=10.0.0.0/33",
+			expectError: true,
+		},
+		{
+			name:        "not an address",
+			input:       "ip=example.com",
+			expectError: true,
+		},
+		{
+			name:        "empty",
+			input:       "ip=",
+			expectError: true,
+		},
+		{
+			name:        "zones are rejected",
+			input:       "ip=fe80::1%eth0",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseAllowRule(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(rule.IPPatterns) != len(tt.expected) {
+				t.Fatalf("expected IPPatterns %v, got %v", tt.expected, rule.IPPatterns)
+			}
+			for i, prefix := range rule.IPPatterns {
+				if prefix.String() != tt.expected[i] {
+					t.Errorf("expected prefix %q, got %q", tt.expected[i], prefix.String())
+				}
+			}
+		})
+	}
+}
+
 func TestParseAllowRule(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -1090,6 +1584,194 @@ func TestParseAllowRule(t *testing.T) {
 	}
 }
 
+func TestParseTimeBounds(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedFrom  string
+		expectedUntil string
+		expectError   bool
+	}{
+		{
+			name:          "until timestamp",
+			input:         "domain=pastebin.com until=2026-10-16T17:00:00+02:00",
+			expectedUntil: "2026-10-16T15:00:00Z",
+		},
+		{
+			name:          "from and until",
+			input:         "from=2026-10-16T09:00:00Z until=2026-10-16T17:00:00Z domain=pastebin.com",
+			expectedFrom:  "2026-10-16T09:00:00Z",
+			expectedUntil: "2026-10-16T17:00:00Z",
+		},
+		{
+			name:        "until before from",
+			input:       "domain=pastebin.com from=2026-10-16T17:00:00Z until=2026-10-16T09:00:00Z",
+			expectError: true,
+		},
+		{
+			name:        "until equal to from",
+			input:       "domain=pastebin.com from=2026-10-16T17:00:00Z until=2026-10-16T17:00:00Z",
+			expectError: true,
+		},
+		{
+			name:        "given twice",
+			input:       "domain=pastebin.com until=1h until=2h",
+			expectError: true,
+		},
+		{
+			name:        "negative duration",
+			input:       "domain=pastebin.com until=-1h",
+			expectError: true,
+		},
+		{
+			name:        "date without time",
+			input:       "domain=pastebin.com until=2026-10-16",
+			expectError: true,
+		},
+		{
+			name:        "empty",
+			input:       "domain=pastebin.com until=",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseAllowRule(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			format := func(t time.Time) string {
+				if t.IsZero() {
+					return ""
+				}
+				return t.UTC().Format(time.RFC3339)
+			}
+			if got := format(rule.From); got != tt.expectedFrom {
+				t.Errorf("expected From %q, got %q", tt.expectedFrom, got)
+			}
+			if got := format(rule.Until); got != tt.expectedUntil {
+				t.Errorf("expected Until %q, got %q", tt.expectedUntil, got)
+			}
+		})
+	}
+
+	t.Run("relative duration", func(t *testing.T) {
+		before := time.Now()
+		rule, err := parseAllowRule("domain=pastebin.com until=2h30m")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		after := time.Now()
+
+		if rule.Until.Before(before.Add(150*time.Minute)) || rule.Until.After(after.Add(150*time.Minute)) {
+			t.Errorf("expected Until 2h30m from now, got %v", rule.Until)
+		}
+		if !rule.From.IsZero() {
+			t.Errorf("expected no From, got %v", rule.From)
+		}
+	})
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    Limit
+		expectError bool
+	}{
+		{
+			name:     "rate per minute",
+			input:    "domain=api.example.com rate=30/m",
+			expected: Limit{Rate: 30, Interval: time.Minute},
+		},
+		{
+			name:     "rate per second",
+			input:    "rate=5/s domain=api.example.com",
+			expected: Limit{Rate: 5, Interval: time.Second},
+		},
+		{
+			name:     "quota",
+			input:    "domain=api.example.com quota=500",
+			expected: Limit{Quota: 500},
+		},
+		{
+			name:     "rate and quota per host",
+			input:    "domain=*.example.com rate=1/h quota=10 per=host",
+			expected: Limit{Rate: 1, Interval: time.Hour, Quota: 10, PerHost: true},
+		},
+		{
+			name:     "per rule",
+			input:    "domain=*.example.com quota=10 per=rule",
+			expected: Limit{Quota: 10},
+		},
+		{
+			name:        "per without a limit",
+			input:       "domain=*.example.com per=host",
+			expectError: true,
+		},
+		{
+			name:        "unknown per",
+			input:       "domain=*.example.com quota=1 per=path",
+			expectError: true,
+		},
+		{
+			name:        "rate without unit",
+			input:       "domain=api.example.com rate=30",
+			expectError: true,
+		},
+		{
+			name:        "rate with unknown unit",
+			input:       "domain=api.example.com rate=30/d",
+			expectError: true,
+		},
+		{
+			name:        "zero rate",
+			input:       "domain=api.example.com rate=0/s",
+			expectError: true,
+		},
+		{
+			name:        "zero quota",
+			input:       "domain=api.example.com quota=0",
+			expectError: true,
+		},
+		{
+			name:        "rate given twice",
+			input:       "domain=api.example.com rate=1/s rate=2/s",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseAllowRule(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rule.Limit != tt.expected {
+				t.Errorf("expected Limit %+v, got %+v", tt.expected, rule.Limit)
+			}
+		})
+	}
+}
+
 func TestReadmeExamples(t *testing.T) {
 	logger := slog.Default()
 
@@ -1246,7 +1928,7 @@ func TestReadmeExamples(t *testing.T) {
 			// Test each case
 			for i, tc := range tt.testCases {
 				t.Run(fmt.Sprintf("case_%d_%s_%s", i, tc.method, tc.url), func(t *testing.T) {
-					result := engine.matches(rule, tc.method, tc.url)
+					result := engine.matches(rule, Request{Method: tc.method, URL: tc.url})
 					if result != tc.expected {
 						t.Errorf("Rule %q with method %q and URL %q: expected %v, got %v",
 							tt.allowRule, tc.method, tc.url, tc.expected, result)
@@ -0,0 +1,113 @@
+package rulesengine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StructuredRule is a rule written as an object in the config file, with metadata the compact grammar can't
+// carry:
+//
+//   - id: github-issues
+//     description: The agent triages issues.
+//     tags: [team-platform]
+//     methods: [GET, POST]
+//     domains: [api.github.com]
+//     paths: [/repos/coder/*/issues/*]
+//     expires: 2026-12-31T00:00:00Z
+//
+// It compiles to the same rules as the equivalent spec, here `method=GET,POST domain=api.github.com
+// path=/repos/coder/*/issues/*`. A rule can only have one domain, so an object with several domains compiles to
+// one rule per domain.
+type StructuredRule struct {
+	ID          string   `yaml:"id"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Methods     []string `yaml:"methods"`
+	Domains     []string `yaml:"domains"`
+	Paths       []string `yaml:"paths"`
+	// From and Expires bound when the rule matches, like `from=` and `until=`.
+	From    time.Time `yaml:"from"`
+	Expires time.Time `yaml:"expires"`
+}
+
+// Specs returns the specs the rule compiles to, one per domain.
+func (s StructuredRule) Specs() ([]string, error) {
+	if len(s.Methods) == 0 && len(s.Domains) == 0 && len(s.Paths) == 0 {
+		return nil, errors.New("rule needs at least one of methods, domains or paths")
+	}
+
+	var method, path string
+	if len(s.Methods) > 0 {
+		if err := checkStructuredValues("methods", s.Methods); err != nil {
+			return nil, err
+		}
+		method = "method=" + strings.Join(s.Methods, ",")
+	}
+	if len(s.Paths) > 0 {
+		if err := checkStructuredValues("paths", s.Paths); err != nil {
+			return nil, err
+		}
+		path = "path=" + strings.Join(s.Paths, ",")
+	}
+
+	if len(s.Domains) == 0 {
+		return []string{joinKeys(method, path)}, nil
+	}
+	if err := checkStructuredValues("domains", s.Domains); err != nil {
+		return nil, err
+	}
+	specs := make([]string, 0, len(s.Domains))
+	for _, domain := range s.Domains {
+		specs = append(specs, joinKeys(method, "domain="+domain, path))
+	}
+	return specs, nil
+}
+
+func joinKeys(keys ...string) string {
+	return strings.Join(slices.DeleteFunc(keys, func(k string) bool { return k == "" }), " ")
+}
+
+// Compile parses the rule into allow Rules carrying its metadata. Callers compiling deny rules flip Rule.Deny
+// afterwards.
+func (s StructuredRule) Compile() ([]Rule, error) {
+	specs, err := s.Specs()
+	if err != nil {
+		return nil, err
+	}
+	if !s.From.IsZero() && !s.Expires.IsZero() && !s.Expires.After(s.From) {
+		return nil, errors.New("expires must be after from")
+	}
+
+	rules := make([]Rule, 0, len(specs))
+	for _, spec := range specs {
+		r, err := parseAllowRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.ID = s.ID
+		r.Description = s.Description
+		r.Tags = s.Tags
+		r.From = s.From
+		r.Until = s.Expires
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkStructuredValues makes sure every value of a list is a single value of the compact grammar, so that a
+// value like `example.com path=/admin` can't add keys to the compiled rule.
+func checkStructuredValues(field string, values []string) error {
+	for _, v := range values {
+		if v == "" {
+			return fmt.Errorf("%s: empty value", field)
+		}
+		if strings.ContainsAny(v, ", \t\n") {
+			return fmt.Errorf("%s: %q must be a single value, without commas or whitespace", field, v)
+		}
+	}
+	return nil
+}
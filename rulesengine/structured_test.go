@@ -0,0 +1,184 @@
+package rulesengine
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStructuredRuleSpecs(t *testing.T) {
+	tests := []struct {
+		name          string
+		rule          StructuredRule
+		expectedSpecs []string
+		expectedError string
+	}{
+		{
+			name:          "domain only",
+			rule:          StructuredRule{Domains: []string{"github.com"}},
+			expectedSpecs: []string{"domain=github.com"},
+		},
+		{
+			name: "all keys",
+			rule: StructuredRule{
+				Methods: []string{"GET", "POST"},
+				Domains: []string{"api.github.com"},
+				Paths:   []string{"/repos/*", "/user"},
+			},
+			expectedSpecs: []string{"method=GET,POST domain=api.github.com path=/repos/*,/user"},
+		},
+		{
+			name: "one spec per domain",
+			rule: StructuredRule{
+				Methods: []string{"GET"},
+				Domains: []string{"registry.npmjs.org", "registry.yarnpkg.com"},
+			},
+			expectedSpecs: []string{"method=GET domain=registry.npmjs.org", "method=GET domain=registry.yarnpkg.com"},
+		},
+		{
+			name:          "no domain",
+			rule:          StructuredRule{Paths: []string{"/admin/*"}},
+			expectedSpecs: []string{"path=/admin/*"},
+		},
+		{
+			name:          "nothing to match",
+			rule:          StructuredRule{ID: "empty"},
+			expectedError: "rule needs at least one of methods, domains or paths",
+		},
+		{
+			name:          "empty value",
+			rule:          StructuredRule{Methods: []string{""}},
+			expectedError: "methods: empty value",
+		},
+		{
+			name:          "value adding a key",
+			rule:          StructuredRule{Domains: []string{"example.com path=/admin"}},
+			expectedError: `domains: "example.com path=/admin" must be a single value`,
+		},
+		{
+			name:          "several values in one",
+			rule:          StructuredRule{Paths: []string{"/a,/b"}},
+			expectedError: `paths: "/a,/b" must be a single value`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := tt.rule.Specs()
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedSpecs, specs)
+		})
+	}
+}
+
+func TestStructuredRuleCompilesLikeSpec(t *testing.T) {
+	expires := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	structured := StructuredRule{
+		ID:          "github-issues",
+		Description: "The agent triages issues.",
+		Tags:        []string{"team-platform"},
+		Methods:     []string{"GET", "POST"},
+		Domains:     []string{"api.github.com"},
+		Paths:       []string{"/repos/coder/*/issues/*"},
+		Expires:     expires,
+	}
+
+	rules, err := structured.Compile()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	fromSpec, err := ParseAllowSpecs([]string{"method=GET,POST domain=api.github.com path=/repos/coder/*/issues/*"})
+	require.NoError(t, err)
+
+	// Apart from the metadata, it is the rule the spec parses to.
+	expected := fromSpec[0]
+	expected.ID = "github-issues"
+	expected.Description = "The agent triages issues."
+	expected.Tags = []string{"team-platform"}
+	expected.Until = expires
+	require.Equal(t, expected, rules[0])
+
+	_, err = StructuredRule{Domains: []string{"test..com"}}.Compile()
+	require.ErrorContains(t, err, "failed to parse domain")
+
+	_, err = StructuredRule{Domains: []string{"example.com"}, From: expires, Expires: expires.Add(-time.Hour)}.Compile()
+	require.ErrorContains(t, err, "expires must be after from")
+}
+
+func TestStructuredRuleResult(t *testing.T) {
+	allow, err := StructuredRule{
+		ID:      "npm",
+		Tags:    []string{"team-web"},
+		Domains: []string{"registry.npmjs.org"},
+	}.Compile()
+	require.NoError(t, err)
+	deny, err := StructuredRule{
+		ID:      "no-tarballs",
+		Tags:    []string{"security"},
+		Domains: []string{"registry.npmjs.org"},
+		Paths:   []string{"/*/-/*"},
+	}.Compile()
+	require.NoError(t, err)
+	deny[0].Deny = true
+	plain, err := ParseAllowSpecs([]string{"domain=github.com"})
+	require.NoError(t, err)
+
+	engine := NewRuleEngine(append(append(allow, deny...), plain...), slog.Default())
+
+	result := engine.Evaluate("GET", "https://registry.npmjs.org/left-pad")
+	require.True(t, result.Allowed)
+	require.Equal(t, "domain=registry.npmjs.org", result.Rule)
+	require.Equal(t, "npm", result.RuleID)
+	require.Equal(t, []string{"team-web"}, result.RuleTags)
+
+	result = engine.Evaluate("GET", "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz")
+	require.False(t, result.Allowed)
+	require.Equal(t, "no-tarballs", result.RuleID)
+	require.Equal(t, []string{"security"}, result.RuleTags)
+
+	result = engine.Evaluate("GET", "https://github.com/")
+	require.True(t, result.Allowed)
+	require.Empty(t, result.RuleID)
+	require.Empty(t, result.RuleTags)
+}
+
+func TestRuleExpires(t *testing.T) {
+	expires := time.Date(2026, 10, 16, 17, 0, 0, 0, time.UTC)
+	rules, err := StructuredRule{Domains: []string{"pastebin.com"}, Expires: expires}.Compile()
+	require.NoError(t, err)
+	engine := NewRuleEngine(rules, slog.Default())
+
+	request := func(at time.Time) Request {
+		return Request{Method: "GET", URL: "https://pastebin.com/raw/abc", Time: at}
+	}
+
+	require.True(t, engine.EvaluateRequest(request(expires.Add(-time.Minute))).Allowed)
+	require.False(t, engine.EvaluateRequest(request(expires)).Allowed)
+	require.False(t, engine.EvaluateRequest(request(expires.Add(time.Hour))).Allowed)
+
+	// Without a time, the request is made now.
+	for _, tt := range []struct {
+		expires time.Time
+		allowed bool
+	}{
+		{expires: time.Now().Add(-time.Hour), allowed: false},
+		{expires: time.Now().Add(time.Hour), allowed: true},
+	} {
+		rules, err := StructuredRule{Domains: []string{"pastebin.com"}, Expires: tt.expires}.Compile()
+		require.NoError(t, err)
+		engine := NewRuleEngine(rules, slog.Default())
+		require.Equal(t, tt.allowed, engine.Evaluate("GET", "https://pastebin.com/").Allowed)
+	}
+
+	explanation := engine.ExplainRequest(request(expires))
+	require.Len(t, explanation.Rules, 1)
+	require.False(t, explanation.Rules[0].Matched)
+	require.Equal(t, "until", explanation.Rules[0].Mismatch.Key)
+	require.Equal(t, "until: rule expired", explanation.Rules[0].Mismatch.String())
+}
@@ -106,6 +106,8 @@ func (cm *CertificateManager) getTLSConfig() *tls.Config {
 	return &tls.Config{
 		GetCertificate: cm.getCertificate,
 		MinVersion:     tls.VersionTLS12,
+		// Clients that speak HTTP/2, like gRPC clients, get it; the proxy serves each stream as a request.
+		NextProtos: []string{"h2", "http/1.1"},
 	}
 }
 